@@ -0,0 +1,245 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/base64"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "github.com/satori/go.uuid"
+)
+
+/*
+==============================================================================
+ Personal Access Tokens
+------------------------------------------------------------------------------
+ Scripts and integrations can't sign in and juggle cookies, so a user can
+ create personal access tokens for them at /users/me/tokens.  A script
+ sends its token in an "Authorization: Bearer pim_..." header.
+
+ Each token has scopes limiting what it can do:
+    read         - anything that changes nothing (routes marked ReadOnly)
+    tasks:write  - read, and change tasks too
+ No token can manage the account itself (routes marked AccountOnly) - that
+ takes signing in.
+
+ The token is only shown when it is created.  Like refresh tokens only a
+ hash of it is stored, under an id of its own that lists and revocations
+ refer to.  Tokens last until they are revoked.
+============================================================================*/
+const (
+    scopeRead       = "read"
+    scopeTasksWrite = "tasks:write"
+)
+
+var tokenScopes = []string{scopeRead, scopeTasksWrite}
+
+// every personal access token starts with this so it is easy to tell one
+// from an access token (or spot one that was pasted somewhere it shouldn't)
+const userTokenPrefix = "pim_"
+
+// how often we note when a token was last used
+const userTokenUseInterval = time.Minute
+
+type UserToken struct {
+    Id         string     // public id of the token
+    UserId     string     // user the token acts for
+    Name       string     // what the user calls it, e.g. "nightly backup"
+    Hash       string     // hash of the token itself (see sessionId())
+    Scopes     []string
+    CreatedAt  time.Time
+    LastUsedAt *time.Time // nil if never used
+}
+
+// Allows: true if the token has the scope asked for (writing tasks
+// includes reading them)
+func (k *UserToken) Allows(scope string) bool {
+    for _, s := range k.Scopes {
+        if s == scope || (scope == scopeRead && s == scopeTasksWrite) {
+            return true
+        }
+    }
+    return false
+}
+
+// validTokenScope: true if the scope is one we know
+func validTokenScope(scope string) bool {
+    for _, s := range tokenScopes {
+        if s == scope {
+            return true
+        }
+    }
+    return false
+}
+
+/*
+==============================================================================
+ NewUserToken()
+------------------------------------------------------------------------------
+ Inputs:  u      *User      - user the token acts for
+          name   string     - what to call it
+          scopes []string   - what it may do (already checked)
+ Returns:        string     - the token itself, to show the user just once
+                 *UserToken - the token as stored
+                 error      - if the token can't be made or saved
+============================================================================*/
+func NewUserToken(u *User, name string, scopes []string) (string, *UserToken, error) {
+    b := make([]byte, 32)
+    if _, err := rand.Read(b); err != nil {
+        return "", nil, err
+    }
+    secret := userTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+    k := &UserToken{Id:uuid.NewV4().String(), UserId:u.GetId(), Name:name, Hash:sessionId(secret),
+                    Scopes:scopes, CreatedAt:time.Now().UTC()}
+    if err := repo.Storage().TokenSave(k); err != nil {
+        return "", nil, err
+    }
+    return secret, k, nil
+}
+
+// the scope a personal access token needs for a route ("" if tokens can't
+// use it at all)
+func (route Route) TokenScope() string {
+    if route.AccountOnly {
+        return ""
+    }
+    if route.ReadOnly {
+        return scopeRead
+    }
+    return scopeTasksWrite
+}
+
+/*
+==============================================================================
+ userCheckBearerToken()
+------------------------------------------------------------------------------
+ Inputs:  w      ResponseWriter - response to write errors into
+          bearer string         - the token from the header
+          scope  string         - scope the route needs (see TokenScope())
+ Returns:        *User          - authenticated user, nil if an error was
+                                  written
+
+ Authenticate a request by a bearer token.  Personal access tokens must
+ have the scope the route needs.  Anything else is taken to be an access
+ token, just as if it had come in the "token" cookie.
+============================================================================*/
+func userCheckBearerToken(w http.ResponseWriter, bearer string, scope string) *User {
+    if !strings.HasPrefix(bearer, userTokenPrefix) {
+        username, errCode := UserValidateAuthToken(bearer)
+        if errCode != success {
+            errorResponse(w, pimErr(errCode))
+            return nil
+        }
+        user := repo.Users().FindByEmail(username)
+        if user == nil {
+            log.Printf("userCheckBearerToken() - suspicious activity - valid token with invalid user <%s>\n", username)
+            errorResponse(w, pimErr(authFail))
+        }
+        return user
+    }
+
+    k, err := repo.Storage().TokenLoad(sessionId(bearer))
+    if err != nil {
+        log.Printf("userCheckBearerToken() - unable to look up token: %s\n", err)
+        errorResponse(w, pimErr(authErr))
+        return nil
+    }
+    var user *User
+    if k != nil {
+        user = repo.Users().FindById(k.UserId)
+    }
+    if user == nil {
+        errorResponse(w, pimErr(authToken))
+        return nil
+    }
+    if scope == "" || !k.Allows(scope) {
+        errorResponse(w, pimErr(authScope))
+        return nil
+    }
+
+    now := time.Now().UTC()
+    if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= userTokenUseInterval {
+        k.LastUsedAt = &now
+        if err := repo.Storage().TokenSave(k); err != nil {
+            log.Printf("userCheckBearerToken() - unable to note use of token %s: %s\n", k.Id, err)
+        }
+    }
+    return user
+}
+
+// UserTokenAuthenticator: like UserAuthenticator, but also accepts bearer
+// tokens with the scope the route needs
+func UserTokenAuthenticator(next http.Handler, scope string) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        auth := r.Header.Get("Authorization")
+        if !strings.HasPrefix(auth, "Bearer ") {
+            UserAuthenticator(next).ServeHTTP(w, r)
+            return
+        }
+        user := userCheckBearerToken(w, strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")), scope)
+        if user == nil {
+            return
+        }
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "user", user)))
+    })
+}
+
+/*
+==============================================================================
+ tokenStore
+------------------------------------------------------------------------------
+ Personal access tokens kept in memory, for data mappers (like YAML) that
+ have nowhere better to keep them.  They are lost when the server restarts.
+ Tokens are copied in and out since requests holding only a read lock may
+ note their use.
+============================================================================*/
+type tokenStore struct {
+    mu     sync.Mutex
+    tokens map[string]UserToken // hash -> token
+}
+
+func newTokenStore() *tokenStore {
+    return &tokenStore{tokens:make(map[string]UserToken)}
+}
+
+func (ts *tokenStore) save(k *UserToken) {
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+    ts.tokens[k.Hash] = *k
+}
+
+func (ts *tokenStore) load(hash string) *UserToken {
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+    k, found := ts.tokens[hash]
+    if !found {
+        return nil
+    }
+    return &k
+}
+
+func (ts *tokenStore) loadAll(userId string) []*UserToken {
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+    var found []*UserToken
+    for _, k := range ts.tokens {
+        if k.UserId == userId {
+            copied := k
+            found = append(found, &copied)
+        }
+    }
+    sort.Slice(found, func(i, j int) bool {
+        return found[i].CreatedAt.Before(found[j].CreatedAt)
+    })
+    return found
+}
+
+func (ts *tokenStore) delete(k *UserToken) {
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+    delete(ts.tokens, k.Hash)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// a personal access token is shown once, authenticates a script only for
+// the scopes it was given and stops working once it is revoked
+func TestUserTokens(t *testing.T) {
+	u, tdm := testRepository(t)
+
+	create := func(body string) (*httptest.ResponseRecorder, UserTokenJSON) {
+		var j UserTokenJSON
+		w := routeRequest(UserTokenCreate, u, "POST", nil, body)
+		json.NewDecoder(strings.NewReader(w.Body.String())).Decode(&j)
+		return w, j
+	}
+	bearer := func(token string, scope string) int {
+		r := httptest.NewRequest("GET", "/tasks", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		UserTokenAuthenticator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if UserFromRequest(w, r) != u {
+				t.Error("Expected the token to act for its user")
+			}
+		}), scope).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, body := range []string{`{"name":"backup","scopes":[]}`, `{"name":"","scopes":["read"]}`, `{"name":"backup","scopes":["admin"]}`} {
+		if w, _ := create(body); w.Code != http.StatusUnprocessableEntity {
+			t.Error("Expected", body, "to be refused but got", w.Code)
+		}
+	}
+
+	w, reader := create(`{"name":"backup","scopes":["read","read"]}`)
+	if w.Code != http.StatusCreated || !strings.HasPrefix(reader.Token, userTokenPrefix) || len(reader.Scopes) != 1 {
+		t.Fatal("Expected a read token to be created but got", w.Code, w.Body.String())
+	}
+	_, writer := create(`{"name":"sync","scopes":["tasks:write"]}`)
+
+	if k, _ := tdm.TokenLoad(sessionId(reader.Token)); k == nil || k.Hash == reader.Token {
+		t.Error("Expected only a hash of the token to be stored")
+	}
+	if code := bearer(reader.Token, scopeRead); code != http.StatusOK {
+		t.Error("Expected a read token to be allowed to read but got", code)
+	}
+	if code := bearer(reader.Token, scopeTasksWrite); code != http.StatusForbidden {
+		t.Error("Expected a read token to be refused changing tasks but got", code)
+	}
+	if code := bearer(writer.Token, scopeRead); code != http.StatusOK {
+		t.Error("Expected a tasks:write token to be allowed to read but got", code)
+	}
+	if code := bearer(writer.Token, ""); code != http.StatusForbidden {
+		t.Error("Expected a token to be refused managing the account but got", code)
+	}
+	if code := bearer(userTokenPrefix+"guess", scopeRead); code != http.StatusUnauthorized {
+		t.Error("Expected an unknown token to be refused but got", code)
+	}
+
+	w = routeRequest(UserTokenIndex, u, "GET", nil, "")
+	var listed []UserTokenJSON
+	json.NewDecoder(w.Body).Decode(&listed)
+	if len(listed) != 2 || listed[0].Id != reader.Id || listed[0].Token != "" || listed[0].LastUsedAt == nil {
+		t.Fatal("Expected both tokens to be listed without their secrets but got", w.Body.String())
+	}
+
+	w = routeRequest(UserTokenRevoke, u, "DELETE", map[string]string{"tokenId": reader.Id}, "")
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected the token to be revoked but got", w.Code)
+	}
+	if code := bearer(reader.Token, scopeRead); code != http.StatusUnauthorized {
+		t.Error("Expected a revoked token to be refused but got", code)
+	}
+	w = routeRequest(UserTokenRevoke, u, "DELETE", map[string]string{"tokenId": reader.Id}, "")
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected revoking twice to find nothing but got", w.Code)
+	}
+}
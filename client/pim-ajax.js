@@ -65,3 +65,7 @@ function ajaxPut(xmlhttp, url, payload) {
   ajaxPayload(xmlhttp, url, payload, "PUT");
 }
 
+function ajaxPatch(xmlhttp, url, payload) {
+  ajaxPayload(xmlhttp, url, payload, "PATCH");
+}
+
@@ -93,6 +93,7 @@ function timeToForm(date) {
   * tasksCompleteURL - finds all complete tasks in the system
   * tasksFindURL - finds all tasks at a certain date
   * undoURL - calls the server to undo the most recent action
+  * redoURL - calls the server to redo the most recently undone action
   * signinURL - calls the server to signin
 ========================================================================*/
 
@@ -121,6 +122,10 @@ function undoURL() {
   return makeURL("undo")
 }
 
+function redoURL() {
+  return makeURL("redo")
+}
+
 function signinURL() {
   return makeURL("signin")
 }
@@ -133,6 +138,14 @@ function signreupURL() {
   return makeURL("signreup")
 }
 
+function tokenRefreshURL() {
+  return makeURL("token/refresh")
+}
+
+function signoutURL() {
+  return makeURL("signout")
+}
+
 function tasksURL(id = "") {
   var rest = "tasks";
   if (id) {
@@ -193,9 +206,8 @@ function tasksFindDateURL(fromDate, toDate, tags = null) {
   return makeURL(rest, tags) // TBD - this won't work - makeURL needs to add tags without ? every time
 }
 
-function tasksReorderURL(moveId, beforeId) {
-  console.log(beforeId)
-  return makeURL("reorder/" + moveId + "?targetId=" + beforeId)  
+function tasksReorderURL(moveId) {
+  return makeURL("tasks/" + moveId + "/position")  
 }
 
 function tagsURL() {
@@ -215,7 +227,7 @@ function reorderTask(move, before) {
       }
     }
   }
-  ajaxGet(ajax, tasksReorderURL(move.getId(), before.getId()))
+  ajaxPatch(ajax, tasksReorderURL(move.getId()), {before: before.getId()})
 }
 
 /*
@@ -239,10 +251,10 @@ function serverStatus() {
 /*
 =========================================================================
  cmdUndo
+ cmdRedo
 -------------------------------------------------------------------------
- For now the only exposed function on the server is to undo.  This
- section of the code may be expanded to add redo() or other command
- system functions over time.
+ The server keeps an undo and a redo stack for each user.  These
+ functions call the server to undo or redo the most recent action.
 ========================================================================*/
 function cmdUndo(rawResponseCallback = null) {
   ajax = ajaxObj();
@@ -271,15 +283,45 @@ function cmdUndo(rawResponseCallback = null) {
   ajaxGet(ajax, undoURL());    
 }
 
+function cmdRedo(rawResponseCallback = null) {
+  ajax = ajaxObj();
+  ajax.onreadystatechange = function() {
+    if (this.readyState == 4) {
+      if (rawResponseCallback != null) {
+        rawResponseCallback(this.status, this.responseText)
+      }
+      pimAuthCheck(this.status) // redirects on auth failure         
+      if (this.status == 200) {
+        let response = JSON.parse(this.responseText)
+        if (response && response.code == 14) {
+          pimShowError("Nothing to Redo");
+        }
+        else {
+          forceRefresh()
+        }
+      }
+      else {
+        console.log("cmdRedo(): failed http response: " + this.status);
+        pimShowError(this.responseText);
+      }
+    }
+  };
+  ajaxSimple(ajax, redoURL(), "POST");
+}
+
 /*
 =========================================================================
  userAuth
  userSignin
  userSignUp
  userSignReup
+ userTokenRefresh
+ userSignout
 -------------------------------------------------------------------------
  Call server to authenticate or add a new user.  Tokens are automatically
- set into a cookie.
+ set into a cookie.  The access token is short-lived so pages refresh it
+ with the session's refresh token before it expires, and signing out ends
+ the session on the server.
 ========================================================================*/
 function userAuth(url, email, password, redirectAuth, redirectFail, rawResponseCallback = null) {
   ajax = ajaxObj();
@@ -332,6 +374,14 @@ function userSignReup(rawResponseCallback = null) {
   userAuth(signreupURL(), null, null, null, "index.html", rawResponseCallback)  
 }
 
+function userTokenRefresh(rawResponseCallback = null) {
+  userAuth(tokenRefreshURL(), null, null, null, "index.html", rawResponseCallback)
+}
+
+function userSignout(rawResponseCallback = null) {
+  userAuth(signoutURL(), null, null, "index.html", "index.html", rawResponseCallback)
+}
+
 /*
 =========================================================================
  Write Functions for Tasks
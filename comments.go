@@ -0,0 +1,162 @@
+package main
+
+import (
+    "log"
+    "strings"
+    "sync"
+    "time"
+)
+
+/*
+==============================================================================
+ Comments and Activity
+------------------------------------------------------------------------------
+ Tasks can be shared by several users, so collaborators need a way to talk
+ about a task and to see what has happened to it.
+
+ A TaskComment is a note a user leaves on a task.  Only its author can edit
+ it, and an edited comment remembers when it was last edited.
+
+ A TaskActivity is an entry in the history of a task, recorded for every
+ command that succeeds (see CommandDo(), CommandUndo() and CommandRedo()).
+ It is fed from the command's Log() so it says what the command was (e.g.
+ EXEC-CREATE, EXEC-STATE, EXEC-TAG, UNDO-DELETE) and who ran it.  The
+ commands of a transaction are recorded one by one against their own tasks.
+
+ Both are kept through the user's TaskDataMapper.  Neither is removed when
+ a task is deleted, so undoing the delete brings the task back with its
+ comments and its history (which now shows the delete and the undo).
+ Activity errors are logged but never fail the command itself, just as
+ journal errors don't.
+============================================================================*/
+type TaskComment struct {
+    Id        int        // assigned by the mapper when first saved (0 = new)
+    TaskId    string     // task the comment is on
+    UserId    string     // author of the comment
+    Text      string
+    CreatedAt time.Time
+    EditedAt  *time.Time // when the comment was last edited (nil = never)
+}
+
+type TaskActivity struct {
+    Id        int       // assigned by the mapper when first saved (0 = new)
+    TaskId    string    // task the command acted upon
+    UserId    string    // user who ran the command
+    Action    string    // what the command did, e.g. EXEC-TAG or UNDO-CREATE
+    Log       string    // the full Log() of the command
+    CreatedAt time.Time
+}
+
+func NewTaskComment(t *Task, u *User, text string) *TaskComment {
+    return &TaskComment{TaskId:t.GetId(), UserId:u.GetId(), Text:text, CreatedAt:time.Now()}
+}
+
+// Edit: change the text of the comment, noting when
+func (c *TaskComment) Edit(text string, when time.Time) {
+    c.Text = text
+    c.EditedAt = &when
+}
+
+// commandAction: the verb and kind of command from a Log() line, e.g.
+// CMD-EXEC-TAG-Pay bills-SUCCESS is EXEC-TAG (task names may have dashes
+// but verbs and kinds never do)
+func commandAction(line string) string {
+    fields := strings.SplitN(strings.TrimPrefix(line, "CMD-"), "-", 3)
+    if len(fields) < 2 {
+        return line
+    }
+    return fields[0] + "-" + fields[1]
+}
+
+// commandActivity: the activity entries for a command that has just run
+func commandActivity(u *User, cmd Command, when time.Time) []*TaskActivity {
+    if cc, ok := cmd.(*compositeCmd); ok {
+        var entries []*TaskActivity
+        for _, child := range cc.cmds {
+            entries = append(entries, commandActivity(u, child, when)...)
+        }
+        return entries
+    }
+    t := cmd.Target()
+    if t == nil || t.IsMemoryOnly() {
+        return nil
+    }
+    line := strings.TrimSpace(cmd.Log())
+    return []*TaskActivity{&TaskActivity{TaskId:t.GetId(), UserId:u.GetId(), Action:commandAction(line), Log:line, CreatedAt:when}}
+}
+
+// recordActivity: add a command that succeeded to the activity of its tasks
+func recordActivity(u *User, cmd Command) {
+    if u.persist == nil {
+        return
+    }
+    for _, a := range commandActivity(u, cmd, time.Now()) {
+        if err := u.persist.ActivitySave(a); err != nil {
+            log.Printf("recordActivity(): unable to save activity: %s\n", err)
+        }
+    }
+}
+
+/*
+==============================================================================
+ taskDiscussion
+------------------------------------------------------------------------------
+ Comments and activity kept in memory, for data mappers (like YAML) that
+ have nowhere better to keep them.  They start empty each time the server
+ is started.
+============================================================================*/
+type taskDiscussion struct {
+    mu       sync.Mutex
+    lastId   int
+    comments map[string][]*TaskComment  // task id -> comments, oldest first
+    activity map[string][]*TaskActivity // task id -> activity, oldest first
+}
+
+func newTaskDiscussion() *taskDiscussion {
+    return &taskDiscussion{comments:make(map[string][]*TaskComment), activity:make(map[string][]*TaskActivity)}
+}
+
+// saveComment: add a new comment or replace an edited one (we keep our own
+// copies so a comment only changes here when it is saved)
+func (d *taskDiscussion) saveComment(c *TaskComment) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    saved := *c
+    if c.Id == 0 {
+        d.lastId++
+        c.Id = d.lastId
+        saved.Id = c.Id
+        d.comments[c.TaskId] = append(d.comments[c.TaskId], &saved)
+        return
+    }
+    for i, curr := range d.comments[c.TaskId] {
+        if curr.Id == c.Id {
+            d.comments[c.TaskId][i] = &saved
+        }
+    }
+}
+
+func (d *taskDiscussion) loadComments(taskId string) []*TaskComment {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    var comments []*TaskComment
+    for _, c := range d.comments[taskId] {
+        copied := *c
+        comments = append(comments, &copied)
+    }
+    return comments
+}
+
+func (d *taskDiscussion) saveActivity(a *TaskActivity) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    d.lastId++
+    a.Id = d.lastId
+    d.activity[a.TaskId] = append(d.activity[a.TaskId], a)
+}
+
+func (d *taskDiscussion) loadActivity(taskId string) []*TaskActivity {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    return append([]*TaskActivity(nil), d.activity[taskId]...)
+}
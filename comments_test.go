@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// every command that succeeds shows up in the activity of its task, with
+// changes of state called out, and comments remember their edits
+func TestActivity(t *testing.T) {
+	mapper := NewTaskDataMapperYAML(filepath.Join(t.TempDir(), "tasks.yaml"))
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(mapper)
+	u := &User{id: "author", persist: mapper}
+
+	task := NewTask("Pay bills - electric")
+	master.AddChild(task)
+	if err := CommandCreateTask(u, task); err != nil {
+		t.Fatal("Unable to create task:", err)
+	}
+	if err := CommandTagTask(u, task, []string{"today"}, nil); err != nil {
+		t.Fatal("Unable to tag task:", err)
+	}
+	cmd := CommandModifyTaskBegin(task)
+	task.SetState(complete)
+	if err := CommandModifyTaskEnd(u, cmd, task); err != nil {
+		t.Fatal("Unable to complete task:", err)
+	}
+	if _, err := CommandUndo(u); err != nil {
+		t.Fatal("Unable to undo:", err)
+	}
+
+	entries, _ := mapper.ActivityLoad(task)
+	expected := []string{"EXEC-CREATE", "EXEC-TAG", "EXEC-STATE", "UNDO-STATE"}
+	if len(entries) != len(expected) {
+		t.Fatal("Expected activity", expected, "but found", len(entries), "entries")
+	}
+	for i, a := range entries {
+		if a.Action != expected[i] || a.UserId != "author" {
+			t.Errorf("Expected activity %d to be %s by author but found %s by %s", i, expected[i], a.Action, a.UserId)
+		}
+	}
+
+	c := NewTaskComment(task, u, "Paid online")
+	mapper.CommentSave(c)
+	c.Edit("Paid online, receipt in email", time.Now())
+	mapper.CommentSave(c)
+	comments, _ := mapper.CommentLoad(task)
+	if len(comments) != 1 || comments[0].Text != "Paid online, receipt in email" || comments[0].EditedAt == nil {
+		t.Error("Expected one edited comment but found", comments)
+	}
+}
+
+// failingCommentMapper: a YAML mapper that can be told to refuse to save
+// comments
+type failingCommentMapper struct {
+	*TaskDataMapperYAML
+	fail bool
+}
+
+func (m *failingCommentMapper) CommentSave(c *TaskComment) error {
+	if m.fail {
+		return errors.New("unable to save comment")
+	}
+	return m.TaskDataMapperYAML.CommentSave(c)
+}
+
+// an edit that can't be saved leaves the comment as it was
+func TestCommentUpdateFailedSave(t *testing.T) {
+	u, _ := testRepository(t)
+	mapper := &failingCommentMapper{TaskDataMapperYAML: NewTaskDataMapperYAML(filepath.Join(t.TempDir(), "tasks.yaml"))}
+	repo = NewTaskRepository(mapper)
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(mapper)
+	repo.SetMaster(master)
+	repo.SetUsers(Users{u})
+
+	task := NewTask("Pay bills - electric")
+	task.AddUser(u)
+	master.AddChild(task)
+	c := NewTaskComment(task, u, "Paid online")
+	mapper.CommentSave(c)
+	vars := map[string]string{"taskId": task.GetId(), "commentId": strconv.Itoa(c.Id)}
+
+	mapper.fail = true
+	if w := routeRequest(TaskCommentUpdate, u, "PUT", vars, `{"text":"Paid by check"}`); w.Code != http.StatusInternalServerError {
+		t.Error("Expected a failed save to answer 500 but got", w.Code)
+	}
+	comments, _ := mapper.CommentLoad(task)
+	if len(comments) != 1 || comments[0].Text != "Paid online" || comments[0].EditedAt != nil {
+		t.Error("Expected the comment to be unchanged after a failed save but found", comments[0])
+	}
+
+	mapper.fail = false
+	if w := routeRequest(TaskCommentUpdate, u, "PUT", vars, `{"text":"Paid by check"}`); w.Code != http.StatusOK {
+		t.Error("Expected the edit to be saved but got", w.Code)
+	}
+	comments, _ = mapper.CommentLoad(task)
+	if len(comments) != 1 || comments[0].Text != "Paid by check" || comments[0].EditedAt == nil {
+		t.Error("Expected the comment to be edited but found", comments[0])
+	}
+}
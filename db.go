@@ -45,6 +45,68 @@ func dbInsert(env *Env, sqlStr string, args ...interface{}) (int, error) {
     return id, err
 }
 
+/*
+===============================================================================
+ dbRunner
+-------------------------------------------------------------------------------
+ The parts of sql.DB and sql.Tx we use to run statements.  Code written
+ against a dbRunner can run its statements on their own against the DB or as
+ part of a transaction.
+=============================================================================*/
+type dbRunner interface {
+    Exec(query string, args ...interface{}) (sql.Result, error)
+    Query(query string, args ...interface{}) (*sql.Rows, error)
+    QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// same as dbExec but runs within the transaction (or DB) provided
+func dbTxExec(tx dbRunner, sqlStr string, args ...interface{}) (sql.Result, error) {
+    result, err := tx.Exec(sqlStr, args...)
+    if err != nil {
+        return nil, err
+    }
+    return result, err
+}
+
+// same as dbInsert but runs within the transaction (or DB) provided
+func dbTxInsert(tx dbRunner, sqlStr string, args ...interface{}) (int, error) {
+    var id int
+    err := tx.QueryRow(sqlStr, args...).Scan(&id)
+    if err == sql.ErrNoRows {
+        err = nil // ErrNoRows is expected from a single row insert
+    }
+    return id, err
+}
+
+/*
+===============================================================================
+ dbTransact()
+-------------------------------------------------------------------------------
+ Inputs:  env *Env                  - the DB to run the transaction against
+          fn  func(*sql.Tx) error   - runs all statements of the transaction
+ Returns:     error                 - error from fn, or from begin / commit
+
+ Run fn within a single transaction.  If fn returns an error, the transaction
+ is rolled back and that error is returned, otherwise it is committed.
+=============================================================================*/
+func dbTransact(env *Env, fn func(tx *sql.Tx) error) error {
+    tx, err := env.db.Begin()
+    if err != nil {
+        return err
+    }
+    err = fn(tx)
+    if err != nil {
+        tx.Rollback() // the error from fn is the one worth reporting
+        return err
+    }
+    return tx.Commit()
+}
+
+// dbNullString: store empty strings as NULL
+func dbNullString(s string) sql.NullString {
+    return sql.NullString{String:s, Valid:len(s) > 0}
+}
+
 func dbCreate(env *Env, dbName string) (sql.Result, error) {
     return dbExec(env, "CREATE DATABASE $1", dbName) 
 }
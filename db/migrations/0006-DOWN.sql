@@ -0,0 +1 @@
+DROP TABLE command_journal;
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE command_journal (
+	id SERIAL PRIMARY KEY,
+	user_id VARCHAR(36) NOT NULL,
+	record TEXT NOT NULL,
+	undone BOOLEAN DEFAULT FALSE,
+	created_at TIMESTAMP DEFAULT now(),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
@@ -0,0 +1 @@
+ALTER TABLE tasks ADD COLUMN version INT NOT NULL DEFAULT 1;
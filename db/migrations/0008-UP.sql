@@ -0,0 +1 @@
+ALTER TABLE tasks ADD COLUMN recurrence VARCHAR(1024);
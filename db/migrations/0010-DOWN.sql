@@ -0,0 +1,5 @@
+ALTER TABLE tasks
+	DROP COLUMN priority;
+
+ALTER TABLE task_parents
+	DROP COLUMN position;
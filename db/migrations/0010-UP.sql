@@ -0,0 +1,5 @@
+ALTER TABLE tasks
+	ADD COLUMN priority INT NOT NULL DEFAULT 0;
+
+ALTER TABLE task_parents
+	ADD COLUMN position INT NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE tasks
+	ADD COLUMN position INT NOT NULL DEFAULT 0;
@@ -0,0 +1,8 @@
+CREATE TABLE task_sessions (
+	task_id VARCHAR(36) NOT NULL,
+	started_at TIMESTAMP NOT NULL,
+	stopped_at TIMESTAMP,
+	user_id VARCHAR(36),
+	CONSTRAINT pk_tasksessions PRIMARY KEY (task_id, started_at),
+	FOREIGN KEY (task_id) REFERENCES tasks(id)
+);
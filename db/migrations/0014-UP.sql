@@ -0,0 +1,3 @@
+ALTER TABLE users
+	ADD COLUMN time_zone VARCHAR(64),
+	ADD COLUMN week_start INT NOT NULL DEFAULT 0;
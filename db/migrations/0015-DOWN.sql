@@ -0,0 +1,4 @@
+DROP INDEX tasks_search;
+
+ALTER TABLE tasks
+	DROP COLUMN search;
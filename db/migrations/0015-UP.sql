@@ -0,0 +1,4 @@
+ALTER TABLE tasks
+	ADD COLUMN search TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', name)) STORED;
+
+CREATE INDEX tasks_search ON tasks USING GIN (search);
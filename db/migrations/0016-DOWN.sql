@@ -0,0 +1,12 @@
+DROP INDEX tasks_search;
+
+ALTER TABLE tasks
+	DROP COLUMN search;
+
+ALTER TABLE tasks
+	DROP COLUMN notes;
+
+ALTER TABLE tasks
+	ADD COLUMN search TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', name)) STORED;
+
+CREATE INDEX tasks_search ON tasks USING GIN (search);
@@ -0,0 +1,12 @@
+ALTER TABLE tasks
+	ADD COLUMN notes TEXT NOT NULL DEFAULT '';
+
+DROP INDEX tasks_search;
+
+ALTER TABLE tasks
+	DROP COLUMN search;
+
+ALTER TABLE tasks
+	ADD COLUMN search TSVECTOR GENERATED ALWAYS AS (setweight(to_tsvector('english', name), 'A') || setweight(to_tsvector('english', notes), 'B')) STORED;
+
+CREATE INDEX tasks_search ON tasks USING GIN (search);
@@ -0,0 +1,3 @@
+DROP TABLE task_activity;
+
+DROP TABLE task_comments;
@@ -0,0 +1,23 @@
+CREATE TABLE task_comments (
+	id SERIAL PRIMARY KEY,
+	task_id VARCHAR(36) NOT NULL,
+	user_id VARCHAR(36) NOT NULL,
+	text TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT now(),
+	edited_at TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX task_comments_task ON task_comments (task_id);
+
+CREATE TABLE task_activity (
+	id SERIAL PRIMARY KEY,
+	task_id VARCHAR(36) NOT NULL,
+	user_id VARCHAR(36) NOT NULL,
+	action VARCHAR(64) NOT NULL,
+	log TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT now(),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX task_activity_task ON task_activity (task_id);
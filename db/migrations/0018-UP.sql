@@ -0,0 +1,7 @@
+CREATE TABLE user_sessions (
+	id CHAR(64) PRIMARY KEY,
+	user_id VARCHAR(36) NOT NULL,
+	created_at TIMESTAMP DEFAULT now(),
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
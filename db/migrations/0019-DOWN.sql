@@ -0,0 +1,4 @@
+DELETE FROM user_sessions WHERE kind <> 'refresh';
+
+ALTER TABLE user_sessions
+	DROP COLUMN kind;
@@ -0,0 +1,2 @@
+ALTER TABLE user_sessions
+	ADD COLUMN kind VARCHAR(16) NOT NULL DEFAULT 'refresh';
@@ -0,0 +1,12 @@
+DROP INDEX user_logins_created;
+
+DELETE FROM user_logins WHERE user_id IS NULL;
+
+ALTER TABLE user_logins
+	DROP COLUMN succeeded;
+
+ALTER TABLE user_logins
+	DROP COLUMN email;
+
+ALTER TABLE user_logins
+	ALTER COLUMN user_id SET NOT NULL;
@@ -0,0 +1,10 @@
+ALTER TABLE user_logins
+	ALTER COLUMN user_id DROP NOT NULL;
+
+ALTER TABLE user_logins
+	ADD COLUMN email VARCHAR(1024);
+
+ALTER TABLE user_logins
+	ADD COLUMN succeeded BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX user_logins_created ON user_logins (created_at);
@@ -0,0 +1,10 @@
+CREATE TABLE user_tokens (
+	id VARCHAR(36) PRIMARY KEY,
+	user_id VARCHAR(36) NOT NULL,
+	name VARCHAR(128) NOT NULL,
+	token_hash CHAR(64) NOT NULL UNIQUE,
+	scopes TEXT[] NOT NULL,
+	created_at TIMESTAMP DEFAULT now(),
+	last_used_at TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
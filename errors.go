@@ -21,6 +21,12 @@ const (
 	authBadEmail
 	authBadPW
 	deleteFailed
+	redoEmpty
+	versionConflict
+	notAuthor
+	authThrottled
+	authLocked
+	authScope
 )
 
 type PimError struct {
@@ -69,4 +75,10 @@ var pimErrors = PimErrors {
     PimError{ Code:authBadEmail,Msg:"pim: invalid email provided",     Response:http.StatusOK},
     PimError{ Code:authBadPW   ,Msg:"pim: insecure PW provided",       Response:http.StatusOK},
     PimError{ Code:deleteFailed,Msg:"pim: unable to delete task",      Response:http.StatusInternalServerError},    
+    PimError{ Code:redoEmpty,   Msg:"pim: nothing to redo",            Response:http.StatusOK},
+    PimError{ Code:versionConflict,Msg:"pim: task was changed by someone else", Response:http.StatusPreconditionFailed},
+    PimError{ Code:notAuthor,   Msg:"pim: only the author can change this", Response:http.StatusForbidden},
+    PimError{ Code:authThrottled,Msg:"pim: too many sign-in attempts - try again later", Response:http.StatusTooManyRequests},
+    PimError{ Code:authLocked,  Msg:"pim: account locked after repeated failed sign-ins - try again later", Response:http.StatusLocked},
+    PimError{ Code:authScope,   Msg:"pim: token not allowed to do this", Response:http.StatusForbidden},
 }
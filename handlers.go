@@ -1,11 +1,15 @@
 package main
 
 import (
+    "encoding/csv"
     "encoding/json"
+    "strconv"
     "net/http"
     "io"
     "io/ioutil"
     "fmt"
+    "log"
+    "math"
     "time"
     "strings"
     "github.com/gorilla/mux"
@@ -27,6 +31,40 @@ func UserIfOn(w http.ResponseWriter, r *http.Request) *User {
     return UserFromRequest(w, r)
 }
 
+// findTask: find a task anywhere in the hierarchy, but only if it is
+// owned by the user
+func findTask(id string, u *User) *Task {
+    t := repo.Master().FindDescendent(id)
+    if t == nil || (u != nil && !t.UserHasAccess(u)) {
+        return nil
+    }
+    return t
+}
+
+// taskETag: the ETag of a task is its version, so it changes on every save
+func taskETag(t *Task) string {
+    return fmt.Sprintf("\"%d\"", t.GetVersion())
+}
+
+// taskIfMatch: honour any If-Match header on a request to change a task.
+// Returns false (having responded with a 412) if the client's copy of the
+// task is not the current version
+func taskIfMatch(w http.ResponseWriter, r *http.Request, t *Task) bool {
+    match := r.Header.Get("If-Match")
+    if match == "" {
+        return true
+    }
+    etag := taskETag(t)
+    for _, m := range strings.Split(match, ",") {
+        m = strings.TrimPrefix(strings.TrimSpace(m), "W/")
+        if m == "*" || m == etag {
+            return true
+        }
+    }
+    errorResponse(w, pimErr(versionConflict))
+    return false
+}
+
 // Task: our central type for the whole world here - will become quite large over time
 type TaskJSON struct {
     Id string  `json:"id"`        // unique id of the task - TBD make this pass through to mapper!!!
@@ -35,13 +73,23 @@ type TaskJSON struct {
     TargetStartTime *time.Time `json:"targetStartTime,omitempty"` // targeted start time of the task
     ActualStartTime *time.Time `json:"actualStartTime,omitempty"` // actual start time of the task
     ActualCompletionTime *time.Time `json:"actualCompletionTime,omitempty"` // time task is marked done
+    DueTime *time.Time `json:"dueTime,omitempty"` // deadline for the task
     // Estimate time.Duration `json:"estimate"` // estimated duration of the task time.Duration
     Estimate int `json:"estimate"`
+    Recurrence string `json:"recurrence"`   // RRULE-style rule e.g. "FREQ=WEEKLY;BYDAY=MO" - empty for none
+    Priority int `json:"priority"`           // larger is more important, 0 = none
+    Notes string `json:"notes"`              // long-form description in Markdown
+    CreatedTime *time.Time `json:"createdTime,omitempty"` // read-only - when the task was created
+    TimeSpent int `json:"timeSpent"`         // read-only - minutes worked on the task (compare to estimate)
+    TimerStartTime *time.Time `json:"timerStartTime,omitempty"` // read-only - start of the running work session
     Tags []string `json:"tags"`              // tags to set - for non-updates - make task tags match
     Links []string `json:"links"`             // links to set - for non-updates - make links match
     Dirty []string `json:"dirty"`            // for updates only - which fields to update
     SetTags []string `json:"setTags"`        // for updates only - which tags to set - set "wins"
     ResetTags []string `json:"resetTags"`    // for updates only - which tags to reset
+    ParentIds []string `json:"parentIds"`    // read-only - change with /tasks/{id}/parents
+    ChildIds []string `json:"childIds"`      // read-only - change with /tasks/{id}/children
+    Version int `json:"version"`             // read-only - same as the ETag, send back in If-Match
 }
 
 
@@ -56,7 +104,20 @@ func (j *TaskJSON) IsDirty(field string) bool {
 }
 
 // used for Create, Update and Replace - go to an in-memory Task from JSON
-func (j *TaskJSON) ToTask(t *Task, update bool) {
+// fails (without changing the task) if the JSON holds an invalid value
+func (j *TaskJSON) ToTask(t *Task, update bool) error {
+    // check what can fail before we change anything
+    var recurrence *Recurrence
+    if !update || j.IsDirty("recurrence") {
+        if len(j.Recurrence) > 0 {
+            var err error
+            recurrence, err = ParseRecurrence(j.Recurrence)
+            if err != nil {
+                return err
+            }
+        }
+    }
+
     // t.SetId(j.Id) we don't set the ID, that is done by the server
     if !update || j.IsDirty("name") {
         t.SetName(j.Name)        
@@ -76,6 +137,18 @@ func (j *TaskJSON) ToTask(t *Task, update bool) {
     if !update || j.IsDirty("actualcompletiontime") {
         t.SetActualCompletionTime(j.ActualCompletionTime)
     }
+    if !update || j.IsDirty("duetime") {
+        t.SetDueTime(j.DueTime)
+    }
+    if !update || j.IsDirty("priority") {
+        t.SetPriority(j.Priority)
+    }
+    if !update || j.IsDirty("notes") {
+        t.SetNotes(j.Notes)
+    }
+    if !update || j.IsDirty("recurrence") {
+        t.SetRecurrence(recurrence)
+    }
     if !update { // if create or replace just set tags to match
         t.ClearTags()
         for _, v := range j.Tags {
@@ -101,6 +174,7 @@ func (j *TaskJSON) ToTask(t *Task, update bool) {
         }
     } // in the future we'll support set/reset links like we do for tags perhaps
 
+    return nil
 }
 
 func (j *TaskJSON) FromTask(t *Task) {
@@ -111,10 +185,64 @@ func (j *TaskJSON) FromTask(t *Task) {
         j.TargetStartTime = t.GetTargetStartTime()
         j.ActualStartTime = t.GetActualStartTime()
         j.ActualCompletionTime = t.GetActualCompletionTime()
+        j.DueTime = t.GetDueTime()
         j.Estimate = int(t.GetEstimate())
+        j.Recurrence = t.GetRecurrence().String()
+        j.Priority = t.GetPriority()
+        j.Notes = t.GetNotes()
+        j.CreatedTime = t.GetCreatedTime()
+        j.TimeSpent = int(t.TimeSpent(time.Now()).Minutes())
+        j.TimerStartTime = nil
+        if s := t.RunningSession(); s != nil {
+            j.TimerStartTime = copyTime(&s.Start)
+        }
         j.Tags = t.GetAllTags()
         j.Links = t.GetLinks()
+        j.ParentIds = t.GetParentIds(false) // top-level tasks have no parents
+        j.ChildIds = t.GetChildIds()
+        j.Version = t.GetVersion()
+    }
+}
+
+/*
+==============================================================================
+ sortTasks()
+------------------------------------------------------------------------------
+ Query:   sort  - priority, due, created or position (default position)
+          order - asc or desc (default asc, except desc for priority so the
+                  most important tasks come first)
+
+ Sort a list of tasks for a response as the request asks.  Any handler that
+ returns a list of tasks accepts the same optional query parameters.  If
+ they can't be understood we respond with badRequest and return false.
+============================================================================*/
+func sortTasks(w http.ResponseWriter, r *http.Request, ts Tasks) (Tasks, bool) {
+    query := r.URL.Query()
+    key := sortPosition
+    if s := query.Get("sort"); len(s) > 0 {
+        var err error
+        key, err = TaskSortKeyFromString(s)
+        if err != nil {
+            e := pimErr(badRequest)
+            e.AppendMessage(fmt.Sprintf("sort '%s' must be one of: %s.", s, strings.Join(taskSortKeyStrings, ", ")))
+            errorResponse(w, e)
+            return nil, false
+        }
+    }
+    descending := key == sortPriority
+    switch order := query.Get("order"); order {
+    case "":
+    case "asc":
+        descending = false
+    case "desc":
+        descending = true
+    default:
+        e := pimErr(badRequest)
+        e.AppendMessage(fmt.Sprintf("order '%s' must be asc or desc.", order))
+        errorResponse(w, e)
+        return nil, false
     }
+    return ts.Sort(key, descending), true
 }
 
 // convert a list of tasks to a list of JSON tasks
@@ -135,6 +263,7 @@ func fromTasks(ts Tasks) []TaskJSON {
 type CmdJSON struct {
     Command    string   `json:"cmd"`
     TargetName string   `json:"target"`
+    TargetId   string   `json:"targetId"`
     Status     int      `json:"status"`
     Error      PimError `json:"error"`
     Task       TaskJSON `json:"task"`
@@ -165,7 +294,10 @@ func successResponse(w http.ResponseWriter) {
 // built this from this blog: http://thenewstack.io/make-a-restful-json-api-go/
 
 func ServerStatus(w http.ResponseWriter, r *http.Request) {
-    err := master.MapperError()
+    var err error
+    repo.Read(func() {
+        err = repo.Master().MapperError()
+    })
     if err == nil {
         fmt.Fprintln(w, "OK")
     } else {
@@ -200,8 +332,8 @@ func TagIndex(w http.ResponseWriter, r *http.Request) {
     user := UserIfOn(w, r)
 
     // fmt.Printf("TagIndex(): entry\n")
-    if master.HasChildren() {
-        tags := master.Kids(user).GetChildTags()
+    if repo.Master().HasChildren() {
+        tags := repo.Master().Kids(user).GetChildTags()
         if tags != nil {
             w.Header().Set("Content-Type", "application/json; charset=UTF-8")
             w.WriteHeader(http.StatusOK)
@@ -231,19 +363,22 @@ func TaskIndex(w http.ResponseWriter, r *http.Request) {
         tags = nil
     }
     var kids []TaskJSON = nil
-    if master.HasChildren() {
+    if repo.Master().HasChildren() {
 
         // if tags filter is here then apply it
         if tags != nil && len(tags) > 0 {
             // the second parm says to automatch today and this week
             // based on dates as well as explicit tag matches
-            matching := master.Kids(user).FindTagMatches(tags, true)
+            matching, ok := sortTasks(w, r, repo.Master().Kids(user).FindTagMatches(tags, true, user.Now(), user.GetWeekStart()))
+            if !ok { return }
             if len(matching) > 0 {
                 kids = fromTasks(matching)
             }
         } else {
             // convert kids to JSON-ready tasks            
-            kids = fromTasks(master.Kids(user))
+            matching, ok := sortTasks(w, r, repo.Master().Kids(user))
+            if !ok { return }
+            kids = fromTasks(matching)
         }
 
         if kids != nil {
@@ -267,11 +402,12 @@ func TaskShow(w http.ResponseWriter, r *http.Request) {
 
     vars := mux.Vars(r)
     taskId := vars["taskId"]
-    t := master.FindChild(taskId, user)
+    t := findTask(taskId, user)
     if t != nil {
         var j TaskJSON
         j.FromTask(t)
         w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+        w.Header().Set("ETag", taskETag(t))
         w.WriteHeader(http.StatusOK)    
         if err := json.NewEncoder(w).Encode(j); err != nil {
             panic(err)
@@ -293,10 +429,11 @@ func TaskFind(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     strDate := vars["date"]
     fmt.Printf("strDate=<%v>\n",strDate)
-    date, _ := time.Parse("2006-01-02", strDate)
+    date, _ := time.ParseInLocation("2006-01-02", strDate, user.Location())
     if !date.IsZero() {
-        if master.HasChildren() {
-            matching := master.Kids(user).FindByCompletionDate(date)
+        if repo.Master().HasChildren() {
+            matching, ok := sortTasks(w, r, repo.Master().Kids(user).FindByCompletionDate(date))
+            if !ok { return }
             if len(matching) > 0 {
                 send := fromTasks(matching)
                 w.Header().Set("Content-Type", "application/json; charset=UTF-8")
@@ -317,23 +454,133 @@ func TaskFind(w http.ResponseWriter, r *http.Request) {
     }
 }
 
-// in development: general find function that can take lots of
-// parameters - was going to start with a date range for completion
-// dates to fix the problem with timezones only from UTC (change
-// the client to ask for local time range and call this).
+/*
+=============================================================================
+ TaskSearch()
+-----------------------------------------------------------------------------
+ GET /tasks/search?q=
+
+ Query:   q     - words to search for in the user's tasks
+          sort  - optional, to order the results other than by rank
+
+ Full-text search over all of the user's tasks at any depth, best matches
+ first (see search.go).  Tasks must have every word to match.
+=============================================================================*/
+func TaskSearch(w http.ResponseWriter, r *http.Request) {
+
+    // find my user so I only search tasks that are mine
+    user := UserIfOn(w, r)
+
+    vars := mux.Vars(r)
+    if strings.TrimSpace(vars["q"]) == "" {
+        e := pimErr(badRequest)
+        e.AppendMessage("q with the words to search for is required.")
+        errorResponse(w, e)
+        return
+    }
+    searcher, ok := repo.Storage().(TaskSearcher)
+    if !ok {
+        e := pimErr(badRequest)
+        e.AppendMessage("search is not supported by this server's storage.")
+        errorResponse(w, e)
+        return
+    }
+    hits, err := searcher.TaskSearch(vars["q"], user)
+    if err != nil {
+        fmt.Printf("TaskSearch: search failed with error: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+
+    var found Tasks
+    for _, hit := range hits {
+        if t := findTask(hit.Id, user); t != nil {
+            found = append(found, t)
+        }
+    }
+    if r.URL.Query().Get("sort") != "" {
+        if found, ok = sortTasks(w, r, found); !ok { return }
+    }
+    if len(found) > 0 {
+        send := fromTasks(found)
+        w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+        w.WriteHeader(http.StatusOK)
+        if err := json.NewEncoder(w).Encode(send); err != nil {
+            panic(err)
+        }
+    } else {
+        errorResponse(w, pimErr(emptyList))
+    }
+}
+
+/*
+=============================================================================
+ TaskGeneralFind()
+-----------------------------------------------------------------------------
+ GET /tasks/find?q=
+
+ Query:   q        - what to find (see TaskQuery for the language), e.g.
+                     tag:Work state:inProgress due<2026-11-01 -tag:Personal
+          fromDate - optional start of a completion date range
+          toDate   - optional end of a completion date range (inclusive)
+
+ Finds any of the user's tasks, at any depth, that match.  The dates are
+ shorthand for completed>=fromDate and completed<=toDate.  If our storage
+ can run queries itself we let it, otherwise we search in memory.
+=============================================================================*/
 func TaskGeneralFind(w http.ResponseWriter, r *http.Request) {
 
     // find my user so I can get tasks just for this user
-    // user := UserIfOn(w, r) - don't bother until we actually implement this
+    user := UserIfOn(w, r)
 
-    // tbd
-    // FindBetweenCompletionDate()
-    // extract the search criteria from the request
-    vars := mux.Vars(r)
-    taskId := vars["fromDate"]
-    targetId := vars["toDate"]
-    fmt.Printf("TaskGeneralFind() fromDate: %s toDate: %s vars: %v\n", taskId, targetId, vars)
-    errorResponse(w, pimErr(emptyList)) // for now until we have a response
+    query := r.URL.Query()
+    var terms []string
+    if q := query.Get("q"); q != "" {
+        terms = append(terms, "(" + q + ")")
+    }
+    if from := query.Get("fromDate"); from != "" {
+        terms = append(terms, "completed>=" + from)
+    }
+    if to := query.Get("toDate"); to != "" {
+        terms = append(terms, "completed<=" + to)
+    }
+    tq, err := ParseTaskQuery(strings.Join(terms, " "), user.Location())
+    if err != nil {
+        e := pimErr(badRequest)
+        e.AppendMessage(err.Error())
+        errorResponse(w, e)
+        return
+    }
+
+    var found Tasks
+    if querier, ok := repo.Storage().(TaskQuerier); ok {
+        ids, err := querier.TaskQuery(tq, user)
+        if err != nil {
+            fmt.Printf("TaskGeneralFind: query failed with error: %s\n", err)
+            w.WriteHeader(http.StatusInternalServerError)
+            return
+        }
+        for _, id := range ids {
+            if t := findTask(id, user); t != nil {
+                found = append(found, t)
+            }
+        }
+    } else {
+        found = repo.Master().Descendants(user).FindMatching(tq)
+    }
+
+    matching, ok := sortTasks(w, r, found)
+    if !ok { return }
+    if len(matching) > 0 {
+        send := fromTasks(matching)
+        w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+        w.WriteHeader(http.StatusOK)
+        if err := json.NewEncoder(w).Encode(send); err != nil {
+            panic(err)
+        }
+    } else {
+        errorResponse(w, pimErr(emptyList))
+    }
 }
 
 // TBD: combined with TaskFind
@@ -342,8 +589,9 @@ func TaskFindToday(w http.ResponseWriter, r *http.Request) {
     // find my user so I can get tasks just for this user
     user := UserIfOn(w, r)
 
-    if master.HasChildren() {
-        matching := master.Kids(user).FindToday()
+    if repo.Master().HasChildren() {
+        matching, ok := sortTasks(w, r, repo.Master().Kids(user).FindToday(user.Now()))
+        if !ok { return }
         if len(matching) > 0 {
             send := fromTasks(matching)
             w.Header().Set("Content-Type", "application/json; charset=UTF-8")
@@ -365,8 +613,9 @@ func TaskFindThisWeek(w http.ResponseWriter, r *http.Request) {
     // find my user so I can get tasks just for this user
     user := UserIfOn(w, r)
 
-    if master.HasChildren() {
-        matching := master.Kids(user).FindThisWeek()
+    if repo.Master().HasChildren() {
+        matching, ok := sortTasks(w, r, repo.Master().Kids(user).FindThisWeek(user.Now(), user.GetWeekStart()))
+        if !ok { return }
         if len(matching) > 0 {
             send := fromTasks(matching)
             w.Header().Set("Content-Type", "application/json; charset=UTF-8")
@@ -495,11 +744,8 @@ type BulkResponseJSON struct {
  by returning either the new task (with its assigned id) or a list of such
  tasks.
 
- IN DEV: Today we still always create a single task, even if multiple are
- provided.  TBD: loop over the tasks returned from tasksRead() to create each
- and adjust the resulting JSON response to have the list returned to the
- caller.  THIS CODE IS NOT YET TESTED FOR BULK CREATION!  It does work for
- single task creation.
+ All tasks in a bulk create are created in a single undoable transaction, so
+ either all are created or none are, and one undo removes all of them.
 =============================================================================*/
 func TaskCreate(w http.ResponseWriter, r *http.Request) {
 
@@ -508,33 +754,55 @@ func TaskCreate(w http.ResponseWriter, r *http.Request) {
     if user == nil { return }
     // we don't use UserIfOn() - can create new tasks with users even temporarily
 
+    // new tasks created here are top-level tasks
+    tasksCreate(w, r, user, repo.Master())
+}
+
+// tasksCreate: create the task or tasks in the request as children of the
+// parent provided - worker for TaskCreate and TaskChildCreate
+func tasksCreate(w http.ResponseWriter, r *http.Request, user *User, parent *Task) {
+
     // read the task or tasks from the request - always get back a list
     tasksJSON := tasksRead(w, r)
     if tasksJSON == nil || len(tasksJSON) == 0 {
         return
     }
 
+    // create a persistable task in our world with a unique id for each
+    // task requested, and a command to save each of them
+    var ts Tasks
+    var cmds []Command
+    for _, taskJSON := range tasksJSON {
+        t := NewTask(taskJSON.Name)
+        t.AddUser(user)
+        if err := taskJSON.ToTask(t, false); err != nil {
+            for _, created := range ts {
+                parent.RemoveChild(created)
+            }
+            errorResponse(w, pimErr(badRequest))
+            return
+        }
+        parent.AddChild(t)
+        ts = append(ts, t)
+        cmds = append(cmds, newCreateTaskCmd(t))
+    }
+
+    // save the tasks with a single transaction so that one undo will
+    // undo the entire bulk create - if any fail then none are created
+    err := CommandTransaction(user, "CREATE", cmds...)
+
     // the code prepares a response for multiple task creation but
     // responds with only one if only one was requested. We track the
     // last one so it is easy to respond with just one when needed
     multiResponse := BulkResponseJSON{}
     cntOK := 0
     var lastTaskJSON TaskJSON
-    for _, taskJSON := range tasksJSON {
-
-        // create a persistable task in our world with a unique id
-        t := NewTask(taskJSON.Name)
-        t.AddUser(user)
-        taskJSON.ToTask(t, false)
-        master.AddChild(t)
+    for _, t := range ts {
 
         // track taskStatus objects in case we have multiple items
         taskStatus := TaskStatusJSON{}
-
-        // save the task with a cmd so it can be undone
-        // TBD: consider a bulk undo frame for a bulk create
-        err := CommandCreateTask(t)
         if err != nil {
+            parent.RemoveChild(t) // failed tasks must not linger in memory
             taskStatus.Task = TaskJSON{Name: t.GetName()}
             taskStatus.Status = "Failed"
             taskStatus.Error = "Unable to save task"
@@ -579,6 +847,7 @@ func TaskReplace(w http.ResponseWriter, r *http.Request) {
 
     // find my user so I only change the task if it is mine
     user := UserIfOn(w, r)
+    if user == nil { return }
 
     // extract the task id from the request
     vars := mux.Vars(r)
@@ -587,12 +856,17 @@ func TaskReplace(w http.ResponseWriter, r *http.Request) {
     // make sure the task we wish to replace exists
     // note that we do not allow clients to specify the
     // id of a new task - POST is always used to create tasks
-    t := master.FindChild(taskId, user)
+    t := findTask(taskId, user)
     if t == nil {
       errorResponse(w, pimErr(notFound))
       return
     }
 
+    // make sure the client is changing the version of the task we have
+    if !taskIfMatch(w, r, t) {
+      return
+    }
+
     // record the task as it appears before modification
     // to support undo
     cmd := CommandModifyTaskBegin(t)
@@ -615,13 +889,18 @@ func TaskReplace(w http.ResponseWriter, r *http.Request) {
 
 
     // replace all fields of the current task from the request
-    taskJSON.ToTask(t, false)
+    if err := taskJSON.ToTask(t, false); err != nil {
+        errorResponse(w, pimErr(badRequest))
+        return
+    }
 
     // run the command for the undo stack
-    err := CommandModifyTaskEnd(cmd, t)
+    err := CommandModifyTaskEnd(user, cmd, t)
     // err := t.Save(false)
 
-    if (err != nil) {
+    if _, conflict := err.(*ErrVersionConflict); conflict {
+        errorResponse(w, pimErr(versionConflict))
+    } else if (err != nil) {
         fmt.Printf("TaskReplace: save failed with errror: %s\n", err)
         w.Header().Set("Content-Type", "application/json; charset=UTF-8")
         w.WriteHeader(http.StatusInternalServerError)
@@ -629,6 +908,7 @@ func TaskReplace(w http.ResponseWriter, r *http.Request) {
 
         // set the successful response to include replaced task
         w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+        w.Header().Set("ETag", taskETag(t))
         w.WriteHeader(http.StatusOK)
         var j TaskJSON
         j.FromTask(t)
@@ -644,6 +924,7 @@ func TaskUpdate(w http.ResponseWriter, r *http.Request) {
 
     // find my user so I only change the task if it is mine
     user := UserIfOn(w, r)
+    if user == nil { return }
 
     // extract the task id from the request
     vars := mux.Vars(r)
@@ -652,12 +933,17 @@ func TaskUpdate(w http.ResponseWriter, r *http.Request) {
     // make sure the task we wish to replace exists
     // note that we do not allow clients to specify the
     // id of a new task - POST is always used to create tasks
-    t := master.FindChild(taskId, user)
+    t := findTask(taskId, user)
     if t == nil {
       errorResponse(w, pimErr(notFound))
       return
     }
 
+    // make sure the client is changing the version of the task we have
+    if !taskIfMatch(w, r, t) {
+      return
+    }
+
     cmd := CommandModifyTaskBegin(t)
 
     // read the task from the request
@@ -673,14 +959,26 @@ func TaskUpdate(w http.ResponseWriter, r *http.Request) {
     }
 
     // replace only the fields of the request that were marked dirty
-    taskJSON.ToTask(t, true)
+    if err := taskJSON.ToTask(t, true); err != nil {
+        errorResponse(w, pimErr(badRequest))
+        return
+    }
 
     // log.Printf("update: %+v\n", taskJSON)
     // t.Save(false)
-    CommandModifyTaskEnd(cmd, t)    
+    err := CommandModifyTaskEnd(user, cmd, t)    
+    if _, conflict := err.(*ErrVersionConflict); conflict {
+        errorResponse(w, pimErr(versionConflict))
+        return
+    } else if err != nil {
+        fmt.Printf("TaskUpdate: save failed with errror: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
 
     // set the successful response to include replaced task
     w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.Header().Set("ETag", taskETag(t))
     w.WriteHeader(http.StatusOK)
     var j TaskJSON
     j.FromTask(t)
@@ -693,20 +991,21 @@ func TaskDelete(w http.ResponseWriter, r *http.Request) {
 
     // find my user so I only delete the task if it is mine
     user := UserIfOn(w, r)
+    if user == nil { return }
 
     // extract the task id from the request
     vars := mux.Vars(r)
     taskId := vars["taskId"]
 
     // make sure the task we wish to replace exists
-    t := master.FindChild(taskId, user)
+    t := findTask(taskId, user)
     if t == nil {
       errorResponse(w, pimErr(notFound))
       return
     }
 
     // call the command system to perform the delete
-    err := CommandDeleteTask(t, nil)
+    err := CommandDeleteTask(user, t, nil)
     if err != nil {
       errorResponse(w, pimErr(deleteFailed))
       return
@@ -727,8 +1026,9 @@ func TaskFindComplete(w http.ResponseWriter, r *http.Request) {
     // find my user so I only return tasks that are mine
     user := UserIfOn(w, r)
 
-    if master.HasChildren() {
-        matching := master.Kids(user).FindCompleted()
+    if repo.Master().HasChildren() {
+        matching, ok := sortTasks(w, r, repo.Master().Kids(user).FindCompleted())
+        if !ok { return }
         if len(matching) > 0 {
             send := fromTasks(matching)
             w.Header().Set("Content-Type", "application/json; charset=UTF-8")
@@ -744,143 +1044,496 @@ func TaskFindComplete(w http.ResponseWriter, r *http.Request) {
     }
 }
 
-// consider: should this be an PUT or POST on the task itself
-// with a new "field" of "relativeImportance"?
-func TaskReorder(w http.ResponseWriter, r *http.Request) {
-
-    // find my user so I only change the task if it is mine
-    user := UserIfOn(w, r)    
+// tasks not yet complete whose due time has passed
+func TaskFindOverdue(w http.ResponseWriter, r *http.Request) {
 
-    // extract the task ids from the request
-    vars := mux.Vars(r)
-    taskId := vars["taskId"]
-    targetId := vars["targetId"]
-    fmt.Printf("TaskReorder() taskId: %s targetId: %s vars: %v\n", taskId, targetId, vars)
+    // find my user so I only return tasks that are mine
+    user := UserIfOn(w, r)
 
-    // make sure the task we wish to move exists
-    t := master.FindChild(taskId, user)
-    if t == nil {
-      errorResponse(w, pimErr(notFound))
-      return
+    if repo.Master().HasChildren() {
+        matching, ok := sortTasks(w, r, repo.Master().Kids(user).FindOverdue(time.Now()))
+        if !ok { return }
+        if len(matching) > 0 {
+            send := fromTasks(matching)
+            w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+            w.WriteHeader(http.StatusOK)
+            if err := json.NewEncoder(w).Encode(send); err != nil {
+                panic(err)
+            }
+        } else {
+            errorResponse(w, pimErr(emptyList))
+        }
+    } else {
+        errorResponse(w, pimErr(emptyList))
     }
+}
 
-    // make sure the task we wish to move before exists
-    // but if none specified we're moving to the end
-    target := master.FindChild(targetId, user)
-
-    // make the change - assumes flat list for now
-    // tbd: a better error return
-    err := t.MoveBefore(master.Kids(user), target)
-    if (err != nil) {
-        errorResponse(w, pimErr(notFound))
-        return
+// parseTimeParam: read a time from a query parameter as either RFC3339 or
+// just a date (YYYY-MM-DD, in the given time zone) - a date ending a range
+// includes the whole day, so it is read as the start of the next day
+func parseTimeParam(s string, endOfRange bool, loc *time.Location) (time.Time, error) {
+    if t, err := time.Parse(time.RFC3339, s); err == nil {
+        return t, nil
     }
-
-    // save the tasks impacted - ordering may have to
-    // resave the entire order each time???
-    t.Save(false)
-    // target.Save(false)
-
-    // set the successful response to indicate it worked
-    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-    w.WriteHeader(http.StatusOK)
-    var j TaskJSON
-    j.FromTask(t)    
-    if err := json.NewEncoder(w).Encode(j); err != nil {
-        panic(err)
+    t, err := time.ParseInLocation("2006-01-02", s, loc)
+    if err != nil {
+        return t, err
     }
+    if endOfRange {
+        t = t.AddDate(0, 0, 1)
+    }
+    return t, nil
 }
 
-
 /*
-==============================================================================
- Undo()
-------------------------------------------------------------------------------
- TBD - somehow make this per-user!!!  Undo stack needed per user!
-============================================================================*/
-func Undo(w http.ResponseWriter, r *http.Request) {
+=============================================================================
+ TaskFindDue()
+-----------------------------------------------------------------------------
+ Query:   from - start of the range (RFC3339 or YYYY-MM-DD)
+          to   - end of the range (RFC3339 or YYYY-MM-DD, a date includes
+                 the whole day)
+
+ Returns the tasks due within the range whether they are complete or not.
+=============================================================================*/
+func TaskFindDue(w http.ResponseWriter, r *http.Request) {
 
-    // fmt.Printf("Undo(): entry\n")
-    err := CommandUndo()
-    if (err != nil) {
-        errorResponse(w, pimErr(undoEmpty))
+    // find my user so I only return tasks that are mine
+    user := UserIfOn(w, r)
+
+    vars := mux.Vars(r)
+    from, errFrom := parseTimeParam(vars["from"], false, user.Location())
+    to, errTo := parseTimeParam(vars["to"], true, user.Location())
+    if errFrom != nil || errTo != nil {
+        e := pimErr(badRequest)
+        e.AppendMessage(fmt.Sprintf("from '%s' and to '%s' must be RFC3339 times or YYYY-MM-DD dates.", vars["from"], vars["to"]))
+        errorResponse(w, e)
         return
     }
 
-    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-    w.WriteHeader(http.StatusOK)
-
-    var undoResponse CmdJSON
-    undoResponse.Command = "UNDO"
-    undoResponse.TargetName = "unknown task"
-    undoResponse.Status = 0 // ok
-
-    if err := json.NewEncoder(w).Encode(undoResponse); err != nil {
-        panic(err)
+    if repo.Master().HasChildren() {
+        matching, ok := sortTasks(w, r, repo.Master().Kids(user).FindDueBetween(from, to))
+        if !ok { return }
+        if len(matching) > 0 {
+            send := fromTasks(matching)
+            w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+            w.WriteHeader(http.StatusOK)
+            if err := json.NewEncoder(w).Encode(send); err != nil {
+                panic(err)
+            }
+        } else {
+            errorResponse(w, pimErr(emptyList))
+        }
+    } else {
+        errorResponse(w, pimErr(emptyList))
     }
 }
 
-
 /*
 ==============================================================================
- UserSignup()
+ ReportSummary()
 ------------------------------------------------------------------------------
- Create a new user and automatically sign them in by returning an
- authentication token.
+ GET /reports/summary?from=&to=&groupBy=day|week|tag&format=json|csv
+
+ Query:   from    - start of the range (RFC3339 or YYYY-MM-DD)
+          to      - end of the range (RFC3339 or YYYY-MM-DD, a date
+                    includes the whole day)
+          groupBy - day (default), week or tag
+          format  - json (default) or csv - also csv if the client only
+                    accepts text/csv
+
+ Reports for tasks completed in the range how many were done and how their
+ estimates compare to the time actually spent on them, by group (see
+ Tasks.Summarize()).  The CSV has a header row and ends with the total row.
 ============================================================================*/
-func UserSignup(w http.ResponseWriter, r *http.Request) {
+type ReportSummaryJSON struct {
+    From    time.Time     `json:"from"`
+    To      time.Time     `json:"to"`
+    GroupBy string        `json:"groupBy"`
+    Groups  []ReportGroup `json:"groups"`
+    Total   ReportGroup   `json:"total"`
+}
 
-    var creds UserCredentials
-    // Get the JSON body and decode into credentials
-    err := json.NewDecoder(r.Body).Decode(&creds)
-    if err != nil {
-        // the structure of the body is wrong
-        errorResponse(w, pimErr(badRequest))
+func ReportSummary(w http.ResponseWriter, r *http.Request) {
+
+    // find my user so I only report on tasks that are mine
+    user := UserIfOn(w, r)
+    if user == nil { return }
+
+    vars := mux.Vars(r)
+    from, errFrom := parseTimeParam(vars["from"], false, user.Location())
+    to, errTo := parseTimeParam(vars["to"], true, user.Location())
+    if errFrom != nil || errTo != nil {
+        e := pimErr(badRequest)
+        e.AppendMessage(fmt.Sprintf("from '%s' and to '%s' must be RFC3339 times or YYYY-MM-DD dates.", vars["from"], vars["to"]))
+        errorResponse(w, e)
         return
     }
-
-    // make sure the email address doesn't already exist
-    prev := users.FindByEmail(creds.Email)
-    if prev != nil {
-        errorResponse(w, pimErr(authTaken))
+    query := r.URL.Query()
+    groupBy := groupByDay
+    if s := query.Get("groupBy"); len(s) > 0 {
+        var err error
+        groupBy, err = ReportGroupByFromString(s)
+        if err != nil {
+            e := pimErr(badRequest)
+            e.AppendMessage(fmt.Sprintf("groupBy '%s' must be one of: %s.", s, strings.Join(reportGroupByStrings, ", ")))
+            errorResponse(w, e)
+            return
+        }
+    }
+    format := query.Get("format")
+    if len(format) == 0 && r.Header.Get("Accept") == "text/csv" {
+        format = "csv"
+    }
+    if format != "" && format != "json" && format != "csv" {
+        e := pimErr(badRequest)
+        e.AppendMessage(fmt.Sprintf("format '%s' must be json or csv.", format))
+        errorResponse(w, e)
         return
     }
 
-    // create the new user - may not create if email is invalid
-    // or password is not up to standard
-    // note that every user should get its own copy of the data mapper
-    noob, errCreate := NewUser("", "unspecified", creds.Email, creds.Password, storage.CopyDataMapper())
-    if errCreate != success {
-        errorResponse(w, pimErr(errCreate))
-        return        
-    }
+    groups, total := repo.Master().Descendants(user).Summarize(from, to, groupBy, user.Location(), user.GetWeekStart())
 
-    // save the new user
-    err = noob.Save()
-    if err != nil {
-        errorResponse(w, pimErr(errCreate))
+    if format == "csv" {
+        w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+        w.Header().Set("Content-Disposition", "attachment; filename=\"summary.csv\"")
+        w.WriteHeader(http.StatusOK)
+        out := csv.NewWriter(w)
+        out.Write([]string{groupBy.String(), "completed", "estimateMinutes", "actualMinutes"})
+        for _, g := range append(groups, total) {
+            out.Write([]string{g.Key, strconv.Itoa(g.Completed), strconv.Itoa(g.EstimateMinutes), strconv.Itoa(g.ActualMinutes)})
+        }
+        out.Flush()
         return
     }
 
-    // add the new user to the global list (move inside the object?)
-    users = append(users, noob)
-
-    // now we have a valid user - return credentials to the client
-    UserSetAuthToken(w, creds.Email)
-    successResponse(w)
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusOK)
+    report := ReportSummaryJSON{From:from, To:to, GroupBy:groupBy.String(), Groups:groups, Total:total}
+    if err := json.NewEncoder(w).Encode(report); err != nil {
+        panic(err)
+    }
 }
 
 /*
 ==============================================================================
- UserSignin()
+ TaskTimerStart() / TaskTimerStop()
 ------------------------------------------------------------------------------
- Sign the user into the system by validating credentials that are expected
- in the request and - if the credentials are valid - putting an authentication
- token into the response that the client can reuse on future requests.
+ POST /tasks/{taskId}/timer/start
+ POST /tasks/{taskId}/timer/stop
+
+ Start or stop a work session on the task, to track the time actually spent
+ on it.  Only one session runs at a time, so starting a running timer or
+ stopping a stopped one is a bad request.  Returns the task, whose timeSpent
+ adds up all its sessions.  Like any other change to the task it can be
+ undone.
 ============================================================================*/
-func UserSignin(w http.ResponseWriter, r *http.Request) {
-    fmt.Println("UserSignin() - entry")
-    // enableCors(&w) 
+func TaskTimerStart(w http.ResponseWriter, r *http.Request) {
+    taskTimer(w, r, true)
+}
+
+func TaskTimerStop(w http.ResponseWriter, r *http.Request) {
+    taskTimer(w, r, false)
+}
+
+func taskTimer(w http.ResponseWriter, r *http.Request, start bool) {
+
+    // find my user so I only change the task if it is mine
+    user := UserIfOn(w, r)
+    if user == nil { return }
+
+    vars := mux.Vars(r)
+    t := findTask(vars["taskId"], user)
+    if t == nil {
+      errorResponse(w, pimErr(notFound))
+      return
+    }
+    if !taskIfMatch(w, r, t) {
+      return
+    }
+
+    err := CommandTimerTask(user, t, start, time.Now())
+    if err == errTimerRunning || err == errTimerStopped {
+        e := pimErr(badRequest)
+        e.AppendMessage(err.Error())
+        errorResponse(w, e)
+        return
+    } else if _, conflict := err.(*ErrVersionConflict); conflict {
+        errorResponse(w, pimErr(versionConflict))
+        return
+    } else if err != nil {
+        fmt.Printf("taskTimer: save failed with errror: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.Header().Set("ETag", taskETag(t))
+    w.WriteHeader(http.StatusOK)
+    var j TaskJSON
+    j.FromTask(t)
+    if err := json.NewEncoder(w).Encode(j); err != nil {
+        panic(err)
+    }
+}
+
+/*
+==============================================================================
+ TaskReorder()
+------------------------------------------------------------------------------
+ PATCH /tasks/{taskId}/position
+
+ Body:    {"parentId": "...", "before": "...", "after": "..."}
+
+ Move the task within its parent's list of children, in front of the
+ "before" task or behind the "after" task (give one or the other - with
+ neither it moves to the end of the list).  The parent is the task's first
+ parent unless parentId names another, so top-level tasks need not give
+ one.  The move is a command so it can be undone, and the mappers store
+ the new order.  A move that makes no sense (e.g. next to the task itself)
+ is refused with a 422 and one that can't be saved fails with a 500.
+============================================================================*/
+type TaskPositionJSON struct {
+    ParentId string `json:"parentId"` // parent whose children are reordered
+    Before string `json:"before"`     // id of the sibling to move in front of
+    After string `json:"after"`       // id of the sibling to move behind
+}
+
+func TaskReorder(w http.ResponseWriter, r *http.Request) {
+
+    // find my user so I only change the task if it is mine
+    user := UserIfOn(w, r)    
+    if user == nil { return }
+
+    // make sure the task we wish to move exists
+    vars := mux.Vars(r)
+    t := findTask(vars["taskId"], user)
+    if t == nil {
+      errorResponse(w, pimErr(notFound))
+      return
+    }
+
+    // read where to move it
+    var position TaskPositionJSON
+    body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+    if err != nil {
+        panic(err)
+    }
+    if err := json.Unmarshal(body, &position); err != nil {
+        errorResponse(w, pimErr(badRequest))
+        return
+    }
+    if len(position.Before) > 0 && len(position.After) > 0 {
+        e := pimErr(badRequest)
+        e.AppendMessage("give either before or after, not both.")
+        errorResponse(w, e)
+        return
+    }
+
+    // find the parent whose list we're reordering
+    parent := t.FirstParent()
+    if len(position.ParentId) > 0 {
+        parent = t.FindParent(position.ParentId)
+    }
+    if parent == nil {
+      errorResponse(w, pimErr(notFound))
+      return
+    }
+
+    // find the sibling to move next to - it must be in the same list
+    var target *Task
+    if targetId := position.Before + position.After; len(targetId) > 0 {
+        target = parent.FindChild(targetId, user)
+        if target == nil {
+          errorResponse(w, pimErr(notFound))
+          return
+        }
+        if target == t {
+            e := pimErr(badRequest)
+            e.AppendMessage("a task can't be moved next to itself.")
+            errorResponse(w, e)
+            return
+        }
+    }
+
+    // make the change with a command so it can be undone - moving after
+    // a task is moving in front of the one that follows it
+    before := target
+    if len(position.After) > 0 {
+        before = target.NextSibling(parent)
+    }
+    err = CommandMoveTask(user, t, parent, before)
+    if err == errMoveNotInList || err == errMoveTargetNotInList {
+        e := pimErr(badRequest)
+        e.AppendMessage(err.Error())
+        errorResponse(w, e)
+        return
+    } else if _, conflict := err.(*ErrVersionConflict); conflict {
+        errorResponse(w, pimErr(versionConflict))
+        return
+    } else if err != nil {
+        fmt.Printf("TaskReorder: save failed with errror: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+
+    // set the successful response to indicate it worked
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusOK)
+    var j TaskJSON
+    j.FromTask(t)    
+    if err := json.NewEncoder(w).Encode(j); err != nil {
+        panic(err)
+    }
+}
+
+
+/*
+==============================================================================
+ Undo()
+ Redo()
+------------------------------------------------------------------------------
+ Undo the most recent command of the signed in user, or redo the command
+ most recently undone.  Each user has their own history so we never undo
+ someone else's work.  The response names the task that was affected so
+ the client can tell the user what just happened.
+============================================================================*/
+func Undo(w http.ResponseWriter, r *http.Request) {
+
+    // find my user so I only undo my own commands
+    user := UserIfOn(w, r)
+    if user == nil { return }
+
+    // fmt.Printf("Undo(): entry\n")
+    cmd, err := CommandUndo(user)
+    if cmd == nil {
+        errorResponse(w, pimErr(undoEmpty))
+        return
+    }
+    cmdResponse(w, "UNDO", cmd, err)
+}
+
+func Redo(w http.ResponseWriter, r *http.Request) {
+
+    // find my user so I only redo my own commands
+    user := UserIfOn(w, r)
+    if user == nil { return }
+
+    cmd, err := CommandRedo(user)
+    if cmd == nil {
+        errorResponse(w, pimErr(redoEmpty))
+        return
+    }
+    cmdResponse(w, "REDO", cmd, err)
+}
+
+// cmdResponse: write the CmdJSON envelope describing the command run
+func cmdResponse(w http.ResponseWriter, name string, cmd Command, err error) {
+
+    var response CmdJSON
+    response.Command = name
+    response.Status = 0 // ok
+    response.Error = pimSuccess()
+    if t := cmd.Target(); t != nil {
+        response.TargetName = t.GetName()
+        response.TargetId = t.GetId()
+        response.Task.FromTask(t)
+    }
+    status := http.StatusOK
+    if _, conflict := err.(*ErrVersionConflict); conflict {
+        // someone else changed the task since - the command is dropped
+        // rather than lose their change
+        response.Status = 1
+        response.Error = pimErr(versionConflict)
+        status = response.Error.Response
+    } else if err != nil {
+        response.Status = 1
+        response.Error = pimErr(badRequest)
+        response.Error.AppendMessage(err.Error())
+    }
+
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(status)
+    if err := json.NewEncoder(w).Encode(response); err != nil {
+        panic(err)
+    }
+}
+
+
+/*
+==============================================================================
+ UserSignup()
+------------------------------------------------------------------------------
+ Create a new user and automatically sign them in by returning an
+ authentication token.
+============================================================================*/
+func UserSignup(w http.ResponseWriter, r *http.Request) {
+
+    var creds UserCredentials
+    // Get the JSON body and decode into credentials
+    err := json.NewDecoder(r.Body).Decode(&creds)
+    if err != nil {
+        // the structure of the body is wrong
+        errorResponse(w, pimErr(badRequest))
+        return
+    }
+
+    // make sure the email address doesn't already exist
+    var prev *User
+    repo.Read(func() {
+        prev = repo.Users().FindByEmail(creds.Email)
+    })
+    if prev != nil {
+        errorResponse(w, pimErr(authTaken))
+        return
+    }
+
+    // create the new user - may not create if email is invalid
+    // or password is not up to standard
+    // note that every user should get its own copy of the data mapper
+    noob, errCreate := NewUser("", "unspecified", creds.Email, creds.Password, repo.Storage().CopyDataMapper())
+    if errCreate != success {
+        errorResponse(w, pimErr(errCreate))
+        return        
+    }
+
+    // save the new user and add it to the global list (move inside the
+    // object?) unless someone took the email while we hashed the password
+    taken := false
+    repo.Write(func() {
+        if repo.Users().FindByEmail(creds.Email) != nil {
+            taken = true
+            return
+        }
+        err = noob.Save()
+        if err == nil {
+            repo.AddUser(noob)
+        }
+    })
+    if taken {
+        errorResponse(w, pimErr(authTaken))
+        return
+    }
+    if err != nil {
+        errorResponse(w, pimErr(errCreate))
+        return
+    }
+
+    // now we have a valid user - return credentials to the client
+    if !UserStartSession(w, noob) { return }
+    successResponse(w)
+}
+
+/*
+==============================================================================
+ UserSignin()
+------------------------------------------------------------------------------
+ Sign the user into the system by validating credentials that are expected
+ in the request and - if the credentials are valid - putting an authentication
+ token into the response that the client can reuse on future requests.
+============================================================================*/
+func UserSignin(w http.ResponseWriter, r *http.Request) {
+    fmt.Println("UserSignin() - entry")
+    // enableCors(&w) 
 
     // get the JSON body and decode into credentials
     var creds UserCredentials
@@ -891,16 +1544,43 @@ func UserSignin(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    // don't even look at the password if the attempt is over the limits
+    // (see loginguard.go), but record the attempt all the same.  Only
+    // finding the user needs the repository - checking the password and
+    // recording the attempt are slow so we do them without the lock
+    now := time.Now()
+    attempt := &UserLogin{Email:creds.Email, IPAddress:requestIP(r), CreatedAt:now.UTC()}
+    var user *User
+    var hashedPassword string
+    repo.Read(func() {
+        user = repo.Users().FindByEmail(creds.Email)
+        if user != nil {
+            hashedPassword = user.GetPassword()
+        }
+    })
+    if user != nil {
+        attempt.UserId = user.GetId()
+    }
+    defer func() {
+        if err := repo.Storage().LoginSave(attempt); err != nil {
+            log.Printf("UserSignin: %s\n", err)
+        }
+    }()
+    if errId, wait := loginLimits.Allow(attempt.IPAddress, creds.Email, now); errId != success {
+        w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+        errorResponse(w, pimErr(errId))
+        return
+    }
+
     // we do the success case in one place here, when the
     // username and password are good, set the auth token
     // into the response
-    user := users.FindByEmail(creds.Email)
-    if user != nil {
-        if user.CheckPassword(creds.Password) {
-            UserSetAuthToken(w, creds.Email)
-            successResponse(w)        
-            return
-        }
+    attempt.Succeeded = user != nil && passwordMatches(hashedPassword, creds.Password)
+    loginLimits.Record(creds.Email, attempt.Succeeded, now)
+    if attempt.Succeeded {
+        if !UserStartSession(w, user) { return }
+        successResponse(w)        
+        return
     }
 
     // note that we do not want to leak any username info so it is important
@@ -926,7 +1606,954 @@ func UserSignReup(w http.ResponseWriter, r *http.Request) {
     if user == nil { return }
 
     // create a new token
-    UserSetAuthToken(w, user.GetEmail())
+    if !UserSetAuthToken(w, user.GetEmail()) { return }
     successResponse(w)
     return
 }
+
+/*
+==============================================================================
+ TokenRefresh()
+------------------------------------------------------------------------------
+ POST /token/refresh
+
+ Trade the refresh token of a session for a new access token, even once
+ the old access token has expired.  The refresh token is replaced by a new
+ one that lasts another refreshTimeout, so sessions slide along as long as
+ the client keeps refreshing (see session.go).
+============================================================================*/
+func TokenRefresh(w http.ResponseWriter, r *http.Request) {
+    s, err := userSessionFromRequest(r)
+    if err != nil {
+        fmt.Printf("TokenRefresh: session lookup failed with error: %s\n", err)
+        errorResponse(w, pimErr(authErr))
+        return
+    }
+    var user *User
+    if s != nil {
+        repo.Read(func() {
+            user = repo.Users().FindById(s.UserId)
+        })
+    }
+    if user == nil {
+        userClearCookies(w)
+        errorResponse(w, pimErr(authToken))
+        return
+    }
+
+    // rotate the refresh token so each one can only be used once
+    if err := repo.Storage().SessionDelete(s); err != nil {
+        fmt.Printf("TokenRefresh: unable to end session with error: %s\n", err)
+        errorResponse(w, pimErr(authErr))
+        return
+    }
+    if !UserStartSession(w, user) { return }
+    successResponse(w)
+}
+
+/*
+==============================================================================
+ UserPasswordChange()
+------------------------------------------------------------------------------
+ POST /users/me/password
+
+ Body:    {"oldPassword": "...", "newPassword": "..."}
+
+ Change the password of the signed in user, who must know the old one.
+ Every session of the user ends (signing out anyone who may have learned
+ the old password) and a new one starts for this client.
+============================================================================*/
+type UserPasswordJSON struct {
+    OldPassword string `json:"oldPassword"`
+    NewPassword string `json:"newPassword"`
+}
+
+func UserPasswordChange(w http.ResponseWriter, r *http.Request) {
+    user := UserFromRequest(w, r)
+    if user == nil { return }
+
+    var j UserPasswordJSON
+    if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+        errorResponse(w, pimErr(badRequest))
+        return
+    }
+    if !user.CheckPassword(j.OldPassword) {
+        errorResponse(w, pimErr(authFail))
+        return
+    }
+    if !userReplacePassword(w, user, j.NewPassword) { return }
+    if !UserStartSession(w, user) { return }
+    successResponse(w)
+}
+
+// userReplacePassword: set and save a new password and end every session
+// of the user, returning false (having responded) if that can't be done
+func userReplacePassword(w http.ResponseWriter, u *User, newPassword string) bool {
+    if newPassword == "" {
+        errorResponse(w, pimErr(authBadPW))
+        return false
+    }
+    prior := u.GetPassword()
+    if err := u.SetNewPassword(newPassword); err != nil {
+        errorResponse(w, pimErr(authBadPW))
+        return false
+    }
+    if err := u.Save(); err != nil {
+        u.SetHashedPassword(prior)
+        fmt.Printf("userReplacePassword: save failed with error: %s\n", err)
+        errorResponse(w, pimErr(authErr))
+        return false
+    }
+    if err := repo.Storage().SessionDeleteAll(u); err != nil {
+        fmt.Printf("userReplacePassword: unable to end sessions with error: %s\n", err)
+        errorResponse(w, pimErr(authErr))
+        return false
+    }
+    return true
+}
+
+/*
+==============================================================================
+ UserPasswordReset() / UserPasswordResetConfirm()
+------------------------------------------------------------------------------
+ POST /password/reset          Body: {"email": "..."}
+ POST /password/reset/confirm  Body: {"token": "...", "password": "..."}
+
+ For users who have forgotten their password.  Asking for a reset mails a
+ token to the user (see MailSender) that lasts resetTimeout and can be
+ used once to set a new password, which signs the user in.  Asking always
+ succeeds so nobody can find out which emails have accounts.
+============================================================================*/
+type UserPasswordResetJSON struct {
+    Email    string `json:"email"`
+    Token    string `json:"token"`
+    Password string `json:"password"`
+}
+
+func UserPasswordReset(w http.ResponseWriter, r *http.Request) {
+    var j UserPasswordResetJSON
+    if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+        errorResponse(w, pimErr(badRequest))
+        return
+    }
+
+    var user *User
+    repo.Read(func() {
+        user = repo.Users().FindByEmail(j.Email)
+    })
+    if user != nil {
+        token, _, err := newUserSession(user, sessionReset, resetTimeout)
+        if err == nil {
+            body := fmt.Sprintf("Someone (hopefully you) asked to reset the password of your PIM account.\n\n" +
+                                "To choose a new password send it with this token to %s/password/reset/confirm\n" +
+                                "within the next %s:\n\n    %s\n\n" +
+                                "If you didn't ask to reset your password you can ignore this message.\n",
+                                publicURL, resetTimeout, token)
+            err = mailer.Send(user.GetEmail(), "Reset your PIM password", body)
+        }
+        if err != nil {
+            log.Printf("UserPasswordReset: unable to send reset to %s: %s\n", user, err)
+        }
+    }
+    successResponse(w)
+}
+
+func UserPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+    var j UserPasswordResetJSON
+    if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+        errorResponse(w, pimErr(badRequest))
+        return
+    }
+
+    s, err := userSessionFromToken(j.Token, sessionReset)
+    if err != nil {
+        fmt.Printf("UserPasswordResetConfirm: reset lookup failed with error: %s\n", err)
+        errorResponse(w, pimErr(authErr))
+        return
+    }
+    var user *User
+    if s != nil {
+        repo.Read(func() {
+            user = repo.Users().FindById(s.UserId)
+        })
+    }
+    if user == nil {
+        errorResponse(w, pimErr(authToken))
+        return
+    }
+    replaced := false
+    repo.Write(func() {
+        replaced = userReplacePassword(w, user, j.Password)
+    })
+    if !replaced { return }
+    if !UserStartSession(w, user) { return }
+    successResponse(w)
+}
+
+/*
+==============================================================================
+ UserTokenIndex() / UserTokenCreate() / UserTokenRevoke()
+------------------------------------------------------------------------------
+ GET    /users/me/tokens
+ POST   /users/me/tokens            Body: {"name": "...", "scopes": ["read"]}
+ DELETE /users/me/tokens/{tokenId}
+
+ Personal access tokens of the signed in user (see apitokens.go).  The
+ token itself is only in the response that creates it - after that a
+ token is known by its id.  Only a signed in user can manage tokens, never
+ a script using one.
+============================================================================*/
+type UserTokenJSON struct {
+    Id         string     `json:"id"`
+    Name       string     `json:"name"`
+    Scopes     []string   `json:"scopes"`
+    CreatedAt  time.Time  `json:"createdAt"`
+    LastUsedAt *time.Time `json:"lastUsedAt"`      // null if never used
+    Token      string     `json:"token,omitempty"` // only when created
+}
+
+// the longest name a token can have
+const userTokenMaxName = 128
+
+func tokenToJSON(k *UserToken) UserTokenJSON {
+    return UserTokenJSON{Id:k.Id, Name:k.Name, Scopes:k.Scopes, CreatedAt:k.CreatedAt, LastUsedAt:k.LastUsedAt}
+}
+
+func UserTokenIndex(w http.ResponseWriter, r *http.Request) {
+    user := UserFromRequest(w, r)
+    if user == nil { return }
+
+    tokens, err := repo.Storage().TokenLoadAll(user)
+    if err != nil {
+        fmt.Printf("UserTokenIndex: load failed with error: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+    if len(tokens) == 0 {
+        errorResponse(w, pimErr(emptyList))
+        return
+    }
+    send := make([]UserTokenJSON, 0, len(tokens))
+    for _, k := range tokens {
+        send = append(send, tokenToJSON(k))
+    }
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusOK)
+    if err := json.NewEncoder(w).Encode(send); err != nil {
+        panic(err)
+    }
+}
+
+func UserTokenCreate(w http.ResponseWriter, r *http.Request) {
+    user := UserFromRequest(w, r)
+    if user == nil { return }
+
+    var j UserTokenJSON
+    if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+        errorResponse(w, pimErr(badRequest))
+        return
+    }
+    name := strings.TrimSpace(j.Name)
+    if name == "" || len(name) > userTokenMaxName || len(j.Scopes) == 0 {
+        errorResponse(w, pimErr(badRequest))
+        return
+    }
+    asked := make(map[string]bool)
+    for _, scope := range j.Scopes {
+        if !validTokenScope(scope) {
+            errorResponse(w, pimErr(badRequest))
+            return
+        }
+        asked[scope] = true
+    }
+    var scopes []string // each scope once, in the order of tokenScopes
+    for _, scope := range tokenScopes {
+        if asked[scope] {
+            scopes = append(scopes, scope)
+        }
+    }
+
+    secret, k, err := NewUserToken(user, name, scopes)
+    if err != nil {
+        fmt.Printf("UserTokenCreate: save failed with error: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+    send := tokenToJSON(k)
+    send.Token = secret
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusCreated)
+    if err := json.NewEncoder(w).Encode(send); err != nil {
+        panic(err)
+    }
+}
+
+func UserTokenRevoke(w http.ResponseWriter, r *http.Request) {
+    user := UserFromRequest(w, r)
+    if user == nil { return }
+
+    tokens, err := repo.Storage().TokenLoadAll(user)
+    if err != nil {
+        fmt.Printf("UserTokenRevoke: load failed with error: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+    id := mux.Vars(r)["tokenId"]
+    for _, k := range tokens {
+        if k.Id == id {
+            if err := repo.Storage().TokenDelete(k); err != nil {
+                fmt.Printf("UserTokenRevoke: delete failed with error: %s\n", err)
+                w.WriteHeader(http.StatusInternalServerError)
+                return
+            }
+            successResponse(w)
+            return
+        }
+    }
+    errorResponse(w, pimErr(notFound))
+}
+
+/*
+==============================================================================
+ JWKS()
+------------------------------------------------------------------------------
+ GET /.well-known/jwks.json
+
+ The public keys our access tokens may be signed with, so other services
+ can verify them (see jwtkeys.go).
+============================================================================*/
+func JWKS(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusOK)
+    if err := json.NewEncoder(w).Encode(jwtKeys.jwks()); err != nil {
+        panic(err)
+    }
+}
+
+/*
+==============================================================================
+ UserSignout()
+------------------------------------------------------------------------------
+ POST /signout
+
+ Revoke the session of the refresh token in the request and remove both
+ tokens from the client.  Needs no valid access token so a client whose
+ access token has expired can still sign out.
+============================================================================*/
+func UserSignout(w http.ResponseWriter, r *http.Request) {
+    s, err := userSessionFromRequest(r)
+    if err == nil && s != nil {
+        err = repo.Storage().SessionDelete(s)
+    }
+    if err != nil {
+        fmt.Printf("UserSignout: unable to end session with error: %s\n", err)
+        errorResponse(w, pimErr(authErr))
+        return
+    }
+    userClearCookies(w)
+    successResponse(w)
+}
+
+
+/*
+===============================================================================
+ UserCapacityShow() / UserCapacityUpdate()
+-------------------------------------------------------------------------------
+ GET /users/me/capacity
+ PUT /users/me/capacity
+
+ Body:    {"sunday": 0, "monday": 480, ... "saturday": 0}
+
+ The minutes of working time the user has on each day of the week, which
+ the planner checks their tasks against.  An update must give all 7 days.
+=============================================================================*/
+func capacityToJSON(u *User) map[string]int {
+    capacity := make(map[string]int)
+    for day := time.Sunday; day <= time.Saturday; day++ {
+        capacity[strings.ToLower(day.String())] = int(u.GetCapacity(day).Minutes())
+    }
+    return capacity
+}
+
+func UserCapacityShow(w http.ResponseWriter, r *http.Request) {
+    user := UserFromRequest(w, r)
+    if user == nil { return }
+
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusOK)
+    if err := json.NewEncoder(w).Encode(capacityToJSON(user)); err != nil {
+        panic(err)
+    }
+}
+
+func UserCapacityUpdate(w http.ResponseWriter, r *http.Request) {
+    user := UserFromRequest(w, r)
+    if user == nil { return }
+
+    var capacity map[string]int
+    body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+    if err != nil {
+        panic(err)
+    }
+    if err := json.Unmarshal(body, &capacity); err != nil {
+        errorResponse(w, pimErr(badRequest))
+        return
+    }
+    minutes := make([]int, 7)
+    for day := time.Sunday; day <= time.Saturday; day++ {
+        m, found := capacity[strings.ToLower(day.String())]
+        if !found {
+            e := pimErr(badRequest)
+            e.AppendMessage(fmt.Sprintf("capacity for %s is missing.", day))
+            errorResponse(w, e)
+            return
+        }
+        minutes[day] = m
+    }
+    prior := user.GetCapacityMinutes()
+    if err := user.SetCapacityMinutes(minutes); err != nil {
+        e := pimErr(badRequest)
+        e.AppendMessage(err.Error())
+        errorResponse(w, e)
+        return
+    }
+    if err := user.Save(); err != nil {
+        user.SetCapacityMinutes(prior)
+        fmt.Printf("UserCapacityUpdate: save failed with error: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusOK)
+    if err := json.NewEncoder(w).Encode(capacityToJSON(user)); err != nil {
+        panic(err)
+    }
+}
+
+/*
+===============================================================================
+ UserTimeZoneShow() / UserTimeZoneUpdate()
+-------------------------------------------------------------------------------
+ GET /users/me/timezone
+ PUT /users/me/timezone
+
+ Body:    {"timeZone": "America/New_York", "weekStart": "monday"}
+
+ Where the user's days start and end (an IANA time zone name, or "" for
+ the server's) and the day their weeks start on.  Today, this week, dates
+ in queries and report groups are all on this calendar.  An update may
+ give either or both.
+=============================================================================*/
+type UserTimeZoneJSON struct {
+    TimeZone  *string `json:"timeZone"`
+    WeekStart *string `json:"weekStart"`
+}
+
+func timeZoneToJSON(u *User) UserTimeZoneJSON {
+    zone := u.GetTimeZone()
+    start := strings.ToLower(u.GetWeekStart().String())
+    return UserTimeZoneJSON{TimeZone:&zone, WeekStart:&start}
+}
+
+func UserTimeZoneShow(w http.ResponseWriter, r *http.Request) {
+    user := UserFromRequest(w, r)
+    if user == nil { return }
+
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusOK)
+    if err := json.NewEncoder(w).Encode(timeZoneToJSON(user)); err != nil {
+        panic(err)
+    }
+}
+
+func UserTimeZoneUpdate(w http.ResponseWriter, r *http.Request) {
+    user := UserFromRequest(w, r)
+    if user == nil { return }
+
+    var j UserTimeZoneJSON
+    body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+    if err != nil {
+        panic(err)
+    }
+    if err := json.Unmarshal(body, &j); err != nil {
+        errorResponse(w, pimErr(badRequest))
+        return
+    }
+
+    // check everything before changing anything
+    priorZone, priorStart := user.GetTimeZone(), user.GetWeekStart()
+    zone, start := priorZone, priorStart
+    if j.TimeZone != nil {
+        if _, err := time.LoadLocation(*j.TimeZone); err != nil {
+            e := pimErr(badRequest)
+            e.AppendMessage(fmt.Sprintf("time zone '%s' is not a known IANA time zone.", *j.TimeZone))
+            errorResponse(w, e)
+            return
+        }
+        zone = *j.TimeZone
+    }
+    if j.WeekStart != nil {
+        found := false
+        for day := time.Sunday; day <= time.Saturday; day++ {
+            if strings.EqualFold(*j.WeekStart, day.String()) {
+                start, found = day, true
+            }
+        }
+        if !found {
+            e := pimErr(badRequest)
+            e.AppendMessage(fmt.Sprintf("week start '%s' is not a day of the week.", *j.WeekStart))
+            errorResponse(w, e)
+            return
+        }
+    }
+    user.SetTimeZone(zone)
+    user.SetWeekStart(start)
+    if err := user.Save(); err != nil {
+        user.SetTimeZone(priorZone)
+        user.SetWeekStart(priorStart)
+        fmt.Printf("UserTimeZoneUpdate: save failed with error: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusOK)
+    if err := json.NewEncoder(w).Encode(timeZoneToJSON(user)); err != nil {
+        panic(err)
+    }
+}
+
+/*
+===============================================================================
+ PlannerShow()
+-------------------------------------------------------------------------------
+ GET /planner
+
+ Does today's list fit in the day?  Returns the estimated load against the
+ user's capacity for today and for the rest of this week, and the today
+ tasks we suggest pushing to this week if today is over-committed (see
+ PlanCapacity()).  Moving them is up to the client - tag them thisweek and
+ untag them today.
+=============================================================================*/
+type CapacityPlanJSON struct {
+    CapacityPlan
+    OverCommitted bool `json:"overCommitted"`
+}
+
+type PlanJSON struct {
+    Date       string           `json:"date"`
+    Today      CapacityPlanJSON `json:"today"`
+    Week       CapacityPlanJSON `json:"week"`
+    PushToWeek []TaskJSON       `json:"pushToWeek"`
+}
+
+func PlannerShow(w http.ResponseWriter, r *http.Request) {
+
+    // find my user so I only plan with tasks that are mine
+    user := UserIfOn(w, r)
+    if user == nil { return }
+
+    plan := PlanCapacity(user, repo.Master().Kids(user), time.Now())
+    send := PlanJSON{
+        Date:       plan.Date.Format("2006-01-02"),
+        Today:      CapacityPlanJSON{plan.Today, plan.Today.IsOverCommitted()},
+        Week:       CapacityPlanJSON{plan.Week, plan.Week.IsOverCommitted()},
+        PushToWeek: fromTasks(plan.PushToWeek),
+    }
+    if send.PushToWeek == nil {
+        send.PushToWeek = []TaskJSON{}
+    }
+
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusOK)
+    if err := json.NewEncoder(w).Encode(send); err != nil {
+        panic(err)
+    }
+}
+
+/*
+===============================================================================
+ TaskChildIndex
+-------------------------------------------------------------------------------
+ Return the children of a task that belong to the user.  Together with the
+ parentIds and childIds of each task this lets clients walk the hierarchy.
+=============================================================================*/
+func TaskChildIndex(w http.ResponseWriter, r *http.Request) {
+
+    // find my user so I only return tasks that are mine
+    user := UserIfOn(w, r)
+    if user == nil { return }
+
+    vars := mux.Vars(r)
+    taskId := vars["taskId"]
+    t := findTask(taskId, user)
+    if t == nil {
+        errorResponse(w, pimErr(notFound))
+        return
+    }
+
+    kids, ok := sortTasks(w, r, t.Kids(user))
+    if !ok { return }
+    if len(kids) == 0 {
+        errorResponse(w, pimErr(emptyList))
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusOK)
+    if err := json.NewEncoder(w).Encode(fromTasks(kids)); err != nil {
+        panic(err)
+    }
+}
+
+/*
+===============================================================================
+ TaskChildCreate
+-------------------------------------------------------------------------------
+ Create one or more tasks as children of an existing task.  The request and
+ response are the same as for TaskCreate.
+=============================================================================*/
+func TaskChildCreate(w http.ResponseWriter, r *http.Request) {
+
+    // find the user creating the task who will own it
+    user := UserFromRequest(w, r)
+    if user == nil { return }
+
+    vars := mux.Vars(r)
+    taskId := vars["taskId"]
+    parent := findTask(taskId, user)
+    if parent == nil {
+        errorResponse(w, pimErr(notFound))
+        return
+    }
+
+    tasksCreate(w, r, user, parent)
+}
+
+/*
+===============================================================================
+ TaskParentAdd
+-------------------------------------------------------------------------------
+ Result: 200 (ok)              - task is now a child of the parent
+         422 (unprocessable)   - parent would create a cycle in the hierarchy
+         404 (not found)       - task or parent not found
+
+ Add a parent to a task.  A top-level task moves under the new parent (it is
+ no longer top-level), while a task that already has parents keeps them and
+ gains another.  Adding a parent the task already has changes nothing.
+=============================================================================*/
+func TaskParentAdd(w http.ResponseWriter, r *http.Request) {
+
+    // find my user so I only change the task if it is mine
+    user := UserIfOn(w, r)
+    if user == nil { return }
+
+    vars := mux.Vars(r)
+    t := findTask(vars["taskId"], user)
+    parent := findTask(vars["parentId"], user)
+    if t == nil || parent == nil {
+        errorResponse(w, pimErr(notFound))
+        return
+    }
+
+    // a task can't be its own ancestor
+    if parent == t || t.IsAncestorOf(parent) {
+        e := pimErr(badRequest)
+        e.AppendMessage("parent is the task itself or one of its descendents.")
+        errorResponse(w, e)
+        return
+    }
+
+    // use a command so the change can be undone
+    if t.FindParent(parent.GetId()) == nil {
+        var oldParent *Task
+        if t.FindParent(repo.Master().GetId()) != nil {
+            oldParent = repo.Master()
+        }
+        err := CommandReparentTask(user, t, oldParent, parent)
+        if err != nil {
+            fmt.Printf("TaskParentAdd: save failed with error: %s\n", err)
+            w.WriteHeader(http.StatusInternalServerError)
+            return
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusOK)
+    var j TaskJSON
+    j.FromTask(t)
+    if err := json.NewEncoder(w).Encode(j); err != nil {
+        panic(err)
+    }
+}
+
+/*
+===============================================================================
+ TaskParentRemove
+-------------------------------------------------------------------------------
+ Result: 200 (ok)              - task is no longer a child of the parent
+         404 (not found)       - task not found or parent is not its parent
+
+ Remove a parent from a task.  If it was the task's only parent the task
+ becomes a top-level task rather than being orphaned.
+=============================================================================*/
+func TaskParentRemove(w http.ResponseWriter, r *http.Request) {
+
+    // find my user so I only change the task if it is mine
+    user := UserIfOn(w, r)
+    if user == nil { return }
+
+    vars := mux.Vars(r)
+    t := findTask(vars["taskId"], user)
+    if t == nil {
+        errorResponse(w, pimErr(notFound))
+        return
+    }
+    parent := t.FindParent(vars["parentId"])
+    if parent == nil || parent == repo.Master() {
+        errorResponse(w, pimErr(notFound))
+        return
+    }
+
+    // use a command so the change can be undone
+    var newParent *Task
+    if t.NumParents() == 1 {
+        newParent = repo.Master()
+    }
+    err := CommandReparentTask(user, t, parent, newParent)
+    if err != nil {
+        fmt.Printf("TaskParentRemove: save failed with error: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusOK)
+    var j TaskJSON
+    j.FromTask(t)
+    if err := json.NewEncoder(w).Encode(j); err != nil {
+        panic(err)
+    }
+}
+
+/*
+===============================================================================
+ TaskCommentIndex / TaskCommentCreate / TaskCommentUpdate
+-------------------------------------------------------------------------------
+ GET   /tasks/{taskId}/comments              - the comments, oldest first
+ POST  /tasks/{taskId}/comments              - add a comment
+ PATCH /tasks/{taskId}/comments/{commentId}  - edit a comment
+
+ Body:    {"text": "..."}
+
+ Anyone who shares the task can read and add comments, but only the author
+ of a comment can edit it (403 for anyone else).  Adding or editing returns
+ the comment.
+=============================================================================*/
+type TaskCommentJSON struct {
+    Id        int        `json:"id"`
+    TaskId    string     `json:"taskId"`
+    AuthorId  string     `json:"authorId"`
+    Author    string     `json:"author"`    // name of the author
+    Text      string     `json:"text"`
+    CreatedAt time.Time  `json:"createdAt"`
+    EditedAt  *time.Time `json:"editedAt"`  // null if never edited
+}
+
+// userName: the name of a user for display, empty if they are long gone
+func userName(id string) string {
+    if u := repo.Users().FindById(id); u != nil {
+        return u.GetName()
+    }
+    return ""
+}
+
+func commentToJSON(c *TaskComment) TaskCommentJSON {
+    return TaskCommentJSON{Id:c.Id, TaskId:c.TaskId, AuthorId:c.UserId, Author:userName(c.UserId),
+                           Text:c.Text, CreatedAt:c.CreatedAt, EditedAt:c.EditedAt}
+}
+
+// commentRead: read the text of a comment from the body of a request,
+// returning false (having responded) if there isn't any
+func commentRead(w http.ResponseWriter, r *http.Request) (string, bool) {
+    var j TaskCommentJSON
+    body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+    if err != nil {
+        panic(err)
+    }
+    if err := json.Unmarshal(body, &j); err != nil || strings.TrimSpace(j.Text) == "" {
+        e := pimErr(badRequest)
+        e.AppendMessage("text of the comment is required.")
+        errorResponse(w, e)
+        return "", false
+    }
+    return j.Text, true
+}
+
+func commentResponse(w http.ResponseWriter, status int, c *TaskComment) {
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(status)
+    if err := json.NewEncoder(w).Encode(commentToJSON(c)); err != nil {
+        panic(err)
+    }
+}
+
+func TaskCommentIndex(w http.ResponseWriter, r *http.Request) {
+
+    // find my user so I only show comments on tasks that are mine
+    user := UserIfOn(w, r)
+    if user == nil { return }
+
+    vars := mux.Vars(r)
+    t := findTask(vars["taskId"], user)
+    if t == nil {
+        errorResponse(w, pimErr(notFound))
+        return
+    }
+
+    comments, err := repo.Storage().CommentLoad(t)
+    if err != nil {
+        fmt.Printf("TaskCommentIndex: load failed with error: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+    if len(comments) == 0 {
+        errorResponse(w, pimErr(emptyList))
+        return
+    }
+    send := make([]TaskCommentJSON, 0, len(comments))
+    for _, c := range comments {
+        send = append(send, commentToJSON(c))
+    }
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusOK)
+    if err := json.NewEncoder(w).Encode(send); err != nil {
+        panic(err)
+    }
+}
+
+func TaskCommentCreate(w http.ResponseWriter, r *http.Request) {
+
+    // find the user who is the author of the comment
+    user := UserIfOn(w, r)
+    if user == nil { return }
+
+    vars := mux.Vars(r)
+    t := findTask(vars["taskId"], user)
+    if t == nil {
+        errorResponse(w, pimErr(notFound))
+        return
+    }
+    text, ok := commentRead(w, r)
+    if !ok { return }
+
+    c := NewTaskComment(t, user, text)
+    if err := repo.Storage().CommentSave(c); err != nil {
+        fmt.Printf("TaskCommentCreate: save failed with error: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+    commentResponse(w, http.StatusCreated, c)
+}
+
+func TaskCommentUpdate(w http.ResponseWriter, r *http.Request) {
+
+    // find my user so only the author can edit the comment
+    user := UserIfOn(w, r)
+    if user == nil { return }
+
+    vars := mux.Vars(r)
+    t := findTask(vars["taskId"], user)
+    if t == nil {
+        errorResponse(w, pimErr(notFound))
+        return
+    }
+    comments, err := repo.Storage().CommentLoad(t)
+    if err != nil {
+        fmt.Printf("TaskCommentUpdate: load failed with error: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+    var c *TaskComment
+    for _, curr := range comments {
+        if strconv.Itoa(curr.Id) == vars["commentId"] {
+            c = curr
+        }
+    }
+    if c == nil {
+        errorResponse(w, pimErr(notFound))
+        return
+    }
+    if c.UserId != user.GetId() {
+        errorResponse(w, pimErr(notAuthor))
+        return
+    }
+    text, ok := commentRead(w, r)
+    if !ok { return }
+
+    // edit a copy so a failed save leaves the comment as it was
+    edited := *c
+    edited.Edit(text, time.Now())
+    if err := repo.Storage().CommentSave(&edited); err != nil {
+        fmt.Printf("TaskCommentUpdate: save failed with error: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+    commentResponse(w, http.StatusOK, &edited)
+}
+
+/*
+===============================================================================
+ TaskActivityIndex
+-------------------------------------------------------------------------------
+ GET /tasks/{taskId}/activity
+
+ The history of a task, oldest first: who created it, changed it, changed
+ its state, tagged it, moved it, deleted it, or undid any of those.  The
+ action is the verb (EXEC or UNDO - a redo is an EXEC) and kind of command
+ and the log is the full log line of the command.
+=============================================================================*/
+type TaskActivityJSON struct {
+    Id        int       `json:"id"`
+    TaskId    string    `json:"taskId"`
+    UserId    string    `json:"userId"`
+    User      string    `json:"user"`      // name of the user
+    Action    string    `json:"action"`    // e.g. EXEC-CREATE, EXEC-STATE, UNDO-TAG
+    Log       string    `json:"log"`
+    CreatedAt time.Time `json:"createdAt"`
+}
+
+func TaskActivityIndex(w http.ResponseWriter, r *http.Request) {
+
+    // find my user so I only show the activity of tasks that are mine
+    user := UserIfOn(w, r)
+    if user == nil { return }
+
+    vars := mux.Vars(r)
+    t := findTask(vars["taskId"], user)
+    if t == nil {
+        errorResponse(w, pimErr(notFound))
+        return
+    }
+
+    entries, err := repo.Storage().ActivityLoad(t)
+    if err != nil {
+        fmt.Printf("TaskActivityIndex: load failed with error: %s\n", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+    }
+    if len(entries) == 0 {
+        errorResponse(w, pimErr(emptyList))
+        return
+    }
+    send := make([]TaskActivityJSON, 0, len(entries))
+    for _, a := range entries {
+        send = append(send, TaskActivityJSON{Id:a.Id, TaskId:a.TaskId, UserId:a.UserId, User:userName(a.UserId),
+                                             Action:a.Action, Log:a.Log, CreatedAt:a.CreatedAt})
+    }
+    w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+    w.WriteHeader(http.StatusOK)
+    if err := json.NewEncoder(w).Encode(send); err != nil {
+        panic(err)
+    }
+}
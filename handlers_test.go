@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// a request from a signed in user to a route with the variables in its
+// path given, and any headers as name, value pairs
+func routeRequest(h http.HandlerFunc, u *User, method string, vars map[string]string, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	r = mux.SetURLVars(r.WithContext(context.WithValue(r.Context(), "user", u)), vars)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// a task can't be given itself or one of its descendents as a parent
+func TestTaskParentAddCycle(t *testing.T) {
+	u, _ := testRepository(t)
+	add := func(parent *Task, name string) *Task {
+		task := NewTask(name)
+		task.AddUser(u)
+		parent.AddChild(task)
+		return task
+	}
+	project := add(repo.Master(), "project")
+	step := add(project, "step")
+	detail := add(step, "detail")
+	other := add(repo.Master(), "other")
+	addParent := func(task *Task, parent *Task) int {
+		vars := map[string]string{"taskId": task.GetId(), "parentId": parent.GetId()}
+		return routeRequest(TaskParentAdd, u, "PUT", vars, "").Code
+	}
+
+	if code := addParent(project, project); code != http.StatusUnprocessableEntity {
+		t.Error("Expected a task to be refused as its own parent but got", code)
+	}
+	if code := addParent(project, detail); code != http.StatusUnprocessableEntity {
+		t.Error("Expected a descendent to be refused as a parent but got", code)
+	}
+	if project.NumParents() != 1 || project.FirstParent() != repo.Master() {
+		t.Error("Expected the refused parents to leave the task where it was")
+	}
+
+	if code := addParent(detail, other); code != http.StatusOK || detail.NumParents() != 2 {
+		t.Error("Expected a task elsewhere to be added as a parent but got", code)
+	}
+}
+
+// a change made over a stale copy of a task is refused with a 412 and the
+// current version's ETag is what lets it through
+func TestTaskUpdateIfMatch(t *testing.T) {
+	u, _ := testRepository(t)
+	task := NewTask("Book venue")
+	task.AddUser(u)
+	repo.Master().AddChild(task)
+	if err := CommandCreateTask(u, task); err != nil {
+		t.Fatal("Could not test - unable to create a task:", err)
+	}
+	update := func(etag string, name string) *httptest.ResponseRecorder {
+		body := `{"id":"` + task.GetId() + `","name":"` + name + `","dirty":["name"]}`
+		return routeRequest(TaskUpdate, u, "PATCH", map[string]string{"taskId": task.GetId()}, body, "If-Match", etag)
+	}
+
+	stale := taskETag(task)
+	w := update(stale, "Book venue for May")
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == stale {
+		t.Fatal("Expected the update to succeed with a new ETag but got", w.Code, w.Header().Get("ETag"))
+	}
+	if w := update(stale, "Book venue for June"); w.Code != http.StatusPreconditionFailed {
+		t.Error("Expected a stale If-Match to be refused with a 412 but got", w.Code)
+	}
+	if task.GetName() != "Book venue for May" {
+		t.Error("Expected the refused update to change nothing but found", task.GetName())
+	}
+	if w := update(taskETag(task), "Book venue for June"); w.Code != http.StatusOK || task.GetName() != "Book venue for June" {
+		t.Error("Expected the current ETag to be accepted but got", w.Code, task.GetName())
+	}
+}
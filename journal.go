@@ -0,0 +1,524 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "time"
+)
+
+/*
+==============================================================================
+ Command Journal
+------------------------------------------------------------------------------
+ The command history of each user only lives in memory, so on its own a
+ restart of the server would lose every user's ability to undo.  To survive
+ a restart each command is also written to a journal through the user's
+ TaskDataMapper as it is executed, undone or redone.
+
+ A journal entry holds a JSON commandRecord with enough information to
+ rebuild the command once the task hierarchy has been reloaded: the kind
+ of command, the id of the task it acted upon and TaskYAML snapshots of
+ the task where needed (TaskYAML is already our plain, serializable form
+ of a task).  Entries that have been undone are flagged so that they are
+ rebuilt onto the redo stack instead of the undo stack.
+
+ Entries older than a configurable age are pruned when the server starts
+ and every journalPruneInterval after (see runJournalPruner()), and the
+ commands they hold leave the histories in memory at the same time.
+ Journal errors are logged but never fail the command itself - losing the
+ ability to undo after a restart is better than losing the user's change.
+============================================================================*/
+// how often the server prunes the journal of entries that are too old
+const journalPruneInterval = time.Hour
+
+type JournalEntry struct {
+    Id        int       // assigned by the mapper when first saved (0 = new)
+    UserId    string    // user whose history this entry belongs to
+    Record    string    // JSON-encoded commandRecord
+    Undone    bool      // true if the command is on the redo stack
+    CreatedAt time.Time
+}
+
+type commandRecord struct {
+    Kind      string    `json:"kind"`                // create, delete, update, move, reparent, tag, timer, composite
+    TaskId    string    `json:"taskId,omitempty"`    // task the command acted upon
+    ParentIds []string  `json:"parentIds,omitempty"` // parents needed to re-attach a task
+    Prior     *TaskYAML `json:"prior,omitempty"`     // the task before the command
+    After     *TaskYAML `json:"after,omitempty"`     // the task after the command
+    NewParentId string  `json:"newParentId,omitempty"` // reparent only
+    BeforeId    string  `json:"beforeId,omitempty"`    // move only - sibling moved in front of
+    OldBeforeId string  `json:"oldBeforeId,omitempty"` // move and reparent - sibling the task was in front of
+    SetTags     []string `json:"setTags,omitempty"`    // tag only
+    ResetTags   []string `json:"resetTags,omitempty"`  // tag only
+    PriorTags   []string `json:"priorTags,omitempty"`  // tag only - the tags before the command
+    Timer       string  `json:"timer,omitempty"`       // timer only - start or stop
+    When        *time.Time `json:"when,omitempty"`     // timer only - when it was started or stopped
+    Session     *time.Time `json:"session,omitempty"`  // timer only - start of the session
+    Name        string  `json:"name,omitempty"`        // composite only
+    Cmds        []*commandRecord `json:"cmds,omitempty"` // composite only
+}
+
+// snapshot a task into its serializable form
+func snapshotTask(t *Task) *TaskYAML {
+    if t == nil {
+        return nil
+    }
+    yt := new(TaskYAML)
+    yt.FromTask(t)
+    return yt
+}
+
+// rebuild an in-memory task (without parents) from a snapshot
+func restoreTask(yt *TaskYAML) *Task {
+    t := &Task{id:yt.Id}
+    yt.ToTask(t)
+    return t
+}
+
+// find the first parent listed in the record, or fall back to the root
+func recordParent(rec *commandRecord, tasks *journalTasks) *Task {
+    for _, id := range rec.ParentIds {
+        p := tasks.find(id)
+        if p != nil {
+            return p
+        }
+    }
+    return tasks.root
+}
+
+func (dtc *deleteTaskCmd) Record() *commandRecord {
+    rec := &commandRecord{Kind:"delete", TaskId:dtc.tDelete.GetId(), Prior:snapshotTask(dtc.tDelete)}
+    if dtc.tOldParent != nil && !dtc.tOldParent.IsMemoryOnly() {
+        rec.ParentIds = []string{dtc.tOldParent.GetId()}
+    }
+    return rec
+}
+
+func (ctc *createTaskCmd) Record() *commandRecord {
+    rec := &commandRecord{Kind:"create", TaskId:ctc.tCreate.GetId(), After:snapshotTask(ctc.tCreate)}
+    rec.ParentIds = rec.After.Parents
+    return rec
+}
+
+func (utc *updateTaskCmd) Record() *commandRecord {
+    return &commandRecord{Kind:"update", TaskId:utc.tUpdate.GetId(), Prior:snapshotTask(utc.tPrior), After:snapshotTask(utc.tUpdate)}
+}
+
+func (mtc *moveTaskCmd) Record() *commandRecord {
+    rec := &commandRecord{Kind:"move", TaskId:mtc.tMove.GetId()}
+    rec.ParentIds = []string{recordTaskId(mtc.tParent)}
+    if mtc.tBefore != nil {
+        rec.BeforeId = mtc.tBefore.GetId()
+    }
+    if mtc.tOldBefore != nil {
+        rec.OldBeforeId = mtc.tOldBefore.GetId()
+    }
+    return rec
+}
+
+func (rtc *reparentTaskCmd) Record() *commandRecord {
+    rec := &commandRecord{Kind:"reparent", TaskId:rtc.tMove.GetId()}
+    if rtc.tOldParent != nil {
+        rec.ParentIds = []string{recordTaskId(rtc.tOldParent)}
+    }
+    if rtc.tNewParent != nil {
+        rec.NewParentId = recordTaskId(rtc.tNewParent)
+    }
+    if rtc.tOldBefore != nil {
+        rec.OldBeforeId = rtc.tOldBefore.GetId()
+    }
+    return rec
+}
+
+func (ttc *tagTaskCmd) Record() *commandRecord {
+    return &commandRecord{Kind:"tag", TaskId:ttc.tTag.GetId(), SetTags:ttc.setTags, ResetTags:ttc.resetTags, PriorTags:ttc.priorTags}
+}
+
+func (ttc *timerTaskCmd) Record() *commandRecord {
+    rec := &commandRecord{Kind:"timer", TaskId:ttc.tTimer.GetId(), Timer:"stop", When:copyTime(&ttc.tWhen), Session:copyTime(&ttc.tSession)}
+    if ttc.bStart {
+        rec.Timer = "start"
+    }
+    return rec
+}
+
+func (cc *compositeCmd) Record() *commandRecord {
+    rec := &commandRecord{Kind:"composite", Name:cc.sName}
+    for _, cmd := range cc.cmds {
+        rec.Cmds = append(rec.Cmds, cmd.Record())
+    }
+    return rec
+}
+
+// the memory-only root gets a new id every time the server starts so
+// records refer to it by name rather than by id
+const recordRootId = "root"
+
+func recordTaskId(t *Task) string {
+    if t.IsMemoryOnly() {
+        return recordRootId
+    }
+    return t.GetId()
+}
+
+// journalTasks: the tasks commands rebuilt from the journal can refer to -
+// those in the loaded hierarchy, and those rebuilt from the journal that
+// are out of it (deleted, or created by a command that was undone)
+type journalTasks struct {
+    root *Task
+    gone map[string]*Task
+}
+
+// find a task by id where the root itself may be asked for
+func (jt *journalTasks) find(id string) *Task {
+    if id == recordRootId {
+        return jt.root
+    }
+    if t := jt.root.FindDescendent(id); t != nil {
+        return t
+    }
+    return jt.gone[id]
+}
+
+// restore: rebuild a task that is out of the hierarchy so commands
+// rebuilt after this one can find it too
+func (jt *journalTasks) restore(yt *TaskYAML) *Task {
+    t := restoreTask(yt)
+    jt.gone[t.GetId()] = t
+    return t
+}
+
+/*
+==============================================================================
+ commandFromRecord()
+------------------------------------------------------------------------------
+ Inputs:  rec    *commandRecord - the record read back from the journal
+          undone bool           - true if the command had been undone
+          tasks  *journalTasks  - the freshly loaded tasks to refer to
+          u      *User          - user whose history is being rebuilt
+ Returns:        Command        - the rebuilt command or nil if the tasks
+                                  it refers to no longer exist
+
+ Note the task a command refers to is in memory or not depending on whether
+ the command was undone (e.g. a deleted task is only in memory once the
+ delete has been undone), so we look tasks up or rebuild them accordingly.
+============================================================================*/
+func commandFromRecord(rec *commandRecord, undone bool, tasks *journalTasks, u *User) Command {
+    switch rec.Kind {
+    case "create":
+        if !undone {
+            t := tasks.find(rec.TaskId)
+            if t == nil {
+                return nil
+            }
+            return &createTaskCmd{tCreate:t}
+        }
+        if rec.After == nil {
+            return nil
+        }
+        t := tasks.restore(rec.After)
+        t.AddUser(u)
+        return &createTaskCmd{tCreate:t, tParent:recordParent(rec, tasks)}
+
+    case "delete":
+        if undone {
+            t := tasks.find(rec.TaskId)
+            if t == nil {
+                return nil
+            }
+            return &deleteTaskCmd{tDelete:t}
+        }
+        if rec.Prior == nil {
+            return nil
+        }
+        t := tasks.restore(rec.Prior)
+        t.AddUser(u)
+        return &deleteTaskCmd{tDelete:t, tOldParent:recordParent(rec, tasks)}
+
+    case "update":
+        t := tasks.find(rec.TaskId)
+        if t == nil || rec.Prior == nil || rec.After == nil {
+            return nil
+        }
+        // what the task looks like now is what the command left it as -
+        // the versions of changes by others before the restart are lost
+        utc := &updateTaskCmd{tUpdate:t, iVersion:t.GetVersion(), bPrepared:true}
+        utc.tPrior = t.Copy(nil)
+        rec.Prior.ToTask(utc.tPrior)
+        if undone {
+            utc.tAfter = t.Copy(nil)
+            rec.After.ToTask(utc.tAfter)
+        }
+        return utc
+
+    case "move":
+        t := tasks.find(rec.TaskId)
+        p := recordParent(rec, tasks)
+        if t == nil || p.findChild(t) == -1 {
+            return nil
+        }
+        mtc := newMoveTaskCmd(t, p, nil)
+        if rec.BeforeId != "" {
+            mtc.tBefore = tasks.find(rec.BeforeId)
+        }
+        // a sibling that has since left the list can't be put back in
+        // front of, so the task goes back to the end of the list instead
+        if rec.OldBeforeId != "" {
+            if before := tasks.find(rec.OldBeforeId); before != nil && p.findChild(before) != -1 {
+                mtc.tOldBefore = before
+            }
+        }
+        return mtc
+
+    case "reparent":
+        t := tasks.find(rec.TaskId)
+        if t == nil {
+            return nil
+        }
+        rtc := newReparentTaskCmd(t, nil, nil)
+        if len(rec.ParentIds) > 0 {
+            rtc.tOldParent = tasks.find(rec.ParentIds[0])
+            if rtc.tOldParent == nil {
+                return nil
+            }
+        }
+        if rec.NewParentId != "" {
+            rtc.tNewParent = tasks.find(rec.NewParentId)
+            if rtc.tNewParent == nil {
+                return nil
+            }
+        }
+        if rec.OldBeforeId != "" {
+            rtc.tOldBefore = tasks.find(rec.OldBeforeId)
+        }
+        return rtc
+
+    case "tag":
+        t := tasks.find(rec.TaskId)
+        if t == nil {
+            return nil
+        }
+        ttc := newTagTaskCmd(t, rec.SetTags, rec.ResetTags)
+        ttc.priorTags = rec.PriorTags
+        return ttc
+
+    case "timer":
+        t := tasks.find(rec.TaskId)
+        if t == nil || rec.When == nil || rec.Session == nil {
+            return nil
+        }
+        return &timerTaskCmd{tTimer:t, user:u, bStart:rec.Timer == "start", tWhen:*rec.When, tSession:*rec.Session}
+
+    case "composite":
+        cc := &compositeCmd{sName:rec.Name}
+        // rebuild in order so that (for example) a create restored from
+        // the journal can be found by a later command in the same frame
+        for _, child := range rec.Cmds {
+            cmd := commandFromRecord(child, undone, tasks, u)
+            if cmd == nil {
+                return nil
+            }
+            cc.cmds = append(cc.cmds, cmd)
+        }
+        return cc
+    }
+    return nil
+}
+
+// journalAdd: write a newly executed command to the journal
+func (h *commandHistory) journalAdd(u *User, cmd Command) {
+    if u.persist == nil {
+        return
+    }
+    data, err := json.Marshal(cmd.Record())
+    if err != nil {
+        log.Printf("journalAdd(): unable to encode command: %s\n", err)
+        return
+    }
+    e := &JournalEntry{UserId:u.GetId(), Record:string(data), CreatedAt:time.Now().UTC()}
+    err = u.persist.JournalSave(e)
+    if err != nil {
+        log.Printf("journalAdd(): unable to save command: %s\n", err)
+        return
+    }
+    if h.journal == nil {
+        h.journal = make(map[Command]*JournalEntry)
+    }
+    h.journal[cmd] = e
+}
+
+// journalMark: record that a command was undone or redone.  A redo runs
+// the command again, which takes a fresh note of what it changes (e.g. the
+// tags before a tag command) so the record is written again too
+func (h *commandHistory) journalMark(u *User, cmd Command, undone bool) {
+    e := h.journal[cmd]
+    if e == nil || u.persist == nil {
+        return
+    }
+    e.Undone = undone
+    if !undone {
+        data, err := json.Marshal(cmd.Record())
+        if err != nil {
+            log.Printf("journalMark(): unable to encode command: %s\n", err)
+        } else {
+            e.Record = string(data)
+        }
+    }
+    err := u.persist.JournalSave(e)
+    if err != nil {
+        log.Printf("journalMark(): unable to save command: %s\n", err)
+    }
+}
+
+// journalDrop: remove commands from the journal (e.g. a cleared redo stack)
+func (h *commandHistory) journalDrop(u *User, cmds []Command) {
+    for _, cmd := range cmds {
+        e := h.journal[cmd]
+        if e == nil {
+            continue
+        }
+        delete(h.journal, cmd)
+        if u.persist == nil {
+            continue
+        }
+        err := u.persist.JournalDelete(e)
+        if err != nil {
+            log.Printf("journalDrop(): unable to delete command: %s\n", err)
+        }
+    }
+}
+
+/*
+==============================================================================
+ Load()
+------------------------------------------------------------------------------
+ Inputs:  u    *User - the user whose history we are rebuilding
+          root *Task - root of the task hierarchy, which must be loaded
+ Returns:      error - error reading the journal
+
+ Rebuild a user's undo and redo stacks from their journal.  Intended to be
+ called once at startup after all tasks have been loaded.  Entries that no
+ longer make sense (their tasks are gone) are dropped from the journal.
+============================================================================*/
+func (h *commandHistory) Load(u *User, root *Task) error {
+    if u.persist == nil {
+        return errors.New("user has no storage to load history from")
+    }
+    entries, err := u.persist.JournalLoad(u)
+    if err != nil {
+        return err
+    }
+
+    // a command may refer to a task that a later command took out of the
+    // hierarchy (e.g. deleted it), so the commands still done are rebuilt
+    // newest first and the ones undone (the newest but for a rollover,
+    // which never takes a task out) oldest first - either way the command
+    // that restores such a task is rebuilt first
+    var order []int
+    for i := len(entries) - 1; i >= 0; i-- {
+        if !entries[i].Undone {
+            order = append(order, i)
+        }
+    }
+    for i, e := range entries {
+        if e.Undone {
+            order = append(order, i)
+        }
+    }
+    tasks := &journalTasks{root:root, gone:make(map[string]*Task)}
+    cmds := make([]Command, len(entries))
+    for _, i := range order {
+        var rec commandRecord
+        if err := json.Unmarshal([]byte(entries[i].Record), &rec); err == nil {
+            cmds[i] = commandFromRecord(&rec, entries[i].Undone, tasks, u)
+        }
+    }
+
+    // the oldest of the undone entries was undone last so it belongs on
+    // top of the redo stack
+    var redo []Command
+    for i, e := range entries {
+        cmd := cmds[i]
+        if cmd == nil {
+            log.Printf("commandHistory.Load(): dropping stale journal entry %d\n", e.Id)
+            u.persist.JournalDelete(e)
+            continue
+        }
+        if h.journal == nil {
+            h.journal = make(map[Command]*JournalEntry)
+        }
+        h.journal[cmd] = e
+        if e.Undone {
+            redo = append(redo, cmd)
+        } else {
+            h.Push(cmd)
+        }
+    }
+    for i := len(redo) - 1; i >= 0; i-- {
+        h.PushRedo(redo[i])
+    }
+    return nil
+}
+
+// Prune: forget the commands journaled before the time given, just as the
+// journal forgets them (commands that were never journaled are kept).  The
+// undo stack loses its oldest commands, but redoing any command depends on
+// redoing the ones undone after it first, so if any command on the redo
+// stack is too old the whole redo stack goes
+func (h *commandHistory) Prune(u *User, before time.Time) {
+    old := func(cmd Command) bool {
+        e := h.journal[cmd]
+        return e != nil && e.CreatedAt.Before(before)
+    }
+    var kept []Command
+    for _, cmd := range h.cmds {
+        if old(cmd) {
+            delete(h.journal, cmd)
+            continue
+        }
+        kept = append(kept, cmd)
+    }
+    h.cmds = kept
+    for _, cmd := range h.redo {
+        if old(cmd) {
+            h.journalDrop(u, h.redo)
+            h.ClearRedo()
+            break
+        }
+    }
+}
+
+// pruneCommandHistories: prune journal entries older than the maximum age
+// from the journal and from each user's history
+func pruneCommandHistories(tdm TaskDataMapper, us Users, maxAge time.Duration) {
+    before := time.Now().UTC().Add(-maxAge)
+    err := tdm.JournalPrune(before)
+    if err != nil {
+        log.Printf("Unable to prune command journal: %s\n", err)
+        return
+    }
+    for _, u := range us {
+        u.History().Prune(u, before)
+    }
+}
+
+/*
+==============================================================================
+ runJournalPruner()
+------------------------------------------------------------------------------
+ Inputs:  r      *TaskRepository - the repository holding the users
+          maxAge time.Duration   - how long commands can be undone
+
+ Runs forever (start it on its own goroutine) pruning the journal and the
+ histories every journalPruneInterval, so a server that stays up for weeks
+ doesn't keep every command ever run.
+============================================================================*/
+func runJournalPruner(r *TaskRepository, maxAge time.Duration) {
+    for {
+        time.Sleep(journalPruneInterval)
+        r.Write(func() {
+            pruneCommandHistories(r.Storage(), r.Users(), maxAge)
+        })
+    }
+}
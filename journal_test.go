@@ -0,0 +1,283 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// journalMapper: a YAML mapper that keeps the command journal in memory
+// (the YAML mapper itself doesn't journal) so tests can rebuild histories
+type journalMapper struct {
+	*TaskDataMapperYAML
+	entries []*JournalEntry
+	next    int
+}
+
+func newJournalMapper(t *testing.T) *journalMapper {
+	return &journalMapper{TaskDataMapperYAML: NewTaskDataMapperYAML(filepath.Join(t.TempDir(), "tasks.yaml"))}
+}
+
+func (m *journalMapper) JournalSave(e *JournalEntry) error {
+	if e.Id == 0 {
+		m.next++
+		e.Id = m.next
+		m.entries = append(m.entries, e)
+		return nil
+	}
+	for i, curr := range m.entries {
+		if curr.Id == e.Id {
+			m.entries[i] = e
+		}
+	}
+	return nil
+}
+
+func (m *journalMapper) JournalLoad(u *User) ([]*JournalEntry, error) {
+	var found []*JournalEntry
+	for _, e := range m.entries {
+		if e.UserId == u.GetId() {
+			copied := *e
+			found = append(found, &copied)
+		}
+	}
+	return found, nil
+}
+
+func (m *journalMapper) JournalDelete(e *JournalEntry) error {
+	for i, curr := range m.entries {
+		if curr.Id == e.Id {
+			m.entries = append(m.entries[:i], m.entries[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *journalMapper) JournalPrune(before time.Time) error {
+	var kept []*JournalEntry
+	for _, e := range m.entries {
+		if !e.CreatedAt.Before(before) {
+			kept = append(kept, e)
+		}
+	}
+	m.entries = kept
+	return nil
+}
+
+// restartHistory: forget the user's history and rebuild it from the
+// journal, as the server does when it starts
+func restartHistory(t *testing.T, u *User, root *Task) {
+	u.history = nil
+	if err := u.History().Load(u, root); err != nil {
+		t.Fatal("Unable to rebuild history:", err)
+	}
+}
+
+// names of the children of a task in order, e.g. "a,b,c"
+func childNames(p *Task) string {
+	var names []string
+	for _, c := range p.kids {
+		names = append(names, c.GetName())
+	}
+	return strings.Join(names, ",")
+}
+
+// sorted tags of a task, e.g. "errand,today"
+func tagNames(task *Task) string {
+	tags := task.GetTags()
+	sort.Strings(tags)
+	return strings.Join(tags, ",")
+}
+
+// undoing a tag command or a rollover after a restart puts back exactly
+// the tags the task had, not just the ones the command changed
+func TestJournalTagUndo(t *testing.T) {
+	mapper := newJournalMapper(t)
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(mapper.TaskDataMapperYAML)
+	u := &User{id: "tester", persist: mapper}
+	midnight := time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	yesterday := midnight.Add(-10 * time.Hour)
+
+	tagged := NewTask("tagged")
+	tagged.SetTag("today")
+	tagged.SetTag("urgent")
+	master.AddChild(tagged)
+	rolled := NewTask("rolled over")
+	rolled.SetTag("errand")
+	rolled.SetTargetStartTime(&yesterday)
+	master.AddChild(rolled)
+
+	if err := CommandTagTask(u, tagged, []string{"thisweek"}, []string{"today"}); err != nil {
+		t.Fatal("Unable to tag task:", err)
+	}
+	if n, err := CommandRollover(u, master.Kids(nil), midnight); err != nil || n != 1 {
+		t.Fatal("Expected to roll over 1 task but rolled over", n, err)
+	}
+	if tagNames(rolled) != "errand,thisweek,today" {
+		t.Fatal("Expected the rollover to tag the task but found", tagNames(rolled))
+	}
+
+	restartHistory(t, u, master)
+	if _, err := CommandUndo(u); err != nil {
+		t.Fatal("Unable to undo rollover:", err)
+	}
+	if tagNames(rolled) != "errand" {
+		t.Error("Expected undoing the rollover to keep the task's own tags but found", tagNames(rolled))
+	}
+	if _, err := CommandUndo(u); err != nil {
+		t.Fatal("Unable to undo tag:", err)
+	}
+	if tagNames(tagged) != "today,urgent" {
+		t.Error("Expected undoing the tag to restore the tags but found", tagNames(tagged))
+	}
+}
+
+// undoing a move or a reparent after a restart puts the task back where
+// it was among its siblings, not at the end of the list
+func TestJournalMoveUndo(t *testing.T) {
+	mapper := newJournalMapper(t)
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(mapper.TaskDataMapperYAML)
+	u := &User{id: "tester", persist: mapper}
+	add := func(parent *Task, name string) *Task {
+		task := NewTask(name)
+		parent.AddChild(task)
+		return task
+	}
+	a := add(master, "a")
+	add(master, "b")
+	add(master, "c")
+	from := add(master, "from")
+	to := add(master, "to")
+	x := add(from, "x")
+	add(from, "y")
+
+	if err := CommandMoveTask(u, a, master, nil); err != nil {
+		t.Fatal("Unable to move task:", err)
+	}
+	if err := CommandReparentTask(u, x, from, to); err != nil {
+		t.Fatal("Unable to reparent task:", err)
+	}
+	if childNames(master) != "b,c,from,to,a" || childNames(from) != "y" {
+		t.Fatal("Expected the tasks to move but found", childNames(master), childNames(from))
+	}
+
+	restartHistory(t, u, master)
+	for i := 0; i < 2; i++ {
+		if _, err := CommandUndo(u); err != nil {
+			t.Fatal("Unable to undo:", err)
+		}
+	}
+	if childNames(from) != "x,y" || childNames(to) != "" {
+		t.Error("Expected undoing the reparent to put the task back first but found", childNames(from))
+	}
+	if childNames(master) != "a,b,c,from,to" {
+		t.Error("Expected undoing the move to put the task back first but found", childNames(master))
+	}
+}
+
+// after a restart the rebuilt history undoes and redoes creates, updates
+// and deletes just as the history before the restart would have
+func TestJournalRebuild(t *testing.T) {
+	mapper := newJournalMapper(t)
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(mapper.TaskDataMapperYAML)
+	u := &User{id: "tester", persist: mapper}
+	add := func(name string) *Task {
+		task := NewTask(name)
+		task.AddUser(u)
+		master.AddChild(task)
+		if err := CommandCreateTask(u, task); err != nil {
+			t.Fatal("Unable to create task:", err)
+		}
+		return task
+	}
+
+	kept := add("kept")
+	deleted := add("deleted")
+	cmd := CommandModifyTaskBegin(kept)
+	kept.SetName("kept and renamed")
+	if err := CommandModifyTaskEnd(u, cmd, kept); err != nil {
+		t.Fatal("Unable to update task:", err)
+	}
+	if err := CommandDeleteTask(u, deleted, nil); err != nil {
+		t.Fatal("Unable to delete task:", err)
+	}
+
+	restartHistory(t, u, master)
+	for i := 0; i < 3; i++ {
+		if _, err := CommandUndo(u); err != nil {
+			t.Fatal("Unable to undo:", err)
+		}
+	}
+	if childNames(master) != "kept" {
+		t.Fatal("Expected the rebuilt history to undo all three commands but found", childNames(master))
+	}
+	if master.FindDescendent(deleted.GetId()) != nil {
+		t.Error("Expected the delete to be undone and the create before it too")
+	}
+
+	// what was undone before a restart can still be redone after it
+	restartHistory(t, u, master)
+	for i := 0; i < 3; i++ {
+		if _, err := CommandRedo(u); err != nil {
+			t.Fatal("Unable to redo:", err)
+		}
+	}
+	if childNames(master) != "kept and renamed" {
+		t.Error("Expected the rebuilt history to redo all three commands but found", childNames(master))
+	}
+	if !u.History().IsRedoEmpty() {
+		t.Error("Expected nothing left to redo")
+	}
+}
+
+// pruning while the server runs forgets old commands in the journal and
+// in the history alike
+func TestJournalPrune(t *testing.T) {
+	mapper := newJournalMapper(t)
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(mapper.TaskDataMapperYAML)
+	u := &User{id: "tester", persist: mapper}
+	create := func(names ...string) {
+		for _, name := range names {
+			task := NewTask(name)
+			task.AddUser(u)
+			master.AddChild(task)
+			if err := CommandCreateTask(u, task); err != nil {
+				t.Fatal("Unable to create task:", err)
+			}
+		}
+	}
+	create("old", "new")
+	mapper.entries[0].CreatedAt = time.Now().UTC().Add(-8 * 24 * time.Hour)
+
+	pruneCommandHistories(mapper, Users{u}, 7*24*time.Hour)
+	if len(mapper.entries) != 1 {
+		t.Error("Expected the old command to be pruned from the journal but found", len(mapper.entries))
+	}
+	if _, err := CommandUndo(u); err != nil {
+		t.Fatal("Unable to undo:", err)
+	}
+	if !u.History().IsEmpty() || childNames(master) != "old" {
+		t.Error("Expected only the new command to be left to undo but found", childNames(master))
+	}
+
+	// a command can only be redone once those undone after it are, so
+	// one too old to keep takes the whole redo stack with it
+	create("x", "y")
+	for i := 0; i < 2; i++ {
+		if _, err := CommandUndo(u); err != nil {
+			t.Fatal("Unable to undo:", err)
+		}
+	}
+	mapper.entries[0].CreatedAt = time.Now().UTC().Add(-8 * 24 * time.Hour)
+	pruneCommandHistories(mapper, Users{u}, 7*24*time.Hour)
+	if !u.History().IsRedoEmpty() || len(mapper.entries) != 0 {
+		t.Error("Expected the redo stack to be dropped but found", len(mapper.entries), "journal entries")
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+    "crypto"
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "math/big"
+    "os"
+    "path/filepath"
+    "github.com/dgrijalva/jwt-go"
+    "gopkg.in/yaml.v2"
+)
+
+/*
+==============================================================================
+ JWT Signing Keys
+------------------------------------------------------------------------------
+ Access tokens are signed with a key from a key ring.  Every token carries
+ the id of its key (the "kid" header) so that a ring can hold several keys
+ at once: one to sign new tokens with, and others that still verify tokens
+ signed before a rotation.  To rotate keys add the new key to the ring and
+ make it the signing key, then remove the old key once the tokens it signed
+ have expired (minutes - see jwtTimeout).  Refresh tokens are not JWTs so a
+ rotation never signs anyone out.
+
+ The ring is configured from the environment:
+    PIM_JWT_KEY_FILE  - path of a YAML key file (see jwtKeyFileYAML), or
+    PIM_JWT_KEY       - a secret for a single HS256 key
+ HS256 secrets, here or in a key file, must be at least 32 characters.
+ With neither the server makes up a random HS256 key each time it starts,
+ so access tokens don't survive a restart (sessions refresh them).
+
+ Keys may be HS256 (a shared secret), RS256 (an RSA key) or EdDSA (an
+ Ed25519 key).  Other services can verify tokens signed with the public
+ key algorithms without being able to sign them, using the public keys
+ published at /.well-known/jwks.json.  A key file may list a public key
+ alone to verify tokens signed elsewhere.
+============================================================================*/
+
+// the environment variables the key ring is configured from
+const (
+    JWT_KEY_FILE_ENV = "PIM_JWT_KEY_FILE"
+    JWT_KEY_ENV      = "PIM_JWT_KEY"
+)
+
+// the shortest HS256 secret we accept - anything shorter is guessable
+const jwtMinSecretLength = 32
+
+type jwtSigningKey struct {
+    kid       string
+    method    jwt.SigningMethod
+    signKey   interface{} // nil if we can only verify with this key
+    verifyKey interface{}
+}
+
+type jwtKeyRing struct {
+    keys    map[string]*jwtSigningKey // kid -> key
+    signing *jwtSigningKey            // key new tokens are signed with
+}
+
+// the key ring in use - replaced by initJWTKeys() when the server starts
+var jwtKeys = newRandomJWTKeys()
+
+// jwtKeyFileYAML: the key file, e.g.
+//
+//    signing: 2026-10
+//    keys:
+//      - kid: 2026-10
+//        alg: EdDSA
+//        file: ed25519-2026-10.pem  # PKCS8 private key, or a public key
+//      - kid: 2026-04
+//        alg: RS256
+//        file: rsa-2026-04.pem      # PKCS1/PKCS8 private key, or a public key
+//      - kid: 2025-10
+//        alg: HS256
+//        secret: "a long random string"
+//
+// Files are relative to the key file.  The signing key defaults to the
+// first key listed.
+type jwtKeyFileYAML struct {
+    Signing string
+    Keys []struct {
+        Kid    string
+        Alg    string
+        File   string
+        Secret string
+    }
+}
+
+func newRandomJWTKeys() *jwtKeyRing {
+    secret := make([]byte, 32)
+    if _, err := rand.Read(secret); err != nil {
+        panic(err)
+    }
+    return newHMACKeyRing("random", secret)
+}
+
+func newHMACKeyRing(kid string, secret []byte) *jwtKeyRing {
+    k := &jwtSigningKey{kid:kid, method:jwt.SigningMethodHS256, signKey:secret, verifyKey:secret}
+    return &jwtKeyRing{keys:map[string]*jwtSigningKey{kid:k}, signing:k}
+}
+
+/*
+==============================================================================
+ initJWTKeys()
+------------------------------------------------------------------------------
+ Returns: error - what is wrong with the configured keys, if anything
+
+ Set up the key ring from the environment (see above).  Called once when
+ the server starts, before any tokens are handed out.
+============================================================================*/
+func initJWTKeys() error {
+    if fileName := os.Getenv(JWT_KEY_FILE_ENV); fileName != "" {
+        ring, err := loadJWTKeyFile(fileName)
+        if err != nil {
+            return err
+        }
+        jwtKeys = ring
+        log.Printf("...signing access tokens with key %s from %s\n", ring.signing.kid, fileName)
+    } else if secret := os.Getenv(JWT_KEY_ENV); secret != "" {
+        if len(secret) < jwtMinSecretLength {
+            return errors.New(fmt.Sprintf("%s: an HS256 secret must be at least %d characters", JWT_KEY_ENV, jwtMinSecretLength))
+        }
+        jwtKeys = newHMACKeyRing("env", []byte(secret))
+        log.Printf("...signing access tokens with the key in %s\n", JWT_KEY_ENV)
+    } else {
+        log.Printf("...signing access tokens with a random key - set %s or %s to keep tokens valid across restarts\n", JWT_KEY_FILE_ENV, JWT_KEY_ENV)
+    }
+    return nil
+}
+
+// loadJWTKeyFile: read a key ring from a key file
+func loadJWTKeyFile(fileName string) (*jwtKeyRing, error) {
+    data, err := ioutil.ReadFile(fileName)
+    if err != nil {
+        return nil, errors.New(fmt.Sprintf("unable to read JWT key file %s: %s", fileName, err))
+    }
+    var kf jwtKeyFileYAML
+    if err := yaml.Unmarshal(data, &kf); err != nil {
+        return nil, errors.New(fmt.Sprintf("unable to parse JWT key file %s: %s", fileName, err))
+    }
+
+    ring := &jwtKeyRing{keys:make(map[string]*jwtSigningKey)}
+    for _, entry := range kf.Keys {
+        if entry.Kid == "" || ring.keys[entry.Kid] != nil {
+            return nil, errors.New(fmt.Sprintf("JWT key file %s: every key needs its own kid", fileName))
+        }
+        k := &jwtSigningKey{kid:entry.Kid}
+        if entry.Alg == "HS256" {
+            if len(entry.Secret) < jwtMinSecretLength {
+                return nil, errors.New(fmt.Sprintf("JWT key %s: an HS256 secret must be at least %d characters", entry.Kid, jwtMinSecretLength))
+            }
+            k.method, k.signKey, k.verifyKey = jwt.SigningMethodHS256, []byte(entry.Secret), []byte(entry.Secret)
+        } else {
+            keyFile := entry.File
+            if !filepath.IsAbs(keyFile) {
+                keyFile = filepath.Join(filepath.Dir(fileName), keyFile)
+            }
+            pemData, err := ioutil.ReadFile(keyFile)
+            if err != nil {
+                return nil, errors.New(fmt.Sprintf("JWT key %s: unable to read %s: %s", entry.Kid, keyFile, err))
+            }
+            if err := k.parsePEM(entry.Alg, pemData); err != nil {
+                return nil, errors.New(fmt.Sprintf("JWT key %s: %s", entry.Kid, err))
+            }
+        }
+        ring.keys[k.kid] = k
+        if ring.signing == nil && (kf.Signing == "" || kf.Signing == k.kid) {
+            ring.signing = k
+        }
+    }
+    if ring.signing == nil || ring.signing.signKey == nil {
+        return nil, errors.New(fmt.Sprintf("JWT key file %s has no private key to sign with", fileName))
+    }
+    return ring, nil
+}
+
+// parsePEM: set the method and keys from a PEM private or public key
+func (k *jwtSigningKey) parsePEM(alg string, pemData []byte) error {
+    block, _ := pem.Decode(pemData)
+    if block == nil {
+        return errors.New("key file is not PEM")
+    }
+    switch alg {
+    case "RS256":
+        k.method = jwt.SigningMethodRS256
+        if private, err := jwt.ParseRSAPrivateKeyFromPEM(pemData); err == nil {
+            k.signKey, k.verifyKey = private, &private.PublicKey
+            return nil
+        }
+        public, err := jwt.ParseRSAPublicKeyFromPEM(pemData)
+        if err != nil {
+            return errors.New("key file holds no RSA key")
+        }
+        k.verifyKey = public
+        return nil
+
+    case "EdDSA":
+        k.method = signingMethodEdDSA
+        if private, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+            if edPrivate, ok := private.(ed25519.PrivateKey); ok {
+                k.signKey, k.verifyKey = edPrivate, edPrivate.Public()
+                return nil
+            }
+        }
+        public, err := x509.ParsePKIXPublicKey(block.Bytes)
+        if edPublic, ok := public.(ed25519.PublicKey); err == nil && ok {
+            k.verifyKey = edPublic
+            return nil
+        }
+        return errors.New("key file holds no Ed25519 key")
+    }
+    return errors.New(fmt.Sprintf("algorithm '%s' is not one of HS256, RS256 or EdDSA", alg))
+}
+
+// sign: a signed token with the claims, naming the key that signed it
+func (ring *jwtKeyRing) sign(claims jwt.Claims) (string, error) {
+    token := jwt.NewWithClaims(ring.signing.method, claims)
+    token.Header["kid"] = ring.signing.kid
+    return token.SignedString(ring.signing.signKey)
+}
+
+// keyFunc: find the key that verifies a token - the one it names, which
+// must use the algorithm the token says it was signed with
+func (ring *jwtKeyRing) keyFunc(token *jwt.Token) (interface{}, error) {
+    kid, _ := token.Header["kid"].(string)
+    k := ring.keys[kid]
+    if k == nil {
+        return nil, errors.New(fmt.Sprintf("token signed with unknown key '%s'", kid))
+    }
+    if token.Method.Alg() != k.method.Alg() {
+        return nil, errors.New(fmt.Sprintf("token signed with %s but key '%s' is %s", token.Method.Alg(), kid, k.method.Alg()))
+    }
+    return k.verifyKey, nil
+}
+
+/*
+==============================================================================
+ JWKS
+------------------------------------------------------------------------------
+ The public keys of the ring as a JSON Web Key Set (RFC 7517) so other
+ services can verify our tokens.  HS256 keys are secrets, so they are never
+ published.
+============================================================================*/
+type JWKJSON struct {
+    Kid string `json:"kid"`
+    Kty string `json:"kty"`
+    Alg string `json:"alg"`
+    Use string `json:"use"`
+    N   string `json:"n,omitempty"`   // RSA modulus
+    E   string `json:"e,omitempty"`   // RSA exponent
+    Crv string `json:"crv,omitempty"` // Ed25519 curve
+    X   string `json:"x,omitempty"`   // Ed25519 public key
+}
+
+type JWKSJSON struct {
+    Keys []JWKJSON `json:"keys"`
+}
+
+func (ring *jwtKeyRing) jwks() JWKSJSON {
+    set := JWKSJSON{Keys:[]JWKJSON{}}
+    for kid, k := range ring.keys {
+        jwk := JWKJSON{Kid:kid, Alg:k.method.Alg(), Use:"sig"}
+        switch public := k.verifyKey.(type) {
+        case *rsa.PublicKey:
+            jwk.Kty = "RSA"
+            jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+            jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+        case ed25519.PublicKey:
+            jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+            jwk.X = base64.RawURLEncoding.EncodeToString(public)
+        default:
+            continue
+        }
+        set.Keys = append(set.Keys, jwk)
+    }
+    return set
+}
+
+/*
+==============================================================================
+ signingMethodEdDSA
+------------------------------------------------------------------------------
+ Our version of jwt-go predates EdDSA, so we add it as a signing method
+ of our own over crypto/ed25519.
+============================================================================*/
+type jwtSigningMethodEd25519 struct{}
+
+var signingMethodEdDSA = &jwtSigningMethodEd25519{}
+
+func init() {
+    jwt.RegisterSigningMethod(signingMethodEdDSA.Alg(), func() jwt.SigningMethod {
+        return signingMethodEdDSA
+    })
+}
+
+func (m *jwtSigningMethodEd25519) Alg() string {
+    return "EdDSA"
+}
+
+func (m *jwtSigningMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+    private, ok := key.(ed25519.PrivateKey)
+    if !ok {
+        return "", jwt.ErrInvalidKeyType
+    }
+    sig, err := private.Sign(rand.Reader, []byte(signingString), crypto.Hash(0))
+    if err != nil {
+        return "", err
+    }
+    return jwt.EncodeSegment(sig), nil
+}
+
+func (m *jwtSigningMethodEd25519) Verify(signingString string, signature string, key interface{}) error {
+    public, ok := key.(ed25519.PublicKey)
+    if !ok {
+        return jwt.ErrInvalidKeyType
+    }
+    sig, err := jwt.DecodeSegment(signature)
+    if err != nil {
+        return err
+    }
+    if !ed25519.Verify(public, []byte(signingString), sig) {
+        return jwt.ErrSignatureInvalid
+    }
+    return nil
+}
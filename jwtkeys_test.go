@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// tokens name the key that signed them so keys can be rotated, and a token
+// can't pass itself off as signed with another algorithm
+func TestJWTKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, blockType string, der []byte) {
+		data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal("Unable to write key:", err)
+		}
+	}
+	_, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edPrivate)
+	write("ed.pem", "PRIVATE KEY", edDER)
+	rsaPrivate, _ := rsa.GenerateKey(rand.Reader, 2048)
+	write("rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaPrivate))
+	rsaPublicDER, _ := x509.MarshalPKIXPublicKey(&rsaPrivate.PublicKey)
+	write("rsa-public.pem", "PUBLIC KEY", rsaPublicDER)
+
+	keyFile := func(yaml string) *jwtKeyRing {
+		fileName := filepath.Join(dir, "keys.yaml")
+		ioutil.WriteFile(fileName, []byte(yaml), 0600)
+		ring, err := loadJWTKeyFile(fileName)
+		if err != nil {
+			t.Fatal("Unable to load key file:", err)
+		}
+		return ring
+	}
+	saved := jwtKeys
+	defer func() { jwtKeys = saved }()
+	expires := time.Now().Add(time.Minute)
+
+	jwtKeys = keyFile("keys:\n  - {kid: old, alg: RS256, file: rsa.pem}\n")
+	oldToken, err := UserGetAuthToken("tester@example.com", expires)
+	if err != nil {
+		t.Fatal("Unable to sign with RS256:", err)
+	}
+
+	// rotate to an EdDSA key keeping the old key to verify with
+	jwtKeys = keyFile("signing: new\nkeys:\n  - {kid: old, alg: RS256, file: rsa-public.pem}\n  - {kid: new, alg: EdDSA, file: ed.pem}\n")
+	newToken, err := UserGetAuthToken("tester@example.com", expires)
+	if err != nil {
+		t.Fatal("Unable to sign with EdDSA:", err)
+	}
+	for _, token := range []string{oldToken, newToken} {
+		if username, errId := UserValidateAuthToken(token); errId != success || username != "tester@example.com" {
+			t.Error("Expected tokens signed with either key to be valid but got", errId)
+		}
+	}
+	if len(jwtKeys.jwks().Keys) != 2 {
+		t.Error("Expected both public keys to be published but found", jwtKeys.jwks().Keys)
+	}
+
+	// once the old key is dropped its tokens are no longer valid
+	jwtKeys = keyFile("keys:\n  - {kid: new, alg: EdDSA, file: ed.pem}\n")
+	if _, errId := UserValidateAuthToken(oldToken); errId == success {
+		t.Error("Expected a token signed with a dropped key to be refused")
+	}
+
+	// an HS256 token "signed" with an RSA public key must not pass as RS256
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{Username: "tester@example.com"})
+	forged.Header["kid"] = "old"
+	forgedToken, _ := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPublicDER}))
+	jwtKeys = keyFile("signing: new\nkeys:\n  - {kid: old, alg: RS256, file: rsa-public.pem}\n  - {kid: new, alg: EdDSA, file: ed.pem}\n")
+	if _, errId := UserValidateAuthToken(forgedToken); errId == success {
+		t.Error("Expected a token claiming the wrong algorithm to be refused")
+	}
+}
+
+// a secret in the environment is held to the same minimum length as one
+// in a key file, so a server given a short one refuses to start
+func TestJWTKeyEnvSecret(t *testing.T) {
+	saved := jwtKeys
+	defer func() { jwtKeys = saved }()
+	t.Setenv(JWT_KEY_FILE_ENV, "")
+
+	t.Setenv(JWT_KEY_ENV, "too-short")
+	if err := initJWTKeys(); err == nil {
+		t.Error("Expected a short secret to be refused")
+	}
+	t.Setenv(JWT_KEY_ENV, strings.Repeat("s", jwtMinSecretLength))
+	if err := initJWTKeys(); err != nil || jwtKeys.signing.kid != "env" {
+		t.Error("Expected a long enough secret to be used but got", err)
+	}
+}
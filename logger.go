@@ -52,7 +52,7 @@ func Logger(inner http.Handler, name string) http.Handler {
         log.Printf(
             "%s\t%s\t%s\t%d\t%s",
             r.Method,
-            r.RequestURI,
+            loggedURI(r),
             name,
             lrw.StatusCode(),
             time.Since(start))
@@ -60,6 +60,25 @@ func Logger(inner http.Handler, name string) http.Handler {
     })
 }
 
+// credentials belong in the body of a request, but in case a client puts
+// them in the query we keep them out of the log
+var loggedSecrets = []string{"password", "oldPassword", "newPassword", "token"}
+
+func loggedURI(r *http.Request) string {
+    q := r.URL.Query()
+    hidden := false
+    for _, key := range loggedSecrets {
+        if _, found := q[key]; found {
+            q.Set(key, "REDACTED")
+            hidden = true
+        }
+    }
+    if !hidden {
+        return r.RequestURI
+    }
+    return r.URL.Path + "?" + q.Encode()
+}
+
 /*
 func CORS(inner http.Handler, name string) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,219 @@
+package main
+
+import (
+    "math"
+    "net"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+/*
+==============================================================================
+ Sign-in Protection
+------------------------------------------------------------------------------
+ Checking a password is slow on purpose (bcrypt), which makes sign-in an
+ easy target for anyone wanting to guess passwords or tie up the server.
+ So before checking any password we make sure the attempt is within limits:
+  - each IP address and each account has a token bucket of attempts that
+    refills at a steady rate, so bursts are allowed but a steady stream of
+    guesses is throttled (authThrottled)
+  - after loginLockoutFailures failed attempts in a row an account is
+    locked for loginLockoutTime, however many addresses the guesses come
+    from (authLocked)
+
+ Accounts are tracked by the email given, whether or not it belongs to a
+ user, so the responses never reveal which emails have accounts.  The limits
+ live in memory and reset when the server restarts.  Every attempt, allowed
+ or not, is also recorded in storage (see UserLogin).
+============================================================================*/
+const (
+    loginIPBurst          = 20               // attempts an address can make at once
+    loginIPRefill         = 3 * time.Second  // time for an address to get another
+    loginAccountBurst     = 10               // attempts on an account at once
+    loginAccountRefill    = time.Minute      // time for an account to get another
+    loginLockoutFailures  = 5                // failures in a row that lock an account
+    loginLockoutTime      = 15 * time.Minute // how long the account stays locked
+    loginGuardMaxEntries  = 10000            // forget idle addresses and accounts beyond this
+)
+
+// UserLogin: a sign-in attempt as recorded in storage
+type UserLogin struct {
+    UserId    string    // user signing in (empty if the email is unknown)
+    Email     string    // email given
+    IPAddress string    // address the attempt came from
+    Succeeded bool
+    CreatedAt time.Time
+}
+
+type tokenBucket struct {
+    tokens float64
+    last   time.Time
+}
+
+// take: use an attempt from the bucket if there is one, otherwise return
+// how long until there will be
+func (b *tokenBucket) take(burst int, refill time.Duration, now time.Time) (bool, time.Duration) {
+    if b.last.IsZero() {
+        b.tokens = float64(burst)
+    } else {
+        b.tokens = math.Min(float64(burst), b.tokens + float64(now.Sub(b.last)) / float64(refill))
+    }
+    b.last = now
+    if b.tokens < 1 {
+        return false, time.Duration((1 - b.tokens) * float64(refill))
+    }
+    b.tokens--
+    return true, 0
+}
+
+// full: true if the bucket has refilled completely (so can be forgotten)
+func (b *tokenBucket) full(burst int, refill time.Duration, now time.Time) bool {
+    return b.tokens + float64(now.Sub(b.last)) / float64(refill) >= float64(burst)
+}
+
+type loginAccount struct {
+    bucket      tokenBucket
+    failures    int       // failed attempts in a row
+    lockedUntil time.Time
+}
+
+type loginGuard struct {
+    mu       sync.Mutex
+    ips      map[string]*tokenBucket
+    accounts map[string]*loginAccount // lower case email -> account
+}
+
+func newLoginGuard() *loginGuard {
+    return &loginGuard{ips:make(map[string]*tokenBucket), accounts:make(map[string]*loginAccount)}
+}
+
+// the limits on signing in to this server
+var loginLimits = newLoginGuard()
+
+/*
+==============================================================================
+ Allow()
+------------------------------------------------------------------------------
+ Inputs:  ip    string        - address the attempt comes from
+          email string        - account the attempt is for
+          now   time.Time
+ Returns:       PimErrId      - success, authLocked or authThrottled
+                time.Duration - how long until another attempt could be
+                                allowed, if this one isn't
+
+ Check a sign-in attempt against the limits before checking its password.
+ The attempt uses up a token from the address and account buckets.
+============================================================================*/
+func (g *loginGuard) Allow(ip string, email string, now time.Time) (PimErrId, time.Duration) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    g.prune(now)
+
+    account := g.account(email)
+    if now.Before(account.lockedUntil) {
+        return authLocked, account.lockedUntil.Sub(now)
+    }
+    bucket := g.ips[ip]
+    if bucket == nil {
+        bucket = &tokenBucket{}
+        g.ips[ip] = bucket
+    }
+    if ok, wait := bucket.take(loginIPBurst, loginIPRefill, now); !ok {
+        return authThrottled, wait
+    }
+    if ok, wait := account.bucket.take(loginAccountBurst, loginAccountRefill, now); !ok {
+        return authThrottled, wait
+    }
+    return success, 0
+}
+
+// Record: note how an allowed attempt turned out, locking the account if
+// it has failed too many times in a row
+func (g *loginGuard) Record(email string, succeeded bool, now time.Time) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    account := g.account(email)
+    if succeeded {
+        account.failures = 0
+        return
+    }
+    account.failures++
+    if account.failures >= loginLockoutFailures {
+        account.failures = 0
+        account.lockedUntil = now.Add(loginLockoutTime)
+    }
+}
+
+func (g *loginGuard) account(email string) *loginAccount {
+    key := strings.ToLower(email)
+    account := g.accounts[key]
+    if account == nil {
+        account = &loginAccount{}
+        g.accounts[key] = account
+    }
+    return account
+}
+
+// prune: once we are tracking a lot of addresses and accounts forget the
+// ones that are back where they started.  If that isn't enough (a flood
+// of attempts from many addresses or on many accounts) forget the ones
+// least recently tried as well, down to half the limit so we don't do this
+// on every attempt - but never a locked account, or the flood would unlock
+// it
+func (g *loginGuard) prune(now time.Time) {
+    if len(g.ips) + len(g.accounts) < loginGuardMaxEntries {
+        return
+    }
+    for ip, bucket := range g.ips {
+        if bucket.full(loginIPBurst, loginIPRefill, now) {
+            delete(g.ips, ip)
+        }
+    }
+    for email, account := range g.accounts {
+        if account.failures == 0 && !now.Before(account.lockedUntil) && account.bucket.full(loginAccountBurst, loginAccountRefill, now) {
+            delete(g.accounts, email)
+        }
+    }
+    if len(g.ips) + len(g.accounts) < loginGuardMaxEntries {
+        return
+    }
+
+    type entry struct {
+        ip, email string
+        last      time.Time
+    }
+    var entries []entry
+    for ip, bucket := range g.ips {
+        entries = append(entries, entry{ip:ip, last:bucket.last})
+    }
+    for email, account := range g.accounts {
+        if !now.Before(account.lockedUntil) {
+            entries = append(entries, entry{email:email, last:account.bucket.last})
+        }
+    }
+    sort.Slice(entries, func(i, j int) bool {
+        return entries[i].last.Before(entries[j].last)
+    })
+    for _, e := range entries {
+        if len(g.ips) + len(g.accounts) <= loginGuardMaxEntries / 2 {
+            break
+        }
+        if e.ip != "" {
+            delete(g.ips, e.ip)
+        } else {
+            delete(g.accounts, e.email)
+        }
+    }
+}
+
+// requestIP: the address a request came from
+func requestIP(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
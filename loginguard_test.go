@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// repeated failures lock an account for a while and a flood of attempts
+// from one address is throttled
+func TestLoginGuard(t *testing.T) {
+	g := newLoginGuard()
+	now := time.Date(2026, time.October, 17, 9, 0, 0, 0, time.UTC)
+
+	for i := 0; i < loginLockoutFailures; i++ {
+		if errId, _ := g.Allow("10.0.0.1", "Tester@example.com", now); errId != success {
+			t.Fatal("Expected attempt", i, "to be allowed but got", errId)
+		}
+		g.Record("Tester@example.com", false, now)
+	}
+	if errId, wait := g.Allow("10.0.0.2", "tester@example.com", now); errId != authLocked || wait != loginLockoutTime {
+		t.Error("Expected the account to be locked from any address but got", errId, wait)
+	}
+	if errId, _ := g.Allow("10.0.0.2", "tester@example.com", now.Add(loginLockoutTime)); errId != success {
+		t.Error("Expected the account to unlock after the lockout but got", errId)
+	}
+
+	// a success clears the failures so far
+	g.Record("other@example.com", false, now)
+	g.Record("other@example.com", true, now)
+	for i := 0; i < loginLockoutFailures - 1; i++ {
+		g.Record("other@example.com", false, now)
+	}
+	if errId, _ := g.Allow("10.0.0.3", "other@example.com", now); errId != success {
+		t.Error("Expected failures before a success not to count but got", errId)
+	}
+
+	for i := 0; i < loginIPBurst; i++ {
+		g.Allow("10.0.0.4", "user"+string(rune('a'+i))+"@example.com", now)
+	}
+	if errId, wait := g.Allow("10.0.0.4", "new@example.com", now); errId != authThrottled || wait != loginIPRefill {
+		t.Error("Expected the address to be throttled after its burst but got", errId, wait)
+	}
+	if errId, _ := g.Allow("10.0.0.4", "new@example.com", now.Add(loginIPRefill)); errId != success {
+		t.Error("Expected the address to be allowed again once refilled but got", errId)
+	}
+}
+
+// a flood of attempts from distinct addresses on distinct accounts can't
+// grow the guard past its limit, nor free the account it is guessing at
+func TestLoginGuardFlood(t *testing.T) {
+	g := newLoginGuard()
+	now := time.Date(2026, time.October, 17, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < loginLockoutFailures; i++ {
+		g.Allow("10.0.0.1", "tester@example.com", now)
+		g.Record("tester@example.com", false, now)
+	}
+
+	for i := 0; i < 2*loginGuardMaxEntries; i++ {
+		now = now.Add(time.Millisecond)
+		g.Allow(fmt.Sprintf("10.%d.%d.%d", i>>16, (i>>8)&255, i&255), fmt.Sprintf("user%d@example.com", i), now)
+		if n := len(g.ips) + len(g.accounts); n > loginGuardMaxEntries+1 {
+			t.Fatal("Expected the guard to stay within its limit but it holds", n, "entries")
+		}
+	}
+	if errId, _ := g.Allow("10.0.0.2", "tester@example.com", now); errId != authLocked {
+		t.Error("Expected the account to stay locked through the flood but got", errId)
+	}
+}
+
+// a locked account is refused before its password is checked, even the
+// right one, and says when to come back
+func TestSigninLockout(t *testing.T) {
+	testRepository(t)
+	savedLimits := loginLimits
+	loginLimits = newLoginGuard()
+	defer func() { loginLimits = savedLimits }()
+
+	signin := func(password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/signin", strings.NewReader(`{"email":"tester@example.com","password":"`+password+`"}`))
+		w := httptest.NewRecorder()
+		UserSignin(w, r)
+		return w
+	}
+	for i := 0; i < loginLockoutFailures; i++ {
+		if w := signin("wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatal("Expected a wrong password to fail but got", w.Code)
+		}
+	}
+	w := signin("Correct-Horse-9")
+	if w.Code != http.StatusLocked || w.Header().Get("Retry-After") == "" {
+		t.Error("Expected the locked account to be refused with a Retry-After but got", w.Code, w.Header())
+	}
+}
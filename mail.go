@@ -0,0 +1,90 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+)
+
+/*
+==============================================================================
+ MailSender
+------------------------------------------------------------------------------
+ Anything that can deliver mail to our users (today just password resets).
+ Real delivery is left to an implementation over whatever service we end
+ up using - for running locally and testing there are two that deliver
+ nowhere but where a developer can read them:
+    MailSenderWriter - writes each message to a writer (e.g. stdout)
+    MailSenderFile   - writes each message to its own file in a directory
+
+ The server picks one with its -mail flag (see NewMailSender()).
+============================================================================*/
+type MailSender interface {
+    Send(to string, subject string, body string) error
+}
+
+// the sender the server delivers mail with
+var mailer MailSender = &MailSenderWriter{w:os.Stdout}
+
+// where our users reach the server - links in mail point here rather than
+// at whatever host a request claims to be for (see the -url flag)
+var publicURL = "https://localhost:4000"
+
+// mailMessage: a message as it would go over the wire
+func mailMessage(to string, subject string, body string, when time.Time) string {
+    return fmt.Sprintf("Date: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+                       when.Format(time.RFC1123Z), to, subject, strings.ReplaceAll(body, "\n", "\r\n"))
+}
+
+type MailSenderWriter struct {
+    mu sync.Mutex
+    w  io.Writer
+}
+
+func (ms *MailSenderWriter) Send(to string, subject string, body string) error {
+    ms.mu.Lock()
+    defer ms.mu.Unlock()
+    _, err := io.WriteString(ms.w, mailMessage(to, subject, body, time.Now()))
+    return err
+}
+
+type MailSenderFile struct {
+    dir string
+}
+
+// each message is named for when it was sent and who it was sent to so a
+// directory of them reads as an outbox
+func (ms *MailSenderFile) Send(to string, subject string, body string) error {
+    now := time.Now()
+    name := fmt.Sprintf("%s-%s.eml", now.Format("20060102-150405.000000000"), strings.Map(func(r rune) rune {
+        if r == '/' || r == '\\' || r == os.PathSeparator {
+            return '_'
+        }
+        return r
+    }, to))
+    return ioutil.WriteFile(filepath.Join(ms.dir, name), []byte(mailMessage(to, subject, body, now)), 0600)
+}
+
+/*
+==============================================================================
+ NewMailSender()
+------------------------------------------------------------------------------
+ Inputs:  where string - "stdout" or the directory to write messages into
+ Returns: MailSender   - the sender
+          error        - if the directory can't be used
+============================================================================*/
+func NewMailSender(where string) (MailSender, error) {
+    if where == "" || where == "stdout" {
+        return &MailSenderWriter{w:os.Stdout}, nil
+    }
+    if err := os.MkdirAll(where, 0700); err != nil {
+        return nil, errors.New(fmt.Sprintf("unable to use %s for mail: %s", where, err))
+    }
+    return &MailSenderFile{dir:where}, nil
+}
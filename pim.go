@@ -333,14 +333,6 @@ func runConsoleApp(dbName string) {
   }     
 }
 
-// for now keep a global - temp as we build our API
-// eventually we'll move this in somewhere else
-var storage TaskDataMapper
-var master *Task
-var commands *commandHistory
-
-var users Users
-
 func initKnownUsers(tdm TaskDataMapper) (Users, error) {
   return tdm.UserLoadAll()
 
@@ -357,7 +349,20 @@ func initKnownUsers(tdm TaskDataMapper) (Users, error) {
   */
 }
 
-func runServerApp(port string, files string, certs string, dbName string) {
+// rebuild each user's undo / redo history from the command journal, then
+// prune any journal entries older than the maximum age requested (from the
+// histories too, which may need to drop what depends on them)
+func initCommandHistories(tdm TaskDataMapper, us Users, root *Task, maxAge time.Duration) {
+  for _, u := range us {
+    err := u.History().Load(u, root)
+    if err != nil {
+      log.Printf("Unable to rebuild command history for %s: %s\n", u, err)
+    }
+  }
+  pruneCommandHistories(tdm, us, maxAge)
+}
+
+func runServerApp(port string, files string, certs string, dbName string, undoAge time.Duration) {
   log.Printf("Will run as server soon...\n")
 
   // initialize the backend storage mechanism requests
@@ -365,16 +370,39 @@ func runServerApp(port string, files string, certs string, dbName string) {
   if err != nil {
     log.Fatal(err)
   }
-  storage = tdm
+  repo.SetStorage(tdm)
+
+  // set up the keys access tokens are signed with
+  err = initJWTKeys()
+  if err != nil {
+    log.Fatal(err)
+  }
 
   // load up all known users - do first since tasks reference users
-  users, err = initKnownUsers(tdm)  
+  us, err := initKnownUsers(tdm)  
+  repo.SetUsers(us)
 
-  // initialize a master task (in a global for now)
-  master, err = initMasterTask(tdm)
+  // initialize the master task that holds all our tasks
+  master, err := initMasterTask(tdm)
   if err != nil {
     log.Fatal(err)
   } 
+  repo.SetMaster(master)
+
+  // now that tasks are loaded we can rebuild everyone's undo history
+  initCommandHistories(tdm, repo.Users(), repo.Master(), undoAge)
+
+  // forget the sessions of anyone who hasn't been back in a while
+  err = tdm.SessionPrune(time.Now().UTC())
+  if err != nil {
+    log.Printf("Unable to prune expired sessions: %s\n", err)
+  }
+
+  // roll everyone's today and this week tasks over at their midnight
+  go runRolloverScheduler(repo)
+
+  // keep pruning the journal as commands grow too old to undo
+  go runJournalPruner(repo, undoAge)
 
   // create an instance of our router with path to files
   router := NewRouter(files)
@@ -403,11 +431,17 @@ func main() {
   var certs_location        string
   var listenport            string
   var dbName                string
+  var undoAge               time.Duration
+  var mailTo                string
+  var url                   string
   flag.BoolVar(&server, "server", false, "start pim as web server rather than console app")
   flag.StringVar(&static_files_location, "html", "./client", "specify path to static web files on this server")
   flag.StringVar(&certs_location, "certs", ".", "specify path to TLS certificates on this server")
   flag.StringVar(&listenport, "port", "4000", "specify port on which the server will take requests")
   flag.StringVar(&dbName, "db", DB_NAME, "specify the database to use on the server or YAML")
+  flag.DurationVar(&undoAge, "undoage", 7 * 24 * time.Hour, "specify how long commands can be undone after a server restart")
+  flag.StringVar(&mailTo, "mail", "stdout", "specify where mail to users goes - stdout or a directory to write it into")
+  flag.StringVar(&url, "url", publicURL, "specify the public address of the server that links mailed to users point to")
   flag.Parse()
 
   // if we're starting as a server
@@ -425,7 +459,15 @@ func main() {
       listenport = ":" + listenport
     }
 
-    runServerApp(listenport, static_files_location, certs_location, dbName)
+    // decide where mail to users is delivered
+    sender, err := NewMailSender(mailTo)
+    if err != nil {
+      log.Fatal(err)
+    }
+    mailer = sender
+    publicURL = strings.TrimSuffix(url, "/")
+
+    runServerApp(listenport, static_files_location, certs_location, dbName, undoAge)
 
   } else {
 
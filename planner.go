@@ -0,0 +1,138 @@
+package main
+
+import (
+  "sort"
+  "time"
+)
+
+// CapacityPlan: how the work planned for a stretch of time compares to
+// the time the user has for it
+type CapacityPlan struct {
+  CapacityMinutes int `json:"capacityMinutes"` // working time available
+  LoadMinutes int     `json:"loadMinutes"`     // estimated work left on the tasks planned
+  OverMinutes int     `json:"overMinutes"`     // load beyond capacity (0 if it fits)
+  Unestimated int     `json:"unestimated"`     // tasks planned with no estimate (not in the load)
+}
+
+func (cp CapacityPlan) IsOverCommitted() bool {
+  return cp.OverMinutes > 0
+}
+
+func (cp *CapacityPlan) add(t *Task, now time.Time) {
+  if t.GetEstimate() == 0 {
+    cp.Unestimated++
+  }
+  cp.LoadMinutes += int(t.RemainingEstimate(now).Minutes())
+}
+
+func (cp *CapacityPlan) finish() {
+  cp.OverMinutes = 0
+  if cp.LoadMinutes > cp.CapacityMinutes {
+    cp.OverMinutes = cp.LoadMinutes - cp.CapacityMinutes
+  }
+}
+
+// RemainingEstimate: what is left of the estimate after the time already
+// spent on the task (never less than nothing)
+func (t *Task) RemainingEstimate(now time.Time) time.Duration {
+  remaining := t.GetEstimate() - t.TimeSpent(now)
+  if remaining < 0 {
+    return 0
+  }
+  return remaining
+}
+
+// Plan: the result of PlanCapacity()
+type Plan struct {
+  Date time.Time     // the day planned
+  Today CapacityPlan // tasks for today against today's capacity
+  Week CapacityPlan  // tasks for today and this week against the rest of the week
+  PushToWeek Tasks   // today tasks we suggest moving to this week
+}
+
+/*
+==============================================================================
+ PlanCapacity()
+------------------------------------------------------------------------------
+ Inputs:  u    *User     - whose capacity we plan against
+          list Tasks     - the user's tasks
+          now  time.Time - when we're planning
+ Returns: Plan           - load against capacity today and this week, and
+                           what to push out of today if it doesn't fit
+
+ The load is the estimated work left on the tasks that aren't complete
+ (their estimates less time already spent on them).  Today's tasks are
+ those FindToday() finds, and the week's are those for today or this week
+ against the capacity left in the week (today through the last day of the
+ user's week).
+
+ If today is over-committed we suggest tasks tagged for today to push to
+ this week until it fits: least important first, then those due latest
+ (or not at all).  Tasks due today or already being worked on (timer
+ running) stay, as do tasks without an estimate since moving them frees
+ no time we know of.
+============================================================================*/
+func PlanCapacity(u *User, list Tasks, now time.Time) Plan {
+  now = now.In(u.Location())
+  date := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+  plan := Plan{Date:date}
+
+  // capacity of today and what's left of the week
+  plan.Today.CapacityMinutes = int(u.GetCapacity(now.Weekday()).Minutes())
+  for day := now.Weekday(); ; day = (day + 1) % 7 {
+    plan.Week.CapacityMinutes += int(u.GetCapacity(day).Minutes())
+    if (day + 1) % 7 == u.GetWeekStart() {
+      break
+    }
+  }
+
+  // load of today and of the week (which includes today)
+  today := list.FindToday(now)
+  planned := make(map[*Task]bool)
+  for _, t := range today {
+    if !t.IsComplete() {
+      plan.Today.add(t, now)
+      plan.Week.add(t, now)
+      planned[t] = true
+    }
+  }
+  for _, t := range list.FindThisWeek(now, u.GetWeekStart()) {
+    if !t.IsComplete() && !planned[t] {
+      plan.Week.add(t, now)
+    }
+  }
+  plan.Today.finish()
+  plan.Week.finish()
+
+  // pick tasks to push until today fits
+  if plan.Today.IsOverCommitted() {
+    endOfDay := date.AddDate(0, 0, 1)
+    var candidates Tasks
+    for _, t := range today {
+      due := t.GetDueTime()
+      if !t.IsComplete() && t.IsTagSet("today") && t.RunningSession() == nil &&
+         t.RemainingEstimate(now) > 0 && (due == nil || !due.Before(endOfDay)) {
+        candidates = append(candidates, t)
+      }
+    }
+    sort.SliceStable(candidates, func(i, j int) bool {
+      a, b := candidates[i], candidates[j]
+      if a.GetPriority() != b.GetPriority() {
+        return a.GetPriority() < b.GetPriority()
+      }
+      if a.GetDueTime() == nil || b.GetDueTime() == nil {
+        return a.GetDueTime() == nil && b.GetDueTime() != nil
+      }
+      return a.GetDueTime().After(*b.GetDueTime())
+    })
+    over := plan.Today.OverMinutes
+    for _, t := range candidates {
+      if over <= 0 {
+        break
+      }
+      plan.PushToWeek = append(plan.PushToWeek, t)
+      over -= int(t.RemainingEstimate(now).Minutes())
+    }
+  }
+  return plan
+}
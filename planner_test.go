@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// an over-committed day suggests the least important pushable tasks
+func TestPlannerCapacity(t *testing.T) {
+	u := &User{}
+	u.SetCapacity(time.Friday, 4*time.Hour)
+	u.SetCapacity(time.Saturday, time.Hour)
+	now := time.Date(2026, time.October, 16, 9, 0, 0, 0, time.Local) // a Friday
+
+	today := func(name string, minutes int, priority int) *Task {
+		task := NewTask(name)
+		task.SetToday(true)
+		task.SetEstimate(time.Duration(minutes) * time.Minute)
+		task.SetPriority(priority)
+		return task
+	}
+	important := today("important", 120, 3)
+	dueToday := today("due today", 60, 0)
+	due := now.Add(3 * time.Hour)
+	dueToday.SetDueTime(&due)
+	minor := today("minor", 90, 0)
+	medium := today("medium", 60, 1)
+	unestimated := today("unestimated", 0, 0)
+	week := NewTask("this week")
+	week.SetThisWeek(true)
+	week.SetEstimate(30 * time.Minute)
+	list := Tasks{important, dueToday, minor, medium, unestimated, week}
+
+	plan := PlanCapacity(u, list, now)
+	if plan.Today.CapacityMinutes != 240 || plan.Today.LoadMinutes != 330 || plan.Today.OverMinutes != 90 || plan.Today.Unestimated != 1 {
+		t.Error("Unexpected plan for today:", plan.Today)
+	}
+	if plan.Week.CapacityMinutes != 300 || plan.Week.LoadMinutes != 360 || !plan.Week.IsOverCommitted() {
+		t.Error("Unexpected plan for the week:", plan.Week)
+	}
+	if len(plan.PushToWeek) != 1 || plan.PushToWeek[0] != minor {
+		t.Error("Expected to push only the minor task but found", plan.PushToWeek)
+	}
+
+	// time already spent comes off the load, and a day that fits pushes nothing
+	important.StartTimer(nil, now.Add(-2*time.Hour))
+	plan = PlanCapacity(u, list, now)
+	if plan.Today.LoadMinutes != 210 || plan.Today.IsOverCommitted() || len(plan.PushToWeek) != 0 {
+		t.Error("Expected today to fit once work was done but found", plan.Today, plan.PushToWeek)
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "strconv"
+  "strings"
+  "time"
+  "unicode"
+)
+
+/*
+==============================================================================
+ TaskQuery
+------------------------------------------------------------------------------
+ A small query language for finding tasks, e.g.
+
+    tag:Work state:inProgress due<2026-11-01 -tag:Personal "python"
+
+ Terms next to each other must all match (AND is implied but may be written)
+ and OR, NOT (or a leading -) and parentheses combine them as usual, with
+ NOT binding tightest and OR loosest.  The terms are:
+
+    tag:T                      the tag T is set on the task
+    state:S                    notStarted, complete, inProgress or onHold
+    due, target, started,      compared with a YYYY-MM-DD date (a whole day
+    completed, created         in the user's time zone) or an RFC3339 time
+                               using : or = (on) < <= > or >=
+    estimate                   compared with minutes (30) or a duration (1h30m)
+                               using : = < <= > or >=
+    name:X, X or "X Y"         the task's name contains the text (any case)
+
+ Tasks without the date asked about never match it, and tasks without an
+ estimate have an estimate of 0.
+
+ A query is parsed once into a tree of QueryNodes that can be evaluated in
+ memory (Match()) or translated by a data mapper into its own query language
+ (see TaskDataMapperPostgreSQL.TaskQuery()).
+============================================================================*/
+type TaskQuery struct {
+  Root QueryNode
+}
+
+// TaskQuerier: a data mapper that can run a query in its own storage
+// rather than us running it over the tasks in memory
+type TaskQuerier interface {
+  TaskQuery(q *TaskQuery, u *User) ([]string, error) // ids of the user's matching tasks
+}
+
+// QueryNode: one node of a parsed query
+type QueryNode interface {
+  Match(t *Task) bool
+}
+
+type queryAnd struct {
+  nodes []QueryNode // all must match (true if there are none)
+}
+type queryOr struct {
+  nodes []QueryNode // any must match
+}
+type queryNot struct {
+  node QueryNode
+}
+type queryTag struct {
+  tag string
+}
+type queryState struct {
+  state TaskState
+}
+type queryTime struct {
+  field string     // one of queryTimeFields
+  from *time.Time  // matches times in [from, to) - nil is unbounded
+  to *time.Time
+}
+type queryEstimate struct {
+  op string        // = < <= > or >=
+  estimate time.Duration
+}
+type queryText struct {
+  text string      // found in the name regardless of case
+}
+
+// the times of a task a query can compare against
+var queryTimeFields = map[string]func(t *Task) *time.Time {
+  "due": (*Task).GetDueTime,
+  "target": (*Task).GetTargetStartTime,
+  "started": (*Task).GetActualStartTime,
+  "completed": (*Task).GetActualCompletionTime,
+  "created": (*Task).GetCreatedTime,
+}
+
+func (n *queryAnd) Match(t *Task) bool {
+  for _, node := range n.nodes {
+    if !node.Match(t) {
+      return false
+    }
+  }
+  return true
+}
+
+func (n *queryOr) Match(t *Task) bool {
+  for _, node := range n.nodes {
+    if node.Match(t) {
+      return true
+    }
+  }
+  return false
+}
+
+func (n *queryNot) Match(t *Task) bool {
+  return !n.node.Match(t)
+}
+
+func (n *queryTag) Match(t *Task) bool {
+  return t.IsTagSet(n.tag)
+}
+
+func (n *queryState) Match(t *Task) bool {
+  return t.GetState() == n.state
+}
+
+func (n *queryTime) Match(t *Task) bool {
+  when := queryTimeFields[n.field](t)
+  return when != nil && (n.from == nil || !when.Before(*n.from)) && (n.to == nil || when.Before(*n.to))
+}
+
+func (n *queryEstimate) Match(t *Task) bool {
+  estimate := t.GetEstimate()
+  switch n.op {
+  case "<":
+    return estimate < n.estimate
+  case "<=":
+    return estimate <= n.estimate
+  case ">":
+    return estimate > n.estimate
+  case ">=":
+    return estimate >= n.estimate
+  }
+  return estimate == n.estimate
+}
+
+func (n *queryText) Match(t *Task) bool {
+  return strings.Contains(strings.ToLower(t.GetName()), strings.ToLower(n.text))
+}
+
+func (q *TaskQuery) Match(t *Task) bool {
+  return q.Root.Match(t)
+}
+
+// return a list of all tasks in the list that match the query
+func (list Tasks) FindMatching(q *TaskQuery) Tasks {
+  var result Tasks
+  for _, curr := range list {
+    if q.Match(curr) {
+      result = append(result, curr)
+    }
+  }
+  return result
+}
+
+/*
+==============================================================================
+ ParseTaskQuery()
+------------------------------------------------------------------------------
+ Inputs:  q   string         - the query (see TaskQuery)
+          loc *time.Location - time zone dates in the query are in
+ Returns: *TaskQuery         - the parsed query (an empty query matches all)
+          error              - what is wrong with the query if it can't be
+                               parsed
+============================================================================*/
+func ParseTaskQuery(q string, loc *time.Location) (*TaskQuery, error) {
+  tokens, err := queryTokens(q)
+  if err != nil {
+    return nil, err
+  }
+  if len(tokens) == 0 {
+    return &TaskQuery{Root:&queryAnd{}}, nil
+  }
+  p := &queryParser{tokens:tokens, loc:loc}
+  root, err := p.parseOr()
+  if err != nil {
+    return nil, err
+  }
+  if p.pos < len(p.tokens) {
+    return nil, errors.New(fmt.Sprintf("unexpected '%s' in query", p.tokens[p.pos]))
+  }
+  return &TaskQuery{Root:root}, nil
+}
+
+// queryTokens: split a query into words, parentheses and leading -'s,
+// keeping quoted text (quotes and all) together in its word
+func queryTokens(q string) ([]string, error) {
+  var tokens []string
+  runes := []rune(q)
+  for i := 0; i < len(runes); {
+    r := runes[i]
+    switch {
+    case unicode.IsSpace(r):
+      i++
+    case r == '(' || r == ')':
+      tokens = append(tokens, string(r))
+      i++
+    case r == '-' && i + 1 < len(runes) && !unicode.IsSpace(runes[i+1]) && runes[i+1] != ')':
+      tokens = append(tokens, "-")
+      i++
+    default:
+      start := i
+      quoted := false
+      for ; i < len(runes); i++ {
+        if runes[i] == '"' {
+          quoted = !quoted
+        } else if !quoted && (unicode.IsSpace(runes[i]) || runes[i] == '(' || runes[i] == ')') {
+          break
+        }
+      }
+      if quoted {
+        return nil, errors.New("query has an unterminated quote")
+      }
+      tokens = append(tokens, string(runes[start:i]))
+    }
+  }
+  return tokens, nil
+}
+
+type queryParser struct {
+  tokens []string
+  pos int
+  loc *time.Location
+}
+
+func (p *queryParser) peek() string {
+  if p.pos < len(p.tokens) {
+    return p.tokens[p.pos]
+  }
+  return ""
+}
+
+// or := and ("OR" and)*
+func (p *queryParser) parseOr() (QueryNode, error) {
+  var nodes []QueryNode
+  for {
+    node, err := p.parseAnd()
+    if err != nil {
+      return nil, err
+    }
+    nodes = append(nodes, node)
+    if p.peek() != "OR" {
+      break
+    }
+    p.pos++
+  }
+  if len(nodes) == 1 {
+    return nodes[0], nil
+  }
+  return &queryOr{nodes:nodes}, nil
+}
+
+// and := unary (["AND"] unary)*
+func (p *queryParser) parseAnd() (QueryNode, error) {
+  var nodes []QueryNode
+  for p.pos < len(p.tokens) && p.peek() != ")" && p.peek() != "OR" {
+    if p.peek() == "AND" {
+      p.pos++
+      continue
+    }
+    node, err := p.parseUnary()
+    if err != nil {
+      return nil, err
+    }
+    nodes = append(nodes, node)
+  }
+  if len(nodes) == 0 {
+    return nil, errors.New("query is missing a term")
+  }
+  if len(nodes) == 1 {
+    return nodes[0], nil
+  }
+  return &queryAnd{nodes:nodes}, nil
+}
+
+// unary := ("NOT" | "-") unary | "(" or ")" | term
+func (p *queryParser) parseUnary() (QueryNode, error) {
+  token := p.peek()
+  p.pos++
+  switch token {
+  case "", "AND", "OR", ")":
+    return nil, errors.New("query is missing a term")
+  case "NOT", "-":
+    node, err := p.parseUnary()
+    if err != nil {
+      return nil, err
+    }
+    return &queryNot{node:node}, nil
+  case "(":
+    node, err := p.parseOr()
+    if err != nil {
+      return nil, err
+    }
+    if p.peek() != ")" {
+      return nil, errors.New("query is missing a ')'")
+    }
+    p.pos++
+    return node, nil
+  }
+  return p.parseTerm(token)
+}
+
+// unquote: remove the quotes around a value
+func unquote(s string) string {
+  return strings.ReplaceAll(s, "\"", "")
+}
+
+// term := field op value | text
+func (p *queryParser) parseTerm(token string) (QueryNode, error) {
+  // a field is the letters up to an operator - anything else is text
+  i := strings.IndexFunc(token, func(r rune) bool { return !unicode.IsLetter(r) })
+  if i <= 0 || !strings.ContainsRune(":<>=", rune(token[i])) {
+    return &queryText{text:unquote(token)}, nil
+  }
+  field := strings.ToLower(token[:i])
+  op := token[i:i+1]
+  if i + 1 < len(token) && token[i+1] == '=' && (op == "<" || op == ">") {
+    op += "="
+  }
+  value := unquote(token[i+len(op):])
+  if value == "" {
+    return nil, errors.New(fmt.Sprintf("'%s' in query has no value", token))
+  }
+
+  switch {
+  case field == "tag" && op == ":":
+    return &queryTag{tag:value}, nil
+
+  case field == "name" && op == ":":
+    return &queryText{text:value}, nil
+
+  case field == "state" && (op == ":" || op == "="):
+    for i, s := range stateStrings {
+      if strings.EqualFold(s, value) {
+        return &queryState{state:TaskState(i)}, nil
+      }
+    }
+    return nil, errors.New(fmt.Sprintf("'%s' is not a state - use one of %s", value, strings.Join(stateStrings, ", ")))
+
+  case field == "estimate":
+    estimate, err := time.ParseDuration(value)
+    if err != nil {
+      minutes, errMinutes := strconv.Atoi(value)
+      if errMinutes != nil {
+        return nil, errors.New(fmt.Sprintf("estimate '%s' must be minutes or a duration like 1h30m", value))
+      }
+      estimate = time.Duration(minutes) * time.Minute
+    }
+    if op == ":" {
+      op = "="
+    }
+    return &queryEstimate{op:op, estimate:estimate}, nil
+
+  case queryTimeFields[field] != nil:
+    return p.parseTime(field, op, value)
+  }
+  return nil, errors.New(fmt.Sprintf("'%s' is not a term we understand", token))
+}
+
+// a date stands for the whole day and a time for just that instant, so
+// each is a range [start, end) that the operator compares against
+func (p *queryParser) parseTime(field string, op string, value string) (QueryNode, error) {
+  start, err := time.Parse(time.RFC3339, value)
+  end := start.Add(time.Nanosecond)
+  if err != nil {
+    start, err = time.ParseInLocation("2006-01-02", value, p.loc)
+    if err != nil {
+      return nil, errors.New(fmt.Sprintf("%s '%s' must be a YYYY-MM-DD date or an RFC3339 time", field, value))
+    }
+    end = start.AddDate(0, 0, 1)
+  }
+  n := &queryTime{field:field}
+  switch op {
+  case ":", "=":
+    n.from, n.to = &start, &end
+  case "<":
+    n.to = &start
+  case "<=":
+    n.to = &end
+  case ">":
+    n.from = &end
+  case ">=":
+    n.from = &start
+  }
+  return n, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// queries combine tags, state, dates, estimates and text with AND, OR and NOT
+func TestQueryMatch(t *testing.T) {
+	day := func(d int) *time.Time {
+		when := time.Date(2026, time.October, d, 12, 0, 0, 0, time.UTC)
+		return &when
+	}
+	add := func(name string, state TaskState, due *time.Time, minutes int, tags ...string) *Task {
+		task := NewTask(name)
+		task.SetState(state)
+		task.SetDueTime(due)
+		task.SetEstimate(time.Duration(minutes) * time.Minute)
+		for _, tag := range tags {
+			task.SetTag(tag)
+		}
+		return task
+	}
+	report := add("Write the Python report", inProgress, day(20), 90, "Work")
+	slides := add("Slides for the review", notStarted, day(31), 30, "Work", "Personal")
+	taxes := add("Do taxes", inProgress, nil, 0, "Personal")
+	list := Tasks{report, slides, taxes}
+
+	expect := func(q string, expected ...*Task) {
+		tq, err := ParseTaskQuery(q, time.UTC)
+		if err != nil {
+			t.Error("Unable to parse", q, ":", err)
+			return
+		}
+		found := list.FindMatching(tq)
+		if len(found) != len(expected) {
+			t.Error("Query", q, "expected", len(expected), "tasks but found", len(found))
+			return
+		}
+		for i := range expected {
+			if found[i] != expected[i] {
+				t.Error("Query", q, "expected", expected[i].GetName(), "but found", found[i].GetName())
+			}
+		}
+	}
+	expect(`tag:Work state:inProgress due<2026-11-01 -tag:Personal "python"`, report)
+	expect(`tag:Personal OR estimate>=1h`, report, slides, taxes)
+	expect(`NOT (tag:Work AND estimate<60)`, report, taxes)
+	expect(`due:2026-10-31`, slides)
+	expect(`due<=2026-10-20`, report)
+	expect(`-due>2026-10-20`, report, taxes)
+	expect(`name:"the review" OR state:complete`, slides)
+	expect(``, report, slides, taxes)
+
+	for _, bad := range []string{`tag:`, `state:finished`, `(tag:Work`, `due<soon`, `estimate>lots`, `"open quote`, `tag:Work OR`, `color:red`} {
+		if _, err := ParseTaskQuery(bad, time.UTC); err == nil {
+			t.Error("Expected an error parsing", bad)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// RecurFreq: how often a recurring task comes back
+type RecurFreq int
+const (
+  daily RecurFreq = iota
+  weekly
+  monthly
+)
+var recurFreqStrings = []string{"DAILY", "WEEKLY", "MONTHLY"}
+func (f RecurFreq) String() string {
+  return recurFreqStrings[f]
+}
+
+// weekday abbreviations as used by RRULE's BYDAY
+var recurDayStrings = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// format of RRULE's UNTIL
+const recurUntilFormat = "20060102T150405Z"
+
+/*
+==============================================================================
+ Recurrence
+------------------------------------------------------------------------------
+ A rule for when a recurring task comes back, modeled on (a small part of)
+ the iCalendar RRULE.  A rule is written as a string such as:
+
+   FREQ=DAILY
+   FREQ=WEEKLY;INTERVAL=2
+   FREQ=WEEKLY;BYDAY=MO,TH
+   FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR
+   FREQ=MONTHLY;UNTIL=20271231T000000Z
+   FREQ=MONTHLY;BYMONTHDAY=31
+
+ which is also how it is stored and how it appears in the API.  INTERVAL is
+ the number of days, weeks or months between occurrences (default 1).  BYDAY
+ limits a daily or weekly rule to the weekdays listed.  BYMONTHDAY is the
+ day of the month a monthly rule comes back on - a monthly rule without one
+ is pinned to the day of its first occurrence (see AnchoredTo()).  UNTIL is
+ the last time an occurrence may be scheduled.
+
+ Rules are never changed once made - set a new rule on the task instead -
+ so tasks can share them.
+============================================================================*/
+type Recurrence struct {
+  freq RecurFreq
+  interval int
+  byDay []time.Weekday
+  byMonthDay int // monthly only, 0 if not pinned to a day
+  until *time.Time
+}
+
+func NewRecurrence(freq RecurFreq, interval int, byDay []time.Weekday, until *time.Time) (*Recurrence, error) {
+  if freq < daily || freq > monthly {
+    return nil, errors.New("recurrence: unknown frequency")
+  }
+  if interval < 1 {
+    return nil, errors.New("recurrence: interval must be at least 1")
+  }
+  if len(byDay) > 0 && freq == monthly {
+    return nil, errors.New("recurrence: BYDAY is only supported on DAILY and WEEKLY rules")
+  }
+  r := &Recurrence{freq:freq, interval:interval, until:copyTime(until)}
+  r.byDay = append(r.byDay, byDay...)
+  return r, nil
+}
+
+// ParseRecurrence: build a rule from its RRULE-style string form
+func ParseRecurrence(rule string) (*Recurrence, error) {
+  freq := RecurFreq(-1)
+  interval := 1
+  var byDay []time.Weekday
+  byMonthDay := 0
+  var until *time.Time
+
+  for _, part := range strings.Split(strings.TrimSpace(rule), ";") {
+    kv := strings.SplitN(part, "=", 2)
+    if len(kv) != 2 {
+      return nil, errors.New(fmt.Sprintf("recurrence: could not parse <%s>", part))
+    }
+    key, value := strings.ToUpper(strings.TrimSpace(kv[0])), strings.ToUpper(strings.TrimSpace(kv[1]))
+    switch key {
+    case "FREQ":
+      freq = RecurFreq(findString(recurFreqStrings, value))
+      if freq < 0 {
+        return nil, errors.New(fmt.Sprintf("recurrence: unsupported FREQ <%s>", value))
+      }
+    case "INTERVAL":
+      n, err := strconv.Atoi(value)
+      if err != nil {
+        return nil, errors.New(fmt.Sprintf("recurrence: INTERVAL <%s> is not a number", value))
+      }
+      interval = n
+    case "BYDAY":
+      for _, day := range strings.Split(value, ",") {
+        i := findString(recurDayStrings, strings.TrimSpace(day))
+        if i < 0 {
+          return nil, errors.New(fmt.Sprintf("recurrence: unknown BYDAY day <%s>", day))
+        }
+        byDay = append(byDay, time.Weekday(i))
+      }
+    case "BYMONTHDAY":
+      n, err := strconv.Atoi(value)
+      if err != nil || n < 1 || n > 31 {
+        return nil, errors.New(fmt.Sprintf("recurrence: BYMONTHDAY <%s> must be a day of the month from 1 to 31", value))
+      }
+      byMonthDay = n
+    case "UNTIL":
+      u, err := time.Parse(recurUntilFormat, value)
+      if err != nil {
+        u, err = time.Parse("20060102", value)
+      }
+      if err != nil {
+        return nil, errors.New(fmt.Sprintf("recurrence: UNTIL <%s> must be YYYYMMDD or YYYYMMDDTHHMMSSZ", value))
+      }
+      until = &u
+    default:
+      return nil, errors.New(fmt.Sprintf("recurrence: unsupported rule part <%s>", key))
+    }
+  }
+  if freq < 0 {
+    return nil, errors.New("recurrence: FREQ is required")
+  }
+  r, err := NewRecurrence(freq, interval, byDay, until)
+  if err != nil || byMonthDay == 0 {
+    return r, err
+  }
+  if freq != monthly {
+    return nil, errors.New("recurrence: BYMONTHDAY is only supported on MONTHLY rules")
+  }
+  r.byMonthDay = byMonthDay
+  return r, nil
+}
+
+// AnchoredTo: the rule with its monthly occurrences pinned to the day of
+// the month of the time given, unless they already are (any other rule
+// comes back as it is).  Without the pin a rule begun on the 31st would
+// move to the 28th after February and stay there.
+func (r *Recurrence) AnchoredTo(t time.Time) *Recurrence {
+  if r.freq != monthly || r.byMonthDay != 0 {
+    return r
+  }
+  anchored := *r
+  anchored.byMonthDay = t.Day()
+  return &anchored
+}
+
+// String: the RRULE-style form of the rule (see ParseRecurrence)
+func (r *Recurrence) String() string {
+  if r == nil {
+    return ""
+  }
+  parts := []string{"FREQ=" + r.freq.String()}
+  if r.interval != 1 {
+    parts = append(parts, "INTERVAL=" + strconv.Itoa(r.interval))
+  }
+  if len(r.byDay) > 0 {
+    days := make([]string, len(r.byDay))
+    for i, d := range r.byDay {
+      days[i] = recurDayStrings[d]
+    }
+    parts = append(parts, "BYDAY=" + strings.Join(days, ","))
+  }
+  if r.byMonthDay != 0 {
+    parts = append(parts, "BYMONTHDAY=" + strconv.Itoa(r.byMonthDay))
+  }
+  if r.until != nil {
+    parts = append(parts, "UNTIL=" + r.until.UTC().Format(recurUntilFormat))
+  }
+  return strings.Join(parts, ";")
+}
+
+func (r *Recurrence) GetUntil() *time.Time {
+  return copyTime(r.until)
+}
+
+// onDay: true if the rule allows an occurrence on the weekday of the time
+func (r *Recurrence) onDay(t time.Time) bool {
+  if len(r.byDay) == 0 {
+    return true
+  }
+  for _, d := range r.byDay {
+    if t.Weekday() == d {
+      return true
+    }
+  }
+  return false
+}
+
+// weekOf: the Sunday starting the week the time is in
+func weekOf(t time.Time) time.Time {
+  return weekStarting(t, time.Sunday)
+}
+
+// weekStarting: midnight of the first day of the week the time is in, for
+// weeks that start on the given day
+func weekStarting(t time.Time, start time.Weekday) time.Time {
+  days := (int(t.Weekday()) - int(start) + 7) % 7
+  return time.Date(t.Year(), t.Month(), t.Day() - days, 0, 0, 0, 0, t.Location())
+}
+
+/*
+==============================================================================
+ Next()
+------------------------------------------------------------------------------
+ Inputs:  prev time.Time - when the previous occurrence was scheduled
+ Returns: *time.Time     - when the next occurrence is scheduled, or nil if
+                           the rule has ended
+
+ Occurrences keep the time of day of the previous one.  Monthly rules come
+ back on their BYMONTHDAY (or the day of the previous occurrence if they
+ have none), and use the last day of a month that doesn't have that day
+ (e.g. the 31st) without losing the day for later months.
+============================================================================*/
+func (r *Recurrence) Next(prev time.Time) *time.Time {
+  var next time.Time
+  switch r.freq {
+  case daily:
+    next = prev.AddDate(0, 0, r.interval)
+    for i := 0; i < 7 && !r.onDay(next); i++ {
+      next = next.AddDate(0, 0, 1)
+    }
+  case weekly:
+    if len(r.byDay) == 0 {
+      next = prev.AddDate(0, 0, 7 * r.interval)
+    } else {
+      // walk forward a day at a time to the next allowed day in a week
+      // that is a whole number of intervals from the previous week
+      start := weekOf(prev)
+      next = prev.AddDate(0, 0, 1)
+      for {
+        weeks := int(weekOf(next).Sub(start).Hours() + 12) / (24 * 7)
+        if weeks % r.interval == 0 && r.onDay(next) {
+          break
+        }
+        next = next.AddDate(0, 0, 1)
+      }
+    }
+  case monthly:
+    first := time.Date(prev.Year(), prev.Month() + time.Month(r.interval), 1, prev.Hour(), prev.Minute(), prev.Second(), prev.Nanosecond(), prev.Location())
+    lastDay := first.AddDate(0, 1, -1).Day()
+    day := r.byMonthDay
+    if day == 0 {
+      day = prev.Day()
+    }
+    if day > lastDay {
+      day = lastDay
+    }
+    next = first.AddDate(0, 0, day - 1)
+  }
+
+  if r.until != nil && next.After(*r.until) {
+    return nil
+  }
+  return &next
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rules survive a trip through their string form and bad rules are refused
+func TestRecurrenceParse(t *testing.T) {
+	rules := []string{
+		"FREQ=DAILY",
+		"FREQ=WEEKLY;INTERVAL=2",
+		"FREQ=WEEKLY;BYDAY=MO,TH",
+		"FREQ=MONTHLY;UNTIL=20271231T000000Z",
+		"FREQ=MONTHLY;BYMONTHDAY=31",
+	}
+	for _, rule := range rules {
+		r, err := ParseRecurrence(rule)
+		if err != nil {
+			t.Error("Unable to parse rule", rule, ":", err)
+		} else if r.String() != rule {
+			t.Error("Rule", rule, "came back as", r.String())
+		}
+	}
+
+	bad := []string{"", "FREQ=YEARLY", "INTERVAL=2", "FREQ=DAILY;INTERVAL=0", "FREQ=MONTHLY;BYDAY=MO", "FREQ=DAILY;BYDAY=XX", "FREQ=WEEKLY;BYMONTHDAY=3", "FREQ=MONTHLY;BYMONTHDAY=32"}
+	for _, rule := range bad {
+		if _, err := ParseRecurrence(rule); err == nil {
+			t.Error("Expected rule", rule, "to be refused")
+		}
+	}
+}
+
+// each kind of rule schedules the occurrence after a given one
+func TestRecurrenceNext(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 9, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		rule string
+		prev time.Time
+		next time.Time
+	}{
+		{"FREQ=DAILY", day(2026, time.October, 16), day(2026, time.October, 17)},
+		{"FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR", day(2026, time.October, 16), day(2026, time.October, 19)},
+		{"FREQ=WEEKLY;INTERVAL=2", day(2026, time.October, 16), day(2026, time.October, 30)},
+		{"FREQ=WEEKLY;BYDAY=MO,TH", day(2026, time.October, 19), day(2026, time.October, 22)},
+		{"FREQ=WEEKLY;BYDAY=MO,TH", day(2026, time.October, 22), day(2026, time.October, 26)},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", day(2026, time.October, 19), day(2026, time.November, 2)},
+		{"FREQ=MONTHLY", day(2026, time.January, 31), day(2026, time.February, 28)},
+		{"FREQ=MONTHLY;BYMONTHDAY=31", day(2026, time.February, 28), day(2026, time.March, 31)},
+		{"FREQ=MONTHLY;BYMONTHDAY=31", day(2026, time.March, 31), day(2026, time.April, 30)},
+	}
+	for _, test := range tests {
+		r, _ := ParseRecurrence(test.rule)
+		next := r.Next(test.prev)
+		if next == nil || !next.Equal(test.next) {
+			t.Error("Rule", test.rule, "after", test.prev, "expected", test.next, "but found", next)
+		}
+	}
+
+	r, _ := ParseRecurrence("FREQ=DAILY;UNTIL=20261017")
+	if next := r.Next(day(2026, time.October, 16)); next != nil {
+		t.Error("Expected rule to have ended but found next occurrence", next)
+	}
+}
+
+// a task due on the 31st of every month comes back on the last day of the
+// shorter months without drifting to that day for good
+func TestRecurrenceMonthEnd(t *testing.T) {
+	start := time.Date(2026, time.January, 31, 9, 0, 0, 0, time.UTC)
+	task := NewTaskMemoryOnly("pay rent")
+	task.SetTargetStartTime(&start)
+	r, _ := ParseRecurrence("FREQ=MONTHLY")
+	task.SetRecurrence(r)
+
+	var found []string
+	for i := 0; i < 3; i++ {
+		task = task.NextOccurrence()
+		found = append(found, task.GetTargetStartTime().Format("Jan 2"))
+	}
+	if strings.Join(found, ", ") != "Feb 28, Mar 31, Apr 30" {
+		t.Error("Expected the rule to keep to the 31st but found", found)
+	}
+	if task.GetRecurrence().String() != "FREQ=MONTHLY;BYMONTHDAY=31" {
+		t.Error("Expected the rule to be pinned to the 31st but found", task.GetRecurrence())
+	}
+}
+
+// completing a recurring task creates the next occurrence under the same
+// parent, and one undo takes back both
+func TestRecurrenceComplete(t *testing.T) {
+	u := &User{}
+	parent := NewTaskMemoryOnly("parent")
+	parent.SetDataMapper(NewTaskDataMapperYAML(filepath.Join(t.TempDir(), "tasks.yaml")))
+	task := NewTaskMemoryOnly("water the plants")
+	parent.AddChild(task)
+	start := time.Date(2026, time.October, 16, 9, 0, 0, 0, time.UTC)
+	task.SetTargetStartTime(&start)
+	r, _ := ParseRecurrence("FREQ=WEEKLY")
+	task.SetRecurrence(r)
+	task.SetNotes("Fern: **twice** as much")
+
+	cmd := CommandModifyTaskBegin(task)
+	task.SetState(complete)
+	if err := CommandModifyTaskEnd(u, cmd, task); err != nil {
+		t.Fatal("Unable to complete task:", err)
+	}
+	if parent.NumChildren() != 2 {
+		t.Fatal("Expected next occurrence to be added to the parent but found", parent.NumChildren(), "children")
+	}
+	next := parent.kids[1]
+	if next.GetName() != task.GetName() || next.GetNotes() != task.GetNotes() || next.IsComplete() || !next.IsRecurring() {
+		t.Error("Next occurrence not set up like the completed task")
+	}
+	if want := start.AddDate(0, 0, 7); next.GetTargetStartTime() == nil || !next.GetTargetStartTime().Equal(want) {
+		t.Error("Expected next occurrence at", want, "but found", next.GetTargetStartTime())
+	}
+	if task.IsRecurring() {
+		t.Error("Completed occurrence should hand its rule to the next occurrence")
+	}
+
+	if _, err := CommandUndo(u); err != nil {
+		t.Fatal("Unable to undo completion:", err)
+	}
+	if parent.NumChildren() != 1 || task.IsComplete() || !task.IsRecurring() {
+		t.Error("Undo did not take back the completion and the next occurrence")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "sort"
+  "time"
+)
+
+// ReportGroupBy: how the tasks in a summary report are grouped
+type ReportGroupBy int
+const (
+  groupByDay ReportGroupBy = iota
+  groupByWeek
+  groupByTag
+)
+var reportGroupByStrings = []string{"day", "week", "tag"}
+func (g ReportGroupBy) String() string {
+  return reportGroupByStrings[g]
+}
+func ReportGroupByFromString(s string) (ReportGroupBy, error) {
+  i := findString(reportGroupByStrings, s)
+  if i < 0 {
+    return groupByDay, errors.New(fmt.Sprintf("pim-report: cannot group by <%s>", s))
+  }
+  return ReportGroupBy(i), nil
+}
+
+// the group for tasks with no tags when grouping by tag
+const reportUntagged = "(untagged)"
+
+// ReportGroup: one line of a summary report
+type ReportGroup struct {
+  Key string            `json:"key"`             // day or week (YYYY-MM-DD) or tag
+  Completed int         `json:"completed"`       // number of tasks completed
+  EstimateMinutes int   `json:"estimateMinutes"` // total of their estimates
+  ActualMinutes int     `json:"actualMinutes"`   // total of their work sessions
+}
+
+func (g *ReportGroup) add(t *Task) {
+  g.Completed++
+  g.EstimateMinutes += int(t.GetEstimate().Minutes())
+  g.ActualMinutes += int(t.TimeSpent(*t.GetActualCompletionTime()).Minutes())
+}
+
+/*
+==============================================================================
+ Summarize()
+------------------------------------------------------------------------------
+ Inputs:  from, to  time.Time     - tasks completed in [from, to) are counted
+          groupBy   ReportGroupBy - day, week or tag
+          loc       *time.Location - where days and weeks start
+          weekStart time.Weekday  - the day weeks start on
+ Returns: the groups in order of their keys, and the total of all of them
+
+ The summary behind "what did I do": for each group how many tasks were
+ completed, what we estimated they would take and what they actually took,
+ as recorded by their work sessions.  Tasks are grouped by the day or week
+ they were completed (weeks are named by the day they start on) or
+ by each of their tags, in which case a task with many tags counts in each
+ of them and the total counts it only once.  System tags such as "today"
+ are not tags the user chose so they don't get groups.
+============================================================================*/
+func (list Tasks) Summarize(from time.Time, to time.Time, groupBy ReportGroupBy, loc *time.Location, weekStart time.Weekday) ([]ReportGroup, ReportGroup) {
+  groups := make(map[string]*ReportGroup)
+  total := ReportGroup{Key:"total"}
+  addTo := func(key string, t *Task) {
+    if groups[key] == nil {
+      groups[key] = &ReportGroup{Key:key}
+    }
+    groups[key].add(t)
+  }
+
+  for _, t := range list {
+    done := t.GetActualCompletionTime()
+    if done == nil || done.Before(from) || !done.Before(to) {
+      continue
+    }
+    total.add(t)
+    switch groupBy {
+    case groupByDay:
+      addTo(done.In(loc).Format("2006-01-02"), t)
+    case groupByWeek:
+      addTo(weekStarting(done.In(loc), weekStart).Format("2006-01-02"), t)
+    case groupByTag:
+      tagged := false
+      for _, tag := range t.GetTags() {
+        if !IsSystemTag(tag) {
+          addTo(tag, t)
+          tagged = true
+        }
+      }
+      if !tagged {
+        addTo(reportUntagged, t)
+      }
+    }
+  }
+
+  result := make([]ReportGroup, 0, len(groups))
+  for _, g := range groups {
+    result = append(result, *g)
+  }
+  sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+  return result, total
+}
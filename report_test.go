@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// completed tasks in the range are counted, estimated and timed by group
+func TestReportSummarize(t *testing.T) {
+	day := func(d int, hour int) time.Time {
+		return time.Date(2026, time.October, d, hour, 0, 0, 0, time.UTC)
+	}
+	done := func(name string, d int, estimate int, spent int, tags ...string) *Task {
+		task := NewTask(name)
+		task.SetEstimate(time.Duration(estimate) * time.Minute)
+		task.StartTimer(nil, day(d, 9))
+		task.StopTimer(day(d, 9).Add(time.Duration(spent) * time.Minute))
+		completed := day(d, 17)
+		task.SetState(complete)
+		task.SetActualCompletionTime(&completed)
+		for _, tag := range tags {
+			task.SetTag(tag)
+		}
+		return task
+	}
+	list := Tasks{
+		done("a", 12, 30, 45, "work"),             // Monday
+		done("b", 12, 60, 50, "work", "home"),
+		done("c", 16, 15, 20, "today"),            // Friday
+		done("d", 19, 10, 10, "work"),             // next Monday - outside the range
+		NewTask("not done"),
+	}
+
+	groups, total := list.Summarize(day(12, 0), day(17, 0), groupByDay, time.UTC, time.Sunday)
+	if len(groups) != 2 || groups[0].Key != "2026-10-12" || groups[1].Key != "2026-10-16" {
+		t.Fatal("Expected groups for the 12th and 16th but found", groups)
+	}
+	if groups[0].Completed != 2 || groups[0].EstimateMinutes != 90 || groups[0].ActualMinutes != 95 {
+		t.Error("Unexpected summary for the 12th:", groups[0])
+	}
+	if total.Completed != 3 || total.EstimateMinutes != 105 || total.ActualMinutes != 115 {
+		t.Error("Unexpected total:", total)
+	}
+
+	groups, _ = list.Summarize(day(12, 0), day(17, 0), groupByWeek, time.UTC, time.Sunday)
+	if len(groups) != 1 || groups[0].Key != "2026-10-11" || groups[0].Completed != 3 {
+		t.Error("Expected one week starting Sunday the 11th but found", groups)
+	}
+	groups, _ = list.Summarize(day(12, 0), day(17, 0), groupByWeek, time.UTC, time.Monday)
+	if len(groups) != 1 || groups[0].Key != "2026-10-12" {
+		t.Error("Expected one week starting Monday the 12th but found", groups)
+	}
+
+	groups, total = list.Summarize(day(12, 0), day(17, 0), groupByTag, time.UTC, time.Sunday)
+	if len(groups) != 3 || groups[0].Key != reportUntagged || groups[1].Key != "home" || groups[2].Key != "work" {
+		t.Fatal("Expected untagged, home and work groups but found", groups)
+	}
+	if groups[2].Completed != 2 || total.Completed != 3 {
+		t.Error("Expected tasks with many tags to count in each group but once in the total")
+	}
+}
+
+// subtasks completed in the range are reported along with top-level tasks
+func TestReportSummarySubtasks(t *testing.T) {
+	u, _ := testRepository(t)
+	completed := time.Date(2026, time.October, 14, 17, 0, 0, 0, time.UTC)
+	add := func(parent *Task, name string, done bool) *Task {
+		task := NewTask(name)
+		task.AddUser(u)
+		if done {
+			task.SetState(complete)
+			task.SetActualCompletionTime(&completed)
+		}
+		parent.AddChild(task)
+		return task
+	}
+	project := add(repo.Master(), "project", false)
+	add(project, "step", true)
+	add(repo.Master(), "chore", true)
+
+	vars := map[string]string{"from": "2026-10-12", "to": "2026-10-17"}
+	w := routeRequest(ReportSummary, u, "GET", vars, "")
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected a report but got", w.Code)
+	}
+	var report ReportSummaryJSON
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal("Unable to read the report:", err)
+	}
+	if report.Total.Completed != 2 {
+		t.Error("Expected the completed subtask and top-level task to be reported but found", report.Total.Completed)
+	}
+}
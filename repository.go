@@ -0,0 +1,89 @@
+package main
+
+import (
+    "net/http"
+    "sync"
+)
+
+/*
+==============================================================================
+ TaskRepository
+------------------------------------------------------------------------------
+ The repository owns the in-memory task graph: the master task at the root
+ of all tasks, the known users (and with them each user's command history)
+ and the data mapper used to store them.
+
+ HTTP handlers run on many goroutines at once, so all access goes through
+ the repository's lock.  Requests that only read hold it shared, while
+ anything that changes tasks or users holds it alone.  The router takes the
+ lock around each request (see Route.ReadOnly) so handlers don't have to,
+ and any other goroutine must use Read() or Write().
+============================================================================*/
+type TaskRepository struct {
+    mu      sync.RWMutex
+    storage TaskDataMapper // used to create new users and tasks
+    master  *Task          // memory-only parent of all top-level tasks
+    users   Users          // all known users
+}
+
+// the one repository for the server - empty until the server loads it
+var repo = NewTaskRepository(nil)
+
+func NewTaskRepository(storage TaskDataMapper) *TaskRepository {
+    return &TaskRepository{storage:storage}
+}
+
+func (r *TaskRepository) Storage() TaskDataMapper {
+    return r.storage
+}
+
+func (r *TaskRepository) SetStorage(storage TaskDataMapper) {
+    r.storage = storage
+}
+
+func (r *TaskRepository) Master() *Task {
+    return r.master
+}
+
+func (r *TaskRepository) SetMaster(master *Task) {
+    r.master = master
+}
+
+func (r *TaskRepository) Users() Users {
+    return r.users
+}
+
+func (r *TaskRepository) SetUsers(us Users) {
+    r.users = us
+}
+
+func (r *TaskRepository) AddUser(u *User) {
+    r.users = append(r.users, u)
+}
+
+// Read: run fn while holding the lock shared with other readers
+func (r *TaskRepository) Read(fn func()) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    fn()
+}
+
+// Write: run fn while holding the lock alone
+func (r *TaskRepository) Write(fn func()) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    fn()
+}
+
+// Guard: wrap a handler so each request runs holding the lock, shared
+// only if the handler promises not to change anything
+func (r *TaskRepository) Guard(inner http.Handler, readOnly bool) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        serve := func() { inner.ServeHTTP(w, req) }
+        if readOnly {
+            r.Read(serve)
+        } else {
+            r.Write(serve)
+        }
+    })
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRepository: point the server's repository at a scratch YAML file
+// with an empty task list and one user (tester@example.com, password
+// "Correct-Horse-9"), putting the real repository back after the test
+func testRepository(t *testing.T) (*User, TaskDataMapper) {
+	tdm := NewTaskDataMapperYAML(filepath.Join(t.TempDir(), "tasks.yaml"))
+	u, errId := NewUser("", "Tester", "tester@example.com", "Correct-Horse-9", tdm)
+	if errId != success {
+		t.Fatal("Could not test - unable to create a user")
+	}
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(tdm)
+	saved := repo
+	repo = NewTaskRepository(tdm)
+	repo.SetMaster(master)
+	repo.SetUsers(Users{u})
+	t.Cleanup(func() { repo = saved })
+	return u, tdm
+}
+
+// many requests creating, reading and changing tasks at once must leave the
+// task graph intact - run with "go test -race" to check for data races
+func TestRepositoryParallelRequests(t *testing.T) {
+
+	// set up a repository saving to a scratch YAML file
+	u, _ := testRepository(t)
+	master := repo.Master()
+
+	// the handlers guarded as the router would guard them
+	create := repo.Guard(http.HandlerFunc(TaskCreate), false)
+	index := repo.Guard(http.HandlerFunc(TaskIndex), true)
+	undo := repo.Guard(http.HandlerFunc(Undo), false)
+	request := func(h http.Handler, method string, body string) {
+		r := httptest.NewRequest(method, "/tasks", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), "user", u))
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// create tasks while others read the list and undo
+	tasksToCreate := 20
+	undosToRun := 5
+	var wg sync.WaitGroup
+	for i := 0; i < tasksToCreate; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			request(create, "POST", fmt.Sprintf(`{"name":"Parallel Task #%d"}`, i))
+		}(i)
+		go func() {
+			defer wg.Done()
+			request(index, "GET", "")
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < undosToRun; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request(undo, "GET", "")
+		}()
+	}
+	wg.Wait()
+
+	if master.NumChildren() != tasksToCreate - undosToRun {
+		t.Error("Expected", tasksToCreate - undosToRun, "tasks after parallel requests but found", master.NumChildren())
+	}
+	it := master.IterChildren()
+	for c := it.First(); c != nil; c = it.Next() {
+		if c.NumParents() != 1 || c.FirstParent() != master {
+			t.Error("Task", c.GetName(), "not correctly linked to master task.")
+		}
+	}
+}
+
+// blockingLoginMapper: a YAML mapper that holds up recording a sign in
+// until the test lets it go
+type blockingLoginMapper struct {
+	*TaskDataMapperYAML
+	saving  chan bool
+	release chan bool
+}
+
+func (m *blockingLoginMapper) LoginSave(l *UserLogin) error {
+	m.saving <- true
+	<-m.release
+	return nil
+}
+
+// signing in doesn't hold the repository lock while it records the
+// attempt, so a slow login record doesn't hold up everyone else
+func TestSigninUnlocked(t *testing.T) {
+	u, _ := testRepository(t)
+	mapper := &blockingLoginMapper{TaskDataMapperYAML: NewTaskDataMapperYAML(filepath.Join(t.TempDir(), "tasks.yaml")), saving: make(chan bool), release: make(chan bool)}
+	repo.SetStorage(mapper)
+	savedLimits := loginLimits
+	loginLimits = newLoginGuard()
+	defer func() { loginLimits = savedLimits }()
+	router := NewRouter(t.TempDir())
+
+	done := make(chan int)
+	go func() {
+		r := httptest.NewRequest("POST", "/signin", strings.NewReader(`{"email":"`+u.GetEmail()+`","password":"Correct-Horse-9"}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		done <- w.Code
+	}()
+	select {
+	case <-mapper.saving:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the sign in to be recorded without waiting on the repository")
+	}
+
+	written := make(chan bool)
+	go func() {
+		repo.Write(func() {})
+		written <- true
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the repository to be free while the sign in is recorded")
+	}
+	close(mapper.release)
+	if code := <-done; code != http.StatusOK {
+		t.Error("Expected to sign in but got", code)
+	}
+}
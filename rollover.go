@@ -0,0 +1,140 @@
+package main
+
+import (
+    "log"
+    "time"
+)
+
+/*
+==============================================================================
+ Rollover
+------------------------------------------------------------------------------
+ At each user's local midnight the plan for the day that ended is rolled
+ into the new one so nobody has to re-tag yesterday's work by hand:
+  - incomplete tasks that were today only because their target start was
+    yesterday are tagged "today" so they don't drop off the today list
+  - tasks completed before today lose their "today" tag
+  - at the start of a week, incomplete tasks targeted for last week are
+    tagged "thisweek" and tasks completed before this week lose it
+
+ Every task the user can see is rolled over, subtasks included.  Every
+ change is a tag command and the rollover runs as one transaction on the
+ user's command history, so it is journaled like any other change and a
+ single undo puts all of the tags back.  The user didn't ask for it though,
+ so unlike their own changes it leaves whatever they undid there to redo.
+
+ Rolling over is idempotent (a task already rolled over needs no change)
+ so the server also rolls over when it starts, to catch up on a midnight
+ it was down for.
+============================================================================*/
+
+// how often the server checks whether it is midnight for some user
+const rolloverCheckInterval = time.Minute
+
+// true if t is set and falls within [from, to)
+func timeWithin(t *time.Time, from time.Time, to time.Time) bool {
+    return t != nil && !t.Before(from) && t.Before(to)
+}
+
+// true if the task was completed before the cutoff (a task marked complete
+// without recording when is as stale as it gets)
+func completedBefore(t *Task, cutoff time.Time) bool {
+    if !t.IsComplete() {
+        return false
+    }
+    done := t.GetActualCompletionTime()
+    return done == nil || done.Before(cutoff)
+}
+
+/*
+==============================================================================
+ rolloverCommands()
+------------------------------------------------------------------------------
+ Inputs:  list      Tasks        - the user's tasks
+          midnight  time.Time    - start of the new day in the user's time zone
+          weekStart time.Weekday - the first day of the user's week
+ Returns: []Command              - the tag commands (not yet executed) that
+                                   roll the tasks over, empty if there is
+                                   nothing to do
+============================================================================*/
+func rolloverCommands(list Tasks, midnight time.Time, weekStart time.Weekday) []Command {
+    yesterday := midnight.AddDate(0, 0, -1)
+    week := weekStarting(midnight, weekStart)
+    lastWeek := week.AddDate(0, 0, -7)
+
+    var cmds []Command
+    for _, t := range list {
+        var set, reset []string
+        if t.IsComplete() {
+            if t.IsTagSet("today") && completedBefore(t, midnight) {
+                reset = append(reset, "today")
+            }
+            if t.IsTagSet("thisweek") && completedBefore(t, week) {
+                reset = append(reset, "thisweek")
+            }
+        } else {
+            target := t.GetTargetStartTime()
+            if !t.IsTagSet("today") && timeWithin(target, yesterday, midnight) {
+                set = append(set, "today")
+            }
+            if midnight.Equal(week) && !t.IsTagSet("thisweek") && timeWithin(target, lastWeek, week) {
+                set = append(set, "thisweek")
+            }
+        }
+        if len(set) > 0 || len(reset) > 0 {
+            cmds = append(cmds, newTagTaskCmd(t, set, reset))
+        }
+    }
+    return cmds
+}
+
+// CommandRollover: roll the user's tasks over to the day starting at
+// midnight as one undoable command, returning how many tasks changed
+func CommandRollover(u *User, list Tasks, midnight time.Time) (int, error) {
+    cmds := rolloverCommands(list, midnight, u.GetWeekStart())
+    if len(cmds) == 0 {
+        return 0, nil
+    }
+    return len(cmds), CommandDoKeepRedo(u, transaction("ROLLOVER", cmds))
+}
+
+/*
+==============================================================================
+ runRolloverScheduler()
+------------------------------------------------------------------------------
+ Inputs:  r *TaskRepository - the repository holding the users and tasks
+
+ Runs forever (start it on its own goroutine) rolling each user over once
+ per day as their local midnight passes.  We check every minute rather than
+ sleeping until the next midnight so that new users and users who change
+ time zones are picked up without any bookkeeping.  The first check after
+ the server starts rolls everyone over to catch up.
+============================================================================*/
+func runRolloverScheduler(r *TaskRepository) {
+    rolled := make(map[string]time.Time) // user id -> midnight last rolled over to
+    for {
+        r.Write(func() {
+            rolloverUsers(r, rolled, time.Now())
+        })
+        time.Sleep(rolloverCheckInterval)
+    }
+}
+
+// rolloverUsers: roll over every user whose local midnight has passed since
+// they were last rolled over (call it with the repository locked)
+func rolloverUsers(r *TaskRepository, rolled map[string]time.Time, now time.Time) {
+    for _, u := range r.Users() {
+        local := now.In(u.Location())
+        midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+        if rolled[u.GetId()].Equal(midnight) {
+            continue
+        }
+        rolled[u.GetId()] = midnight
+        n, err := CommandRollover(u, r.Master().Descendants(u), midnight)
+        if err != nil {
+            log.Printf("Unable to roll over tasks for %s: %s\n", u, err)
+        } else if n > 0 {
+            log.Printf("Rolled over %d tasks for %s to %s\n", n, u, midnight.Format("2006-01-02"))
+        }
+    }
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// unfinished work carries over to the new day and week, finished work
+// drops off, and a single undo puts everything back
+func TestRollover(t *testing.T) {
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(NewTaskDataMapperYAML(filepath.Join(t.TempDir(), "tasks.yaml")))
+	u := &User{}
+	midnight := time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC) // a Sunday
+	at := func(d time.Duration) *time.Time {
+		when := midnight.Add(d)
+		return &when
+	}
+	add := func(name string, tags ...string) *Task {
+		task := NewTask(name)
+		for _, tag := range tags {
+			task.SetTag(tag)
+		}
+		master.AddChild(task)
+		return task
+	}
+	done := func(task *Task, when *time.Time) {
+		task.SetState(complete)
+		task.SetActualCompletionTime(when)
+	}
+
+	targeted := add("targeted yesterday")
+	targeted.SetTargetStartTime(at(-10 * time.Hour))
+	lastWeek := add("targeted last week")
+	lastWeek.SetTargetStartTime(at(-72 * time.Hour))
+	doneToday := add("done yesterday", "today", "thisweek")
+	done(doneToday, at(-time.Hour))
+	doneLate := add("done after midnight", "today")
+	done(doneLate, at(time.Minute))
+	stillToday := add("still today", "today", "thisweek")
+	untouched := add("untouched")
+	untouched.SetTargetStartTime(at(30 * time.Hour))
+
+	n, err := CommandRollover(u, master.Kids(nil), midnight)
+	if err != nil || n != 3 {
+		t.Fatal("Expected to roll over 3 tasks but rolled over", n, err)
+	}
+	if !targeted.IsTagSet("today") || !targeted.IsTagSet("thisweek") {
+		t.Error("Expected the task targeted yesterday to carry over to today and this week")
+	}
+	if lastWeek.IsTagSet("today") || !lastWeek.IsTagSet("thisweek") {
+		t.Error("Expected the task targeted last week to carry over to this week only")
+	}
+	if doneToday.IsTagSet("today") || doneToday.IsTagSet("thisweek") {
+		t.Error("Expected the task done yesterday to drop off today and this week")
+	}
+	if !doneLate.IsTagSet("today") || !stillToday.IsTagSet("today") || !stillToday.IsTagSet("thisweek") || untouched.IsTagSet("today") {
+		t.Error("Expected the other tasks to be left alone")
+	}
+
+	if n, _ := CommandRollover(u, master.Kids(nil), midnight); n != 0 {
+		t.Error("Expected rolling over twice to do nothing but rolled over", n)
+	}
+
+	if _, err := CommandUndo(u); err != nil {
+		t.Fatal("Unable to undo the rollover:", err)
+	}
+	if targeted.IsTagSet("today") || lastWeek.IsTagSet("thisweek") || !doneToday.IsTagSet("today") || !doneToday.IsTagSet("thisweek") {
+		t.Error("Expected a single undo to restore every tag")
+	}
+}
+
+// the scheduler rolls over subtasks too, and leaves what the user undid
+// there to redo - even after a restart
+func TestRolloverUsers(t *testing.T) {
+	mapper := newJournalMapper(t)
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(mapper.TaskDataMapperYAML)
+	u := &User{id: "tester", persist: mapper, location: time.UTC}
+	saved := repo
+	repo = NewTaskRepository(mapper)
+	repo.SetMaster(master)
+	repo.SetUsers(Users{u})
+	t.Cleanup(func() { repo = saved })
+
+	now := time.Date(2026, time.October, 20, 9, 0, 0, 0, time.UTC)
+	yesterday := now.Add(-24 * time.Hour)
+	project := NewTask("project")
+	project.AddUser(u)
+	master.AddChild(project)
+	step := NewTask("step")
+	step.AddUser(u)
+	step.SetTargetStartTime(&yesterday)
+	project.AddChild(step)
+
+	if err := CommandTagTask(u, project, []string{"urgent"}, nil); err != nil {
+		t.Fatal("Unable to tag task:", err)
+	}
+	if _, err := CommandUndo(u); err != nil {
+		t.Fatal("Unable to undo:", err)
+	}
+
+	rolloverUsers(repo, make(map[string]time.Time), now)
+	if !step.IsTagSet("today") {
+		t.Error("Expected the subtask targeted yesterday to carry over to today")
+	}
+	restartHistory(t, u, master)
+	if _, err := CommandRedo(u); err != nil {
+		t.Fatal("Expected the undone tag to survive the rollover and a restart but:", err)
+	}
+	if !project.IsTagSet("urgent") || !step.IsTagSet("today") {
+		t.Error("Expected the redo to tag the project and leave the rollover in place")
+	}
+}
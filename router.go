@@ -16,9 +16,15 @@ func NewRouter(files string) *mux.Router {
         handler = route.HandlerFunc
         // unless the route is explicitly marked as NoAuth needed
         // insert the authenticator into all other routes so
-        // only authenticated requests can move through
+        // only authenticated requests can move through (scripts may
+        // use personal access tokens with the scope the route needs) -
+        // every such request holds the repository lock while it runs, and
+        // the rest (signing in and the like) take it themselves only while
+        // they look at the repository, so a slow password check or login
+        // record doesn't hold up everyone else
         if !route.NoAuth { 
-            handler = UserAuthenticator(handler)
+            handler = UserTokenAuthenticator(handler, route.TokenScope())
+            handler = repo.Guard(handler, route.ReadOnly)
         }
         handler = Logger(handler, route.Name)
 /*
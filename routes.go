@@ -18,7 +18,9 @@ type Route struct {
     Pattern     string
     Queries     []string
     HandlerFunc http.HandlerFunc
-    NoAuth      bool
+    NoAuth      bool // handler needs no signed in user, and locks the repository itself
+    ReadOnly    bool // handler changes no tasks or users so can run alongside others
+    AccountOnly bool // handler manages the account so personal access tokens can't use it
 }
 
 type Routes []Route
@@ -31,7 +33,6 @@ var routes = Routes{
         Name: "Signin",
         Method: "POST",
         Pattern: "/signin",
-        Queries: []string{"email", "{email}", "password", "{password}"},
         HandlerFunc: UserSignin,
         NoAuth: true,
     },
@@ -39,7 +40,6 @@ var routes = Routes{
         Name: "Signup",
         Method: "POST",
         Pattern: "/signup",
-        Queries: []string{"email", "{email}", "password", "{password}"},
         HandlerFunc: UserSignup,
         NoAuth: true,
     },
@@ -48,6 +48,72 @@ var routes = Routes{
         Method: "POST",
         Pattern: "/signreup",
         HandlerFunc: UserSignReup,
+        AccountOnly: true,
+    },
+    Route{
+        Name: "TokenRefresh",
+        Method: "POST",
+        Pattern: "/token/refresh",
+        HandlerFunc: TokenRefresh,
+        NoAuth: true,
+    },
+    Route{
+        Name: "Signout",
+        Method: "POST",
+        Pattern: "/signout",
+        HandlerFunc: UserSignout,
+        NoAuth: true,
+    },
+    Route{
+        Name: "PasswordReset",
+        Method: "POST",
+        Pattern: "/password/reset",
+        HandlerFunc: UserPasswordReset,
+        NoAuth: true,
+    },
+    Route{
+        Name: "PasswordResetConfirm",
+        Method: "POST",
+        Pattern: "/password/reset/confirm",
+        HandlerFunc: UserPasswordResetConfirm,
+        NoAuth: true,
+    },
+    Route{
+        Name: "UserPasswordChange",
+        Method: "POST",
+        Pattern: "/users/me/password",
+        HandlerFunc: UserPasswordChange,
+        AccountOnly: true,
+    },
+    Route{
+        Name: "UserTokenIndex",
+        Method: "GET",
+        Pattern: "/users/me/tokens",
+        HandlerFunc: UserTokenIndex,
+        ReadOnly: true,
+        AccountOnly: true,
+    },
+    Route{
+        Name: "UserTokenCreate",
+        Method: "POST",
+        Pattern: "/users/me/tokens",
+        HandlerFunc: UserTokenCreate,
+        AccountOnly: true,
+    },
+    Route{
+        Name: "UserTokenRevoke",
+        Method: "DELETE",
+        Pattern: "/users/me/tokens/{tokenId}",
+        HandlerFunc: UserTokenRevoke,
+        AccountOnly: true,
+    },
+    Route{
+        Name: "JWKS",
+        Method: "GET",
+        Pattern: "/.well-known/jwks.json",
+        HandlerFunc: JWKS,
+        NoAuth: true,
+        ReadOnly: true,
     },
     Route{
         Name: "TaskIndex",
@@ -55,6 +121,7 @@ var routes = Routes{
         Pattern: "/tasks",
         Queries: queryTags,
         HandlerFunc: TaskIndex,
+        ReadOnly: true,
     },
     Route {
         Name: "TaskFindToday",
@@ -62,6 +129,7 @@ var routes = Routes{
         Pattern: "/tasks/today",
         Queries: queryTags,
         HandlerFunc: TaskFindToday,
+        ReadOnly: true,
     },
     Route {
         Name: "TaskFindThisWeek",
@@ -69,6 +137,7 @@ var routes = Routes{
         Pattern: "/tasks/thisweek",
         Queries: queryTags,
         HandlerFunc: TaskFindThisWeek,
+        ReadOnly: true,
     },
     Route{
         Name: "TaskFindComplete",
@@ -76,20 +145,108 @@ var routes = Routes{
         Pattern: "/tasks/complete",
         Queries: queryTags,
         HandlerFunc: TaskFindComplete,
+        ReadOnly: true,
     },    
+    Route{
+        Name: "TaskFindOverdue",
+        Method: "GET",
+        Pattern: "/tasks/overdue",
+        HandlerFunc: TaskFindOverdue,
+        ReadOnly: true,
+    },
+    Route{
+        Name: "TaskFindDue",
+        Method: "GET",
+        Pattern: "/tasks/due",
+        Queries: []string{"from", "{from}", "to", "{to}"},
+        HandlerFunc: TaskFindDue,
+        ReadOnly: true,
+    },
+    Route{
+        Name: "TaskSearch",
+        Method: "GET",
+        Pattern: "/tasks/search",
+        Queries: []string{"q", "{q}"},
+        HandlerFunc: TaskSearch,
+        ReadOnly: true,
+    },
     Route{
         Name: "TaskGeneralFind",
         Method: "GET",
         Pattern: "/tasks/find",
-        Queries: []string{"fromDate", "{date}", "toDate", "{date}"},
         HandlerFunc: TaskGeneralFind,
+        ReadOnly: true,
     }, 
+    Route{
+        Name: "TaskChildIndex",
+        Method: "GET",
+        Pattern: "/tasks/{taskId}/children",
+        HandlerFunc: TaskChildIndex,
+        ReadOnly: true,
+    },
+    Route{
+        Name: "TaskChildCreate",
+        Method: "POST",
+        Pattern: "/tasks/{taskId}/children",
+        HandlerFunc: TaskChildCreate,
+    },
+    Route{
+        Name: "TaskTimerStart",
+        Method: "POST",
+        Pattern: "/tasks/{taskId}/timer/start",
+        HandlerFunc: TaskTimerStart,
+    },
+    Route{
+        Name: "TaskTimerStop",
+        Method: "POST",
+        Pattern: "/tasks/{taskId}/timer/stop",
+        HandlerFunc: TaskTimerStop,
+    },
+    Route{
+        Name: "TaskParentAdd",
+        Method: "PUT",
+        Pattern: "/tasks/{taskId}/parents/{parentId}",
+        HandlerFunc: TaskParentAdd,
+    },
+    Route{
+        Name: "TaskParentRemove",
+        Method: "DELETE",
+        Pattern: "/tasks/{taskId}/parents/{parentId}",
+        HandlerFunc: TaskParentRemove,
+    },
+    Route{
+        Name: "TaskCommentIndex",
+        Method: "GET",
+        Pattern: "/tasks/{taskId}/comments",
+        HandlerFunc: TaskCommentIndex,
+        ReadOnly: true,
+    },
+    Route{
+        Name: "TaskCommentCreate",
+        Method: "POST",
+        Pattern: "/tasks/{taskId}/comments",
+        HandlerFunc: TaskCommentCreate,
+    },
+    Route{
+        Name: "TaskCommentUpdate",
+        Method: "PATCH",
+        Pattern: "/tasks/{taskId}/comments/{commentId}",
+        HandlerFunc: TaskCommentUpdate,
+    },
+    Route{
+        Name: "TaskActivityIndex",
+        Method: "GET",
+        Pattern: "/tasks/{taskId}/activity",
+        HandlerFunc: TaskActivityIndex,
+        ReadOnly: true,
+    },
     Route{
         Name: "TaskShow",
         Method: "GET",
         Pattern: "/tasks/{taskId}",
         Queries: queryTags,
         HandlerFunc: TaskShow,
+        ReadOnly: true,
     },
     Route {
         Name: "TaskFindByDate",
@@ -97,6 +254,7 @@ var routes = Routes{
         Pattern: "/tasks/date/{date}",
         Queries: queryTags,
         HandlerFunc: TaskFind,
+        ReadOnly: true,
     },
     Route{
         Name: "TaskCreate",
@@ -122,17 +280,60 @@ var routes = Routes{
         Pattern: "/tasks/{taskId}",
         HandlerFunc: TaskDelete,
     },
+    Route{
+        Name: "ReportSummary",
+        Method: "GET",
+        Pattern: "/reports/summary",
+        Queries: []string{"from", "{from}", "to", "{to}"},
+        HandlerFunc: ReportSummary,
+        ReadOnly: true,
+    },
+    Route{
+        Name: "PlannerShow",
+        Method: "GET",
+        Pattern: "/planner",
+        HandlerFunc: PlannerShow,
+        ReadOnly: true,
+    },
+    Route{
+        Name: "UserCapacityShow",
+        Method: "GET",
+        Pattern: "/users/me/capacity",
+        HandlerFunc: UserCapacityShow,
+        ReadOnly: true,
+    },
+    Route{
+        Name: "UserCapacityUpdate",
+        Method: "PUT",
+        Pattern: "/users/me/capacity",
+        HandlerFunc: UserCapacityUpdate,
+        AccountOnly: true,
+    },
+    Route{
+        Name: "UserTimeZoneShow",
+        Method: "GET",
+        Pattern: "/users/me/timezone",
+        HandlerFunc: UserTimeZoneShow,
+        ReadOnly: true,
+    },
+    Route{
+        Name: "UserTimeZoneUpdate",
+        Method: "PUT",
+        Pattern: "/users/me/timezone",
+        HandlerFunc: UserTimeZoneUpdate,
+        AccountOnly: true,
+    },
     Route{
         Name: "TagIndex",
         Method: "GET",
         Pattern: "/tags",
         HandlerFunc: TagIndex,
+        ReadOnly: true,
     },
     Route{
         Name: "TaskReorder",
-        Method: "GET",
-        Pattern: "/reorder/{taskId}",
-        Queries: []string{"targetId", "{targetId}"},
+        Method: "PATCH",
+        Pattern: "/tasks/{taskId}/position",
         HandlerFunc: TaskReorder,
     }, 
     Route{
@@ -141,10 +342,17 @@ var routes = Routes{
         Pattern: "/undo",
         HandlerFunc: Undo,
     }, 
+    Route{
+        Name: "Redo",
+        Method: "POST",
+        Pattern: "/redo",
+        HandlerFunc: Redo,
+    },
     Route{
         Name: "ServerStatus",
         Method: "GET",
         Pattern: "/status",
         HandlerFunc: ServerStatus,
+        ReadOnly: true,
         NoAuth: true,
     },}
\ No newline at end of file
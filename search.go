@@ -0,0 +1,147 @@
+package main
+
+import (
+  "math"
+  "sort"
+  "strings"
+  "sync"
+  "unicode"
+)
+
+/*
+==============================================================================
+ Search
+------------------------------------------------------------------------------
+ Full-text search over the words of tasks, ranked by relevance.  Storage
+ that can search does it itself (PostgreSQL uses a tsvector column with a
+ GIN index).  Storage that can't keeps a SearchIndex - an in-memory
+ inverted index from each word to the tasks it appears in - built when the
+ tasks are loaded and kept up to date as they are saved.
+
+ A search finds the tasks that have every word searched for.  Words are
+ compared regardless of case, and common words ("the", "and", ...) are
+ ignored as they are in PostgreSQL.  Tasks are ranked by how often the
+ words appear in them, with rare words counting for more (TF-IDF).
+============================================================================*/
+
+// TaskSearcher: a data mapper that can search the text of tasks
+type TaskSearcher interface {
+  TaskSearch(text string, u *User) ([]SearchHit, error) // the user's matching tasks, best first
+}
+
+// SearchHit: a task found by a search and how well it matched
+type SearchHit struct {
+  Id string
+  Rank float64
+}
+
+// the words of a task we search
+func (t *Task) searchText() string {
+  return t.GetName() + "\n" + t.GetNotes()
+}
+
+var searchStopWords = map[string]bool{
+  "a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+  "be": true, "by": true, "for": true, "from": true, "in": true, "is": true,
+  "it": true, "of": true, "on": true, "or": true, "the": true, "to": true,
+  "with": true,
+}
+
+// searchWords: split text into the lower case words we index and search
+func searchWords(text string) []string {
+  var words []string
+  fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+    return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+  })
+  for _, w := range fields {
+    if !searchStopWords[w] {
+      words = append(words, w)
+    }
+  }
+  return words
+}
+
+type SearchIndex struct {
+  mu sync.Mutex
+  postings map[string]map[string]int // word -> task id -> times it appears
+  words map[string][]string          // task id -> the words indexed for it
+}
+
+func NewSearchIndex() *SearchIndex {
+  return &SearchIndex{postings:make(map[string]map[string]int), words:make(map[string][]string)}
+}
+
+// Add: index a task, replacing what was indexed for it before
+func (ix *SearchIndex) Add(t *Task) {
+  ix.mu.Lock()
+  defer ix.mu.Unlock()
+  ix.remove(t.GetId())
+  words := searchWords(t.searchText())
+  for _, w := range words {
+    if ix.postings[w] == nil {
+      ix.postings[w] = make(map[string]int)
+    }
+    ix.postings[w][t.GetId()]++
+  }
+  ix.words[t.GetId()] = words
+}
+
+func (ix *SearchIndex) remove(id string) {
+  for _, w := range ix.words[id] {
+    delete(ix.postings[w], id)
+    if len(ix.postings[w]) == 0 {
+      delete(ix.postings, w)
+    }
+  }
+  delete(ix.words, id)
+}
+
+// Retain: forget every task not kept (e.g. those no longer saved)
+func (ix *SearchIndex) Retain(keep map[string]bool) {
+  ix.mu.Lock()
+  defer ix.mu.Unlock()
+  for id := range ix.words {
+    if !keep[id] {
+      ix.remove(id)
+    }
+  }
+}
+
+// Search: the tasks with every word of the text, best first (nothing if
+// the text has no words worth searching for)
+func (ix *SearchIndex) Search(text string) []SearchHit {
+  ix.mu.Lock()
+  defer ix.mu.Unlock()
+  words := searchWords(text)
+  if len(words) == 0 {
+    return nil
+  }
+
+  total := float64(len(ix.words))
+  ranks := make(map[string]float64)
+  for i, w := range words {
+    found := ix.postings[w]
+    idf := math.Log(1 + total / float64(len(found) + 1))
+    next := make(map[string]float64)
+    for id, count := range found {
+      rank, candidate := ranks[id]
+      if i == 0 || candidate {
+        length := float64(len(ix.words[id]))
+        next[id] = rank + float64(count) / length * idf
+      }
+    }
+    ranks = next
+  }
+
+  hits := make([]SearchHit, 0, len(ranks))
+  for id, rank := range ranks {
+    hits = append(hits, SearchHit{Id:id, Rank:rank})
+  }
+  sort.Slice(hits, func(i, j int) bool {
+    if hits[i].Rank != hits[j].Rank {
+      return hits[i].Rank > hits[j].Rank
+    }
+    return hits[i].Id < hits[j].Id
+  })
+  return hits
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+// tasks with every word are found regardless of case, best matches first
+func TestSearchIndex(t *testing.T) {
+	ix := NewSearchIndex()
+	cost := NewTask("Twillio Cost Analysis")
+	costs := NewTask("Cost of the new laptop and the laptop bag")
+	cutting := NewTask("Twillio cost, cost cutting")
+	ix.Add(cost)
+	ix.Add(costs)
+	ix.Add(cutting)
+
+	hits := ix.Search("twillio COST")
+	if len(hits) != 2 || hits[0].Id != cutting.GetId() || hits[1].Id != cost.GetId() {
+		t.Error("Expected cost cutting then the cost analysis but found", hits)
+	}
+	if hits := ix.Search("the laptop"); len(hits) != 1 || hits[0].Id != costs.GetId() {
+		t.Error("Expected to ignore common words but found", hits)
+	}
+	if hits := ix.Search("the"); len(hits) != 0 {
+		t.Error("Expected nothing for a search with no words worth searching for but found", hits)
+	}
+
+	// renaming a task reindexes it and retaining forgets the rest
+	cost.SetName("Phone bill")
+	ix.Add(cost)
+	if hits := ix.Search("analysis"); len(hits) != 0 {
+		t.Error("Expected the old name to be forgotten but found", hits)
+	}
+	ix.Retain(map[string]bool{cost.GetId(): true})
+	if hits := ix.Search("cost"); len(hits) != 0 {
+		t.Error("Expected tasks not retained to be forgotten but found", hits)
+	}
+	if hits := ix.Search("phone"); len(hits) != 1 {
+		t.Error("Expected the renamed task to be found but found", hits)
+	}
+}
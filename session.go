@@ -0,0 +1,210 @@
+package main
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "net/http"
+    "sync"
+    "time"
+)
+
+/*
+==============================================================================
+ Sessions
+------------------------------------------------------------------------------
+ An access token (the JWT in the "token" cookie) only lasts a few minutes
+ so a stolen one is soon useless.  To keep users signed in longer than that
+ signing in also starts a session: a long-lived random refresh token in the
+ "refresh" cookie that the client trades at /token/refresh for a new access
+ token before the old one expires.
+
+ Sessions slide - each refresh replaces the refresh token with a new one
+ that lasts another refreshTimeout, so a user who keeps working stays
+ signed in while one who walks away is signed out once it runs out.
+ Because sessions live on the server (unlike access tokens) signing out
+ revokes one, and the access token it handed out expires within minutes.
+
+ A password reset is a session too, of its own kind: a short-lived token
+ mailed to the user that can be used once to set a new password.  Changing
+ or resetting a password ends all of the user's sessions.
+
+ Only a hash of each token is stored, so the tokens can't be read back out
+ of storage.  Both cookies are HttpOnly (scripts can't read them),
+ Secure (we only serve over TLS) and SameSite (other sites can't send them).
+============================================================================*/
+
+// how long a refresh token lasts if it isn't used
+const refreshTimeout = (30 * 24 * time.Hour)
+
+// how long a password reset token lasts
+const resetTimeout = time.Hour
+
+// the kinds of session
+const (
+    sessionRefresh = "refresh" // a signed in user
+    sessionReset   = "reset"   // a password reset in progress
+)
+
+type UserSession struct {
+    Id        string    // hash of the token (see sessionId())
+    UserId    string    // user who signed in or is resetting their password
+    Kind      string    // sessionRefresh or sessionReset
+    CreatedAt time.Time
+    ExpiresAt time.Time
+}
+
+// newRefreshToken: a new random refresh token
+func newRefreshToken() (string, error) {
+    b := make([]byte, 32)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// sessionId: the id a session is stored under - the hash of its token
+func sessionId(refreshToken string) string {
+    sum := sha256.Sum256([]byte(refreshToken))
+    return hex.EncodeToString(sum[:])
+}
+
+// newUserSession: start a session of the kind provided, returning the
+// token that identifies it
+func newUserSession(u *User, kind string, lasts time.Duration) (string, *UserSession, error) {
+    token, err := newRefreshToken()
+    if err != nil {
+        return "", nil, err
+    }
+    now := time.Now().UTC() // stored without a time zone
+    s := &UserSession{Id:sessionId(token), UserId:u.GetId(), Kind:kind, CreatedAt:now, ExpiresAt:now.Add(lasts)}
+    if err := repo.Storage().SessionSave(s); err != nil {
+        return "", nil, err
+    }
+    return token, s, nil
+}
+
+// userSessionFromToken: the unexpired session of the kind provided with
+// the token, or nil if there isn't one (expired sessions are removed)
+func userSessionFromToken(token string, kind string) (*UserSession, error) {
+    s, err := repo.Storage().SessionLoad(sessionId(token))
+    if err != nil || s == nil || s.Kind != kind {
+        return nil, err
+    }
+    if !time.Now().Before(s.ExpiresAt) {
+        return nil, repo.Storage().SessionDelete(s)
+    }
+    return s, nil
+}
+
+/*
+==============================================================================
+ UserStartSession()
+------------------------------------------------------------------------------
+ Inputs:  w ResponseWriter - response to set the cookies into
+          u *User          - user whose password is already ok-ed
+ Returns:   bool           - false if a response with an error was written
+
+ Start a new session for the user, setting both an access token and a
+ refresh token into the response.
+============================================================================*/
+func UserStartSession(w http.ResponseWriter, u *User) bool {
+    refreshToken, s, err := newUserSession(u, sessionRefresh, refreshTimeout)
+    if err != nil {
+        errorResponse(w, pimErr(authErr))
+        return false
+    }
+    if !UserSetAuthToken(w, u.GetEmail()) {
+        return false
+    }
+    http.SetCookie(w, authCookie("refresh", refreshToken, s.ExpiresAt))
+    return true
+}
+
+// userSessionFromRequest: the unexpired session of the refresh token in the
+// request, or nil if there isn't one
+func userSessionFromRequest(r *http.Request) (*UserSession, error) {
+    c, err := r.Cookie("refresh")
+    if err != nil {
+        return nil, nil
+    }
+    return userSessionFromToken(c.Value, sessionRefresh)
+}
+
+// authCookie: a cookie holding a token, which scripts can't read and is
+// only ever sent over TLS back to us
+func authCookie(name string, value string, expires time.Time) *http.Cookie {
+    return &http.Cookie{
+        Name:     name,
+        Value:    value,
+        Path:     "/",
+        Expires:  expires,
+        HttpOnly: true,
+        Secure:   true,
+        SameSite: http.SameSiteStrictMode,
+    }
+}
+
+// userClearCookies: remove both tokens from the client
+func userClearCookies(w http.ResponseWriter) {
+    for _, name := range []string{"token", "refresh"} {
+        c := authCookie(name, "", time.Unix(0, 0))
+        c.MaxAge = -1
+        http.SetCookie(w, c)
+    }
+}
+
+/*
+==============================================================================
+ sessionStore
+------------------------------------------------------------------------------
+ Sessions kept in memory, for data mappers (like YAML) that have nowhere
+ better to keep them.  Everyone is signed out when the server restarts.
+============================================================================*/
+type sessionStore struct {
+    mu       sync.Mutex
+    sessions map[string]*UserSession // id -> session
+}
+
+func newSessionStore() *sessionStore {
+    return &sessionStore{sessions:make(map[string]*UserSession)}
+}
+
+func (ss *sessionStore) save(s *UserSession) {
+    ss.mu.Lock()
+    defer ss.mu.Unlock()
+    ss.sessions[s.Id] = s
+}
+
+func (ss *sessionStore) load(id string) *UserSession {
+    ss.mu.Lock()
+    defer ss.mu.Unlock()
+    return ss.sessions[id]
+}
+
+func (ss *sessionStore) delete(id string) {
+    ss.mu.Lock()
+    defer ss.mu.Unlock()
+    delete(ss.sessions, id)
+}
+
+func (ss *sessionStore) deleteUser(userId string) {
+    ss.mu.Lock()
+    defer ss.mu.Unlock()
+    for id, s := range ss.sessions {
+        if s.UserId == userId {
+            delete(ss.sessions, id)
+        }
+    }
+}
+
+func (ss *sessionStore) prune(before time.Time) {
+    ss.mu.Lock()
+    defer ss.mu.Unlock()
+    for id, s := range ss.sessions {
+        if s.ExpiresAt.Before(before) {
+            delete(ss.sessions, id)
+        }
+    }
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// signing in starts a session whose refresh token can be traded once for
+// new tokens, and signing out revokes it
+func TestSessionRefresh(t *testing.T) {
+	testRepository(t)
+
+	cookies := func(w *httptest.ResponseRecorder) map[string]*http.Cookie {
+		found := make(map[string]*http.Cookie)
+		for _, c := range w.Result().Cookies() {
+			found[c.Name] = c
+		}
+		return found
+	}
+	post := func(h http.HandlerFunc, body string, send ...*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		for _, c := range send {
+			r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	signin := cookies(post(UserSignin, `{"email":"tester@example.com","password":"Correct-Horse-9"}`))
+	refresh := signin["refresh"]
+	if signin["token"] == nil || refresh == nil {
+		t.Fatal("Expected signing in to set an access and a refresh token")
+	}
+	if !refresh.HttpOnly || !refresh.Secure || refresh.SameSite != http.SameSiteStrictMode {
+		t.Error("Expected the refresh token cookie to be HttpOnly, Secure and SameSite")
+	}
+
+	w := post(TokenRefresh, "", refresh)
+	renewed := cookies(w)
+	if w.Code != http.StatusOK || renewed["token"] == nil || renewed["refresh"] == nil || renewed["refresh"].Value == refresh.Value {
+		t.Fatal("Expected a refresh to issue new tokens but got", w.Code, w.Body.String())
+	}
+	if w := post(TokenRefresh, "", refresh); w.Code != http.StatusUnauthorized {
+		t.Error("Expected a used refresh token to be refused but got", w.Code)
+	}
+
+	post(UserSignout, "", renewed["refresh"])
+	if w := post(TokenRefresh, "", renewed["refresh"]); w.Code != http.StatusUnauthorized {
+		t.Error("Expected the refresh token to be revoked by signing out but got", w.Code)
+	}
+
+	r := httptest.NewRequest("GET", "/tasks", nil)
+	r.AddCookie(&http.Cookie{Name: "token", Value: "not-a-token"})
+	if userCheckAuthToken(httptest.NewRecorder(), r) != nil {
+		t.Error("Expected an invalid access token to be refused")
+	}
+}
+
+// a forgotten password can be reset once with a mailed token, a known one
+// changed, and either way every earlier session of the user ends
+func TestPasswordReset(t *testing.T) {
+	u, _ := testRepository(t)
+	savedMailer, savedURL := mailer, publicURL
+	outbox := t.TempDir()
+	mailer, _ = NewMailSender(outbox)
+	publicURL = "https://pim.example.com"
+	defer func() { mailer, publicURL = savedMailer, savedURL }()
+
+	post := func(h http.HandlerFunc, body string, send ...*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), "user", u))
+		for _, c := range send {
+			r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	signin := func(password string) *http.Cookie {
+		w := post(UserSignin, `{"email":"tester@example.com","password":"`+password+`"}`)
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "refresh" {
+				return c
+			}
+		}
+		return nil
+	}
+	refresh := signin("Correct-Horse-9")
+
+	post(UserPasswordReset, `{"email":"nobody@example.com"}`)
+	post(UserPasswordReset, `{"email":"tester@example.com"}`)
+	sent, _ := ioutil.ReadDir(outbox)
+	if len(sent) != 1 {
+		t.Fatal("Expected one reset mailed to the user but found", len(sent))
+	}
+	mail, _ := ioutil.ReadFile(filepath.Join(outbox, sent[0].Name()))
+	if !strings.Contains(string(mail), "https://pim.example.com/password/reset/confirm") {
+		t.Error("Expected the mail to link to the server's public address but got", string(mail))
+	}
+	var token string
+	for _, line := range strings.Split(string(mail), "\r\n") {
+		if strings.HasPrefix(line, "    ") {
+			token = strings.TrimSpace(line)
+		}
+	}
+
+	confirm := `{"token":"` + token + `","password":"Battery-Staple-7"}`
+	if w := post(UserPasswordResetConfirm, confirm); w.Code != http.StatusOK {
+		t.Fatal("Expected the mailed token to reset the password but got", w.Code, w.Body.String())
+	}
+	if w := post(UserPasswordResetConfirm, confirm); w.Code != http.StatusUnauthorized {
+		t.Error("Expected a used reset token to be refused but got", w.Code)
+	}
+	if w := post(TokenRefresh, "", refresh); w.Code != http.StatusUnauthorized {
+		t.Error("Expected the reset to end earlier sessions but got", w.Code)
+	}
+	if signin("Correct-Horse-9") != nil || signin("Battery-Staple-7") == nil {
+		t.Error("Expected only the new password to sign in")
+	}
+
+	if w := post(UserPasswordChange, `{"oldPassword":"wrong","newPassword":"Tr0ub4dor-3"}`); w.Code != http.StatusUnauthorized {
+		t.Error("Expected a change with the wrong old password to be refused but got", w.Code)
+	}
+	if w := post(UserPasswordChange, `{"oldPassword":"Battery-Staple-7","newPassword":"Tr0ub4dor-3"}`); w.Code != http.StatusOK || !u.CheckPassword("Tr0ub4dor-3") {
+		t.Error("Expected the password to change but got", w.Code, w.Body.String())
+	}
+
+	r := httptest.NewRequest("POST", "/signin?email=tester@example.com&password=Tr0ub4dor-3", nil)
+	if strings.Contains(loggedURI(r), "Tr0ub4dor") {
+		t.Error("Expected the password to be kept out of the log but logged", loggedURI(r))
+	}
+}
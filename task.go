@@ -4,6 +4,7 @@ import "fmt"
 import "errors"
 import "github.com/satori/go.uuid"
 import "time"
+import "sort"
 // import "net/url"
 
 
@@ -14,6 +15,16 @@ func (e ErrTaskNotFoundInList) Error() string {
   return fmt.Sprintf("Could not find task in parent or child list.")
 }
 
+// ErrVersionConflict: returned by a data mapper when the task being saved
+// is not the version last stored - someone else saved it in between
+type ErrVersionConflict struct {
+  Id string      // id of the task
+  Version int    // version we tried to save over
+}
+func (e *ErrVersionConflict) Error() string {
+  return fmt.Sprintf("task %s was changed since version %d", e.Id, e.Version)
+}
+
 func IsSystemTag(tag string) bool {
   return tag == "today" || tag == "thisweek"
 }
@@ -59,6 +70,29 @@ type TaskDataMapper interface {
   UserLoad(u *User) error
   UserDelete(u *User) error
   UserLoadAll() (Users, error)
+
+  JournalSave(e *JournalEntry) error               // insert a new journal entry or update an existing one
+  JournalLoad(u *User) ([]*JournalEntry, error)    // all journal entries of a user in the order they were created
+  JournalDelete(e *JournalEntry) error
+  JournalPrune(before time.Time) error             // remove all journal entries created before the time provided
+
+  CommentSave(c *TaskComment) error                // insert a new comment or update the text of an existing one
+  CommentLoad(t *Task) ([]*TaskComment, error)     // all comments on a task, oldest first
+  ActivitySave(a *TaskActivity) error              // insert a new activity entry
+  ActivityLoad(t *Task) ([]*TaskActivity, error)   // all activity of a task, oldest first
+
+  SessionSave(s *UserSession) error                // insert a new session
+  SessionLoad(id string) (*UserSession, error)     // the session with the id provided (nil if there is none)
+  SessionDelete(s *UserSession) error
+  SessionDeleteAll(u *User) error                  // remove every session of the user
+  SessionPrune(before time.Time) error             // remove all sessions that expired before the time provided
+
+  LoginSave(l *UserLogin) error                    // record a sign-in attempt
+
+  TokenSave(k *UserToken) error                    // insert a new personal access token or note when it was last used
+  TokenLoad(hash string) (*UserToken, error)       // the token with the hash provided (nil if there is none)
+  TokenLoadAll(u *User) ([]*UserToken, error)      // all tokens of a user, oldest first
+  TokenDelete(k *UserToken) error
 }
 
 // TaskLink: simple object to abstract a task link with optional offsets into the name
@@ -88,6 +122,22 @@ func (link *TaskLink) GetURI() string {
   return link.uri
 }
 
+// WorkSession: a stretch of time someone spent working on a task, from
+// starting its timer to stopping it (Stop is nil while the timer runs)
+type WorkSession struct {
+  Start  time.Time
+  Stop   *time.Time
+  UserId string     // who was working (empty if we don't know)
+}
+
+// Duration: how long the session lasted, or has lasted so far
+func (s WorkSession) Duration(now time.Time) time.Duration {
+  if s.Stop == nil {
+    return now.Sub(s.Start)
+  }
+  return s.Stop.Sub(s.Start)
+}
+
 
 // Task: our central type for the whole world here - will become quite large over time
 type Task struct {
@@ -97,15 +147,23 @@ type Task struct {
   TargetStartTime *time.Time      // targeted start time of the task
   ActualStartTime *time.Time      // actual start time of the task
   ActualCompletionTime *time.Time // time task is marked done
+  DueTime *time.Time              // deadline for the task (if any)
   Estimate time.Duration          // estimated duration of the task
   tags []string                   // all the things - for now today, thisweek, dontforget
   links []TaskLink                // a task can have associated links
+  recurrence *Recurrence          // if not nil, completing the task schedules the next one
+  priority int                    // larger is more important, 0 = no priority given
+  createdTime *time.Time          // when the task was first created (nil = unknown)
+  sessions []WorkSession          // time spent working on the task, oldest first
+  notes string                    // long-form description of the task in Markdown
 
   parents Tasks                   // list of parent tasks (we support many parents)
   kids Tasks                      // list of child tasks
 
   users []*User                   // list of users who can see this task (TBD: different permissions)
 
+  version int                     // bumped on every save, 0 = never saved
+
 
   // for console app only!  hopefully won't need in the end
   current bool  // need to get rid of this
@@ -114,11 +172,6 @@ type Task struct {
   // data-mapper used to abstract persistence from this in-memory task object
   persist TaskDataMapper
   memoryonly bool // if true, don't allow this task to be saved
-
-  // iterator support to make iterating with multiple parents sane
-  // TBD move iteration to its own class for concurrent usage in the future
-  iterCurrParent int
-  iterCurrChild int
 }
 type Tasks []*Task
 
@@ -156,9 +209,10 @@ func (list Tasks) FindByCompletionDate(date time.Time) Tasks {
   for _, curr := range list {
     done := curr.GetActualCompletionTime()
     if done != nil {
-      // note: location should be done.Location() - but set to date for now to make it match
-      // for now we'll assume all dates are UTC and that is what is passed in
-      dayOfTask := time.Date(done.Year(), done.Month(), done.Day(), 0, 0, 0, 0, date.Location())
+      // compare on the calendar of the date we were asked for, which is
+      // in the time zone of the user asking
+      local := done.In(date.Location())
+      dayOfTask := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, date.Location())
       if dayToFind == dayOfTask {
         result = append(result, curr)
       }
@@ -184,8 +238,9 @@ func (list Tasks) GetChildTags() map[string]int {
 // given a list of tags find all matches, optionally including "special"
 // processing for system-supported tags such as "today" and
 // "thisweek" which match not only for their tags, but also
-// for their date ranges.
-func (list Tasks) FindTagMatches(tags []string, system bool) Tasks {
+// for their date ranges (today and this week as of now, which is in the
+// time zone of the user asking, with weeks starting on weekStart).
+func (list Tasks) FindTagMatches(tags []string, system bool, now time.Time, weekStart time.Weekday) Tasks {
   var result Tasks
   var addit bool
   for _, curr := range list {
@@ -194,11 +249,11 @@ func (list Tasks) FindTagMatches(tags []string, system bool) Tasks {
     for _, tag := range tags {
       addit = curr.IsTagSet(tag)
       if !addit && system && IsSystemTag(tag) {
-        if tag == "today" && curr.IsToday() {
+        if tag == "today" && curr.IsTodayAt(now) {
           // fmt.Printf("FindTagMatches(): tags = %v\n", tags)
           addit = true
         }
-        if tag == "thisweek" && curr.IsThisWeek() {
+        if tag == "thisweek" && curr.IsThisWeekAt(now, weekStart) {
           addit = true
         }         
       }
@@ -214,11 +269,107 @@ func (list Tasks) FindTagMatches(tags []string, system bool) Tasks {
   return result 
 }
 
-// return a list of all tasks in the list that have the today flag set
-func (list Tasks) FindToday() Tasks {
+// return a list of all tasks in the list that are not complete and were
+// due before the time given (usually now)
+func (list Tasks) FindOverdue(now time.Time) Tasks {
+  var result Tasks
+  for _, curr := range list {
+    if curr.IsOverdue(now) {
+      result = append(result, curr)
+    }
+  }
+  return result
+}
+
+// return a list of all tasks in the list due at or after dateStart and
+// before dateEnd (whether complete or not)
+func (list Tasks) FindDueBetween(dateStart time.Time, dateEnd time.Time) Tasks {
+  var result Tasks
+  for _, curr := range list {
+    due := curr.GetDueTime()
+    if due != nil && !due.Before(dateStart) && due.Before(dateEnd) {
+      result = append(result, curr)
+    }
+  }
+  return result
+}
+
+// TaskSortKey: what a list of tasks can be sorted on
+type TaskSortKey int
+const (
+  sortPosition TaskSortKey = iota // the order the tasks are in under their parent
+  sortPriority
+  sortDue
+  sortCreated
+)
+var taskSortKeyStrings = []string{"position", "priority", "due", "created"}
+func (k TaskSortKey) String() string {
+  return taskSortKeyStrings[k]
+}
+func TaskSortKeyFromString(s string) (TaskSortKey, error) {
+  i := findString(taskSortKeyStrings, s)
+  if i < 0 {
+    return sortPosition, errors.New(fmt.Sprintf("pim-sort: cannot sort on <%s>", s))
+  }
+  return TaskSortKey(i), nil
+}
+
+/*
+=============================================================================
+ Sort()
+-----------------------------------------------------------------------------
+ Inputs:  key        TaskSortKey - what to sort on
+          descending bool        - largest / latest first
+
+ Returns a sorted copy of the list, leaving the list itself alone.  Tasks
+ that compare the same keep the order they had in the list, so sorting on
+ position just keeps (or with descending reverses) the list's order.  Tasks
+ with no due time come last when sorting on due time either way.
+=============================================================================*/
+func (list Tasks) Sort(key TaskSortKey, descending bool) Tasks {
+  result := make(Tasks, len(list))
+  copy(result, list)
+
+  if key == sortPosition {
+    if descending {
+      for i, j := 0, len(result) - 1; i < j; i, j = i + 1, j - 1 {
+        result[i], result[j] = result[j], result[i]
+      }
+    }
+    return result
+  }
+
+  // before: true if a sorts before b in ascending order
+  var before func(a *Task, b *Task) bool
+  switch key {
+  case sortPriority:
+    before = func(a *Task, b *Task) bool { return a.priority < b.priority }
+  case sortCreated:
+    before = func(a *Task, b *Task) bool { // unknown times count as oldest
+      return b.createdTime != nil && (a.createdTime == nil || a.createdTime.Before(*b.createdTime))
+    }
+  case sortDue:
+    before = func(a *Task, b *Task) bool { return a.DueTime.Before(*b.DueTime) }
+  }
+  sort.SliceStable(result, func(i, j int) bool {
+    a, b := result[i], result[j]
+    if key == sortDue && (a.DueTime == nil || b.DueTime == nil) {
+      return a.DueTime != nil && b.DueTime == nil
+    }
+    if descending {
+      return before(b, a)
+    }
+    return before(a, b)
+  })
+  return result
+}
+
+// return a list of all tasks in the list that are for the day of now
+// (see IsTodayAt())
+func (list Tasks) FindToday(now time.Time) Tasks {
   var result Tasks
   for _, curr := range list {
-    if curr.IsToday() {
+    if curr.IsTodayAt(now) {
       result = append(result, curr)     
     }
   }
@@ -226,12 +377,12 @@ func (list Tasks) FindToday() Tasks {
 }
 
 
-// return a list of all tasks in the list that have the thisweek flag set
-// and are not complete
-func (list Tasks) FindThisWeek() Tasks {
+// return a list of all tasks in the list that are for the week of now
+// (see IsThisWeekAt())
+func (list Tasks) FindThisWeek(now time.Time, weekStart time.Weekday) Tasks {
   var result Tasks
   for _, curr := range list {
-    if curr.IsThisWeek() {
+    if curr.IsThisWeekAt(now, weekStart) {
       result = append(result, curr)     
     }
   }
@@ -294,7 +445,8 @@ func (list Tasks) FindByUser(u *User) Tasks {
 // When we break Tasks into its own package we will rename this to just "New()"
 func NewTask(newName string) *Task {
   id := uuid.NewV4()
-  return &Task{id:id.String(), name:newName, state:notStarted, memoryonly:false}
+  now := time.Now()
+  return &Task{id:id.String(), name:newName, state:notStarted, createdTime:&now, memoryonly:false}
 }
 
 // an in-memory-only task that will never be saved - used to group other tasks
@@ -341,6 +493,12 @@ func (t *Task) Copy(target *Task) *Task {
     tTarget.links = make([]TaskLink, len(t.links))
     copy(tTarget.links, t.links)
   }
+  if tTarget.sessions != nil {
+    tTarget.sessions = make([]WorkSession, len(t.sessions))
+    for i, s := range t.sessions {
+      tTarget.sessions[i] = WorkSession{Start:s.Start, Stop:copyTime(s.Stop), UserId:s.UserId}
+    }
+  }
   if tTarget.parents != nil {
     tTarget.parents = make([]*Task, len(t.parents))
     copy(tTarget.parents, t.parents)
@@ -354,6 +512,8 @@ func (t *Task) Copy(target *Task) *Task {
   tTarget.TargetStartTime      = copyTime(t.TargetStartTime)
   tTarget.ActualStartTime      = copyTime(t.ActualStartTime)
   tTarget.ActualCompletionTime = copyTime(t.ActualCompletionTime)
+  tTarget.DueTime              = copyTime(t.DueTime)
+  tTarget.createdTime          = copyTime(t.createdTime)
 
   // TBD - catch errors and return nil on those
 
@@ -467,6 +627,15 @@ func (t *Task) GetId() string {
   return t.id
 }
 
+// Version: the number of times the task has been saved, used by the data
+// mappers and the API to detect changes that would overwrite each other
+func (t *Task) SetVersion(newVersion int) {
+  t.version = newVersion
+}
+func (t *Task) GetVersion() int {
+  return t.version
+}
+
 // SetState: sets the task state
 func (t *Task) SetState(newState TaskState) {
   t.state = newState
@@ -516,6 +685,155 @@ func (t *Task) GetEstimate() time.Duration {
   return t.Estimate
 }
 
+// DueTime: the deadline for the task - separate from when we plan to start it
+func (t *Task) SetDueTime(due *time.Time) {
+  t.DueTime = due
+}
+func (t *Task) GetDueTime() *time.Time {
+  return t.DueTime
+}
+
+// IsOverdue: the task is due before the time given and still isn't done
+func (t *Task) IsOverdue(now time.Time) bool {
+  return t.DueTime != nil && t.DueTime.Before(now) && !t.IsComplete()
+}
+
+// Priority: larger numbers are more important, 0 means none was given
+func (t *Task) SetPriority(priority int) {
+  t.priority = priority
+}
+func (t *Task) GetPriority() int {
+  return t.priority
+}
+
+// CreatedTime: when the task was first created (nil if we don't know)
+func (t *Task) SetCreatedTime(created *time.Time) {
+  t.createdTime = created
+}
+func (t *Task) GetCreatedTime() *time.Time {
+  return t.createdTime
+}
+
+// Notes: free-form Markdown notes on the task ("" for none)
+func (t *Task) SetNotes(notes string) {
+  t.notes = notes
+}
+func (t *Task) GetNotes() string {
+  return t.notes
+}
+
+// Sessions: the work sessions on the task, oldest first
+func (t *Task) GetSessions() []WorkSession {
+  return t.sessions
+}
+func (t *Task) SetSessions(sessions []WorkSession) {
+  t.sessions = sessions
+}
+
+// RunningSession: the session whose timer is running, nil if none is
+func (t *Task) RunningSession() *WorkSession {
+  if len(t.sessions) > 0 && t.sessions[len(t.sessions)-1].Stop == nil {
+    return &t.sessions[len(t.sessions)-1]
+  }
+  return nil
+}
+
+// the timer errors are the client's mistake rather than ours
+var errTimerRunning = errors.New("pim-timer: timer is already running")
+var errTimerStopped = errors.New("pim-timer: timer is not running")
+
+// StartTimer: start a new work session (fails if one is already running).
+// The first session also marks when work on the task actually started.
+func (t *Task) StartTimer(u *User, now time.Time) error {
+  if t.RunningSession() != nil {
+    return errTimerRunning
+  }
+  s := WorkSession{Start:now}
+  if u != nil {
+    s.UserId = u.GetId()
+  }
+  t.sessions = append(t.sessions, s)
+  if t.GetActualStartTime() == nil {
+    t.SetActualStartTime(&now)
+  }
+  return nil
+}
+
+// StopTimer: end the running work session (fails if none is running)
+func (t *Task) StopTimer(now time.Time) error {
+  s := t.RunningSession()
+  if s == nil {
+    return errTimerStopped
+  }
+  s.Stop = &now
+  return nil
+}
+
+// TimeSpent: total time of all work sessions, counting a running one up to now
+func (t *Task) TimeSpent(now time.Time) time.Duration {
+  var spent time.Duration
+  for _, s := range t.sessions {
+    spent += s.Duration(now)
+  }
+  return spent
+}
+
+// Recurrence: rule for when the task comes back once completed (nil = never)
+func (t *Task) SetRecurrence(r *Recurrence) {
+  t.recurrence = r
+}
+func (t *Task) GetRecurrence() *Recurrence {
+  return t.recurrence
+}
+func (t *Task) IsRecurring() bool {
+  return t.recurrence != nil
+}
+
+// NextOccurrence: create (but don't save or attach) the next occurrence of
+// a recurring task, scheduled by its rule from this occurrence's target start
+// time (or its completion time if it had no target).  The new task takes
+// over the rule and everything else the user set up on the task, but starts
+// over as not started and not planned for today or this week.  Returns nil
+// if the task does not recur or its rule has ended.
+func (t *Task) NextOccurrence() *Task {
+  if t.recurrence == nil {
+    return nil
+  }
+  prev := t.GetTargetStartTime()
+  if prev == nil {
+    prev = t.GetActualCompletionTime()
+  }
+  if prev == nil {
+    now := time.Now()
+    prev = &now
+  }
+  when := t.recurrence.Next(*prev)
+  if when == nil {
+    return nil
+  }
+
+  next := NewTask(t.GetName())
+  next.SetEstimate(t.GetEstimate())
+  next.SetPriority(t.GetPriority())
+  next.SetTargetStartTime(when)
+  if t.DueTime != nil {
+    due := when.Add(t.DueTime.Sub(*prev)) // due as long after it starts as this one was
+    next.SetDueTime(&due)
+  }
+  next.SetRecurrence(t.recurrence.AnchoredTo(*prev))
+  for _, tag := range t.tags {
+    if !IsSystemTag(tag) {
+      next.SetTag(tag)
+    }
+  }
+  next.links = append(next.links, t.links...)
+  next.SetNotes(t.GetNotes())
+  for _, u := range t.users {
+    next.AddUser(u)
+  }
+  return next
+}
+
 func (t *Task) FindTag(target string) int {
   for i, v := range t.tags {
     if v == target {
@@ -559,7 +877,14 @@ func (t *Task) SetToday(today bool) {
   }
 }
 
+// IsToday: is the task for today in the server's time zone (see IsTodayAt())
 func (t *Task) IsToday() bool {
+  return t.IsTodayAt(time.Now())
+}
+
+// IsTodayAt: is the task for the day of now, where now is in the time zone
+// of the user asking
+func (t *Task) IsTodayAt(now time.Time) bool {
 
   // if labeled for today, then just return true
   if t.IsTagSet("today") {
@@ -572,12 +897,12 @@ func (t *Task) IsToday() bool {
     return false
   }
 
-  // otherwise we need to check the target completion date and
-  // compare it to today.  TBD: deal with time zones properly.
-  now := time.Now()
-  today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, target.Location())
-  dayOfTask := time.Date(target.Year(), target.Month(), target.Day(), 0, 0, 0, 0, target.Location())
-  return today == dayOfTask
+  // otherwise we need to check the target start date and compare it
+  // to today, both on the calendar of the user
+  local := target.In(now.Location())
+  today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+  dayOfTask := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, now.Location())
+  return today.Equal(dayOfTask)
 
 }
 
@@ -729,38 +1054,38 @@ func (t *Task) SetThisWeek(thisWeekNew bool) {
   }
 }
 
-// checks if the task's target start time is between sunday and saturday of this week
-func (t *Task) isWithinThisWeek() bool {
+// checks if the task's target start time is within the week of now
+func (t *Task) isWithinWeekOf(now time.Time, weekStart time.Weekday) bool {
   // if there is no target start time then it's not for this week
   target := t.GetTargetStartTime()
   if target == nil {
     return false
   }
 
-  // otherwise we need to check the target start date and
-  // compare it to the range that is this week - we are hard-coded
-  // to start the week on previous Sunday and end on next Saturday (not now)
+  // otherwise we need to check the target start date and compare it to
+  // the range that is this week where the user is
   // NOTE: I'm not sure we even want to do this.  Perhaps you should only
   // put something in the weekly view if it is explicitly set to it?
-  now := time.Now()
-  daysPastSunday := int(now.Weekday())
-  sunday := now.AddDate(0,0,-daysPastSunday)
-  sunday.Truncate((24*time.Hour))
-  saturday := sunday.AddDate(0,0,6)
-
-  dayOfTask := time.Date(target.Year(), target.Month(), target.Day(), 0, 0, 0, 0, target.Location())
-  isThisWeek := (dayOfTask == sunday) || (dayOfTask == saturday) || (dayOfTask.After(sunday) && dayOfTask.Before(saturday))
-  fmt.Printf("IsThisWeek() dayOfTask is between sunday and saturday = %b\n", isThisWeek)
-  return isThisWeek 
+  start := weekStarting(now, weekStart)
+  end := start.AddDate(0, 0, 7)
+  return !target.Before(start) && target.Before(end)
 }
 
+// IsThisWeek: is the task for this week in the server's time zone with
+// weeks starting on Sunday (see IsThisWeekAt())
 func (t *Task) IsThisWeek() bool {
+  return t.IsThisWeekAt(time.Now(), time.Sunday)
+}
+
+// IsThisWeekAt: is the task for the week of now, where now is in the time
+// zone of the user asking and their weeks start on weekStart
+func (t *Task) IsThisWeekAt(now time.Time, weekStart time.Weekday) bool {
   // if labeled for this week, then just return true
   if t.IsTagSet("thisweek") {
     return true
   }
 
-  return t.isWithinThisWeek()
+  return t.isWithinWeekOf(now, weekStart)
 }
 
 func (t *Task) SetDontForget(dontForgetNew bool) {
@@ -877,12 +1202,37 @@ func (t Task) FindDescendent(id string) *Task {
       return curr
     }
     if curr.HasChildren() {
-      return curr.FindDescendent(id)
+      found := curr.FindDescendent(id)
+      if found != nil {
+        return found
+      }
     }
   }
   return nil
 }
 
+// Descendants: every task below this one (each just once, even with many
+// parents), or only those the user has access to if a user is given
+func (t *Task) Descendants(u *User) Tasks {
+  var result Tasks
+  seen := make(map[*Task]bool)
+  var walk func(p *Task)
+  walk = func(p *Task) {
+    for _, curr := range p.kids {
+      if seen[curr] {
+        continue
+      }
+      seen[curr] = true
+      if u == nil || curr.UserHasAccess(u) {
+        result = append(result, curr)
+      }
+      walk(curr)
+    }
+  }
+  walk(t)
+  return result
+}
+
 // CurrentParent: returns the first parent found with its
 // current flag set to true.  Used by UIs to track a
 // particular path through the parent / child chain
@@ -897,19 +1247,30 @@ func (t *Task) CurrentParent() *Task {
 }
 
 func (t *Task) GetParentIds(includeMemoryOnly bool) []string {
-  ids := make([]string, len(t.parents))
-    for i, v := range t.parents {
-      if includeMemoryOnly || !v.IsMemoryOnly() {
-          ids[i] = v.GetId()
-      }
+  ids := make([]string, 0, len(t.parents))
+  for _, v := range t.parents {
+    if includeMemoryOnly || !v.IsMemoryOnly() {
+      ids = append(ids, v.GetId())
     }
-    return ids
+  }
+  return ids
 }
 
+// GetChildIds: returns the ids of all the children of the task
+func (t *Task) GetChildIds() []string {
+  ids := make([]string, 0, len(t.kids))
+  for _, v := range t.kids {
+    ids = append(ids, v.GetId())
+  }
+  return ids
+}
+
+// IsAncestorOf: returns true if this task is anywhere above the provided
+// task in the hierarchy - used to prevent cycles when adding parents
+func (t *Task) IsAncestorOf(k *Task) bool {
+  return t.FindDescendent(k.GetId()) != nil
+}
 
-// IterChild: returns the child delta number of
-// items from the last returned child.  Provide
-// delta = 0 to get the first child.
 
 // IterTasks: worker that operates over any slice of tasks
 // and given a current index i will return the task ahead
@@ -941,40 +1302,65 @@ func IterTasks(tasks []*Task, i int, delta int) (int, *Task) {
   return j, tasks[j]
 }
 
-func (t *Task) IterChild(delta int) *Task {
-  var nextChild *Task = nil
-  t.iterCurrChild, nextChild = IterTasks(t.kids, t.iterCurrChild, delta)
-  return nextChild
+// TaskIter: iterates over the parents or children of a task.  The iterator
+// keeps its own position (the task does not) so any number of callers can
+// walk the same task at once, and it walks a copy of the list so changing
+// the task's parents or children while iterating is safe
+type TaskIter struct {
+  tasks Tasks
+  curr int
 }
 
-func (t *Task) IterParent(delta int) *Task {
-  var nextParent *Task = nil
-  t.iterCurrParent, nextParent = IterTasks(t.parents, t.iterCurrParent, delta)
-  return nextParent
+func NewTaskIter(tasks Tasks) *TaskIter {
+  it := &TaskIter{tasks:make(Tasks, len(tasks))}
+  copy(it.tasks, tasks)
+  return it
 }
 
-func (t *Task) FirstChild() *Task {
-  return t.IterChild(0)
+func (it *TaskIter) First() *Task {
+  var first *Task
+  it.curr, first = IterTasks(it.tasks, it.curr, 0)
+  return first
 }
 
-func (t *Task) NextChild() *Task {
-  return t.IterChild(1)
+func (it *TaskIter) Next() *Task {
+  var next *Task
+  it.curr, next = IterTasks(it.tasks, it.curr, 1)
+  return next
 }
 
-func (t *Task) PrevChild() *Task {
-  return t.IterChild(-1)
+func (it *TaskIter) Prev() *Task {
+  var prev *Task
+  it.curr, prev = IterTasks(it.tasks, it.curr, -1)
+  return prev
 }
 
-func (t *Task) FirstParent() *Task {
-  return t.IterParent(0)
+// IterChildren: returns an iterator over my children, use like this:
+//   it := t.IterChildren()
+//   for c := it.First(); c != nil; c = it.Next() { ... }
+func (t *Task) IterChildren() *TaskIter {
+  return NewTaskIter(t.kids)
 }
 
-func (t *Task) NextParent() *Task {
-  return t.IterParent(1)
+// IterParents: returns an iterator over my parents
+func (t *Task) IterParents() *TaskIter {
+  return NewTaskIter(t.parents)
 }
 
-func (t *Task) PrevParent() *Task {
-  return t.IterParent(-1)
+// FirstChild: returns my first child or nil if I have none
+func (t *Task) FirstChild() *Task {
+  if len(t.kids) == 0 {
+    return nil
+  }
+  return t.kids[0]
+}
+
+// FirstParent: returns my first parent or nil if I have none
+func (t *Task) FirstParent() *Task {
+  if len(t.parents) == 0 {
+    return nil
+  }
+  return t.parents[0]
 }
 
 
@@ -1124,8 +1510,10 @@ func (t *Task) Remove(newParent *Task) error {
     }
   }
 
-  // remove from parent's child lists
-  for _, p := range t.parents {
+  // remove from parent's child lists - iterators walk
+  // a copy of the lists we are removing from
+  itParents := t.IterParents()
+  for p := itParents.First(); p != nil; p = itParents.Next() {
     err := p.RemoveChild(t)
     if err != nil {
       return err
@@ -1135,39 +1523,62 @@ func (t *Task) Remove(newParent *Task) error {
   // remove from kids parent lists
   // replacing with new parent if specified
   // and child will be orphaned otherwise
-  for _, k := range t.kids {
+  itKids := t.IterChildren()
+  for k := itKids.First(); k != nil; k = itKids.Next() {
     err := k.RemoveParent(t)
     if err != nil {
       return err
     }   
-    if newParent != nil && !k.HasChildren() {
+    if newParent != nil && !k.HasParents() {
       k.AddParent(newParent)
     }
   }
   return nil
 }
 
-// move before the specified task, or at the end if no target provided
-func (t *Task) MoveBefore(list Tasks, target *Task) error {
+// Position: where I am in the parent's list of children (-1 if not its child)
+func (t *Task) Position(parent *Task) int {
+  return parent.findChild(t)
+}
+
+// SortChildren: put my children in order - for mappers restoring the order
+// they saved (keeps the current order of children that compare the same)
+func (t *Task) SortChildren(less func(a *Task, b *Task) bool) {
+  sort.SliceStable(t.kids, func(i, j int) bool { return less(t.kids[i], t.kids[j]) })
+}
+
+// errors moving a task within its parent's list of children
+var errMoveNotInList = errors.New("pim-move: task is not in list")
+var errMoveTargetNotInList = errors.New("pim-move: target task is not in list")
 
-  // make sure both tasks are in the same list to start with
-  indexTask := findTaskInSlice(list, t)
+// move before the specified task in the parent's list of children, or to
+// the end of the list if no target is provided
+func (t *Task) MoveBefore(parent *Task, target *Task) error {
+
+  // make sure the task is a child of the parent to start with
+  indexTask := parent.findChild(t)
   if indexTask == -1 {
-    return errors.New("pim-move: task is not in list")  
+    return errMoveNotInList
   }
 
   // if specified, make sure target is in the same list
-  var indexTarget = len(list)
   if target != nil {
-    indexTarget = findTaskInSlice(list, target)
-    if indexTarget == -1 {
-      return errors.New("pim-move: target task is not in list") 
+    if parent.findChild(target) == -1 {
+      return errMoveTargetNotInList
+    }
+    if target == t {
+      return nil // moving before myself leaves me where I am
     }
   }
 
-  // move me before sibling or to the end of the list
-  removeTaskFromSlice(list, indexTask)
-  insertTaskInSlice(list, t, indexTarget)
+  // move me before sibling or to the end of the list - note we must
+  // find the target after removing myself since indexes may shift
+  parent.kids = removeTaskFromSlice(parent.kids, indexTask)
+  indexTarget := len(parent.kids)
+  if target != nil {
+    indexTarget = parent.findChild(target)
+  }
+  parent.kids = insertTaskInSlice(parent.kids, t, indexTarget)
   return nil
 }
 
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"regexp"
 	"strconv"
+	"time"
 )
 
 func validUUIDv4(id string) bool {
@@ -15,14 +16,14 @@ func validateDefaultTask(task *Task, expectedName string, t *testing.T) {
 	if task == nil {
 		t.Error("Failed to even create a task - nill returned")
 	}
-	if !validUUIDv4(task.Id()) {
-		t.Error("Task created with unexpected uiid value: ", task.Id())
+	if !validUUIDv4(task.GetId()) {
+		t.Error("Task created with unexpected uiid value: ", task.GetId())
 	}
-	if task.Name() != expectedName {
-		t.Error("Task name expected ", expectedName, " but found: ", task.Name())
+	if task.GetName() != expectedName {
+		t.Error("Task name expected ", expectedName, " but found: ", task.GetName())
 	}
-	if task.State() != notStarted {
-		t.Error("Task state expected <notStarted> but found: ", task.State())
+	if task.GetState() != notStarted {
+		t.Error("Task state expected <notStarted> but found: ", task.GetState())
 	}
 }
 
@@ -32,17 +33,17 @@ func TestTaskCreate(t *testing.T) {
 	validateDefaultTask(task, "Test Task", t)
 
 	task.SetName("Test Task Renamed")
-	if task.Name() != "Test Task Renamed" {
-		t.Error("Task rename failed, expected <Test Task Rename> but found: ", task.Name())
+	if task.GetName() != "Test Task Renamed" {
+		t.Error("Task rename failed, expected <Test Task Rename> but found: ", task.GetName())
 	}
 	task.SetName("")
-	if task.Name() != "" {
-		t.Error("Task rename failed, expected empty name but found: ", task.Name())
+	if task.GetName() != "" {
+		t.Error("Task rename failed, expected empty name but found: ", task.GetName())
 	}
 
 	task.SetState(complete)
-	if task.State() != complete {
-		t.Error("Task SetState() failed, expected <complete> but found: ", task.State())
+	if task.GetState() != complete {
+		t.Error("Task SetState() failed, expected <complete> but found: ", task.GetState())
 	}
 }
 
@@ -75,7 +76,8 @@ func TestTaskHierarchy(t *testing.T) {
 
 	// validate that we can iterate over all the kids
 	i := childrenToTest
-	for c := parent.FirstChild(); c != nil; c = parent.NextChild() {
+	itKids := parent.IterChildren()
+	for c := itKids.First(); c != nil; c = itKids.Next() {
 		i -= 1
 	}
 	if i != 0 {
@@ -110,7 +112,8 @@ func TestTaskHierarchy(t *testing.T) {
 
 	// validate that we can iterate over all the grandparents
 	i = grandparentsToTest
-	for g := parent.FirstParent(); g != nil; g = parent.NextParent() {
+	itParents := parent.IterParents()
+	for g := itParents.First(); g != nil; g = itParents.Next() {
 		i -= 1
 	}
 	if i != 0 {
@@ -128,10 +131,145 @@ func TestTaskHierarchy(t *testing.T) {
 
 	// validate we can reparent children when removing ourselves
 	grandParent := parent.FirstParent()
-	idToCheck := parent.FirstChild().Id()
+	idToCheck := parent.FirstChild().GetId()
 	parent.Remove(grandParent)
-	if grandParent.NumChildren() != childrenToTest - 2  || grandParent.FindChild(idToCheck) == nil {
+	if grandParent.NumChildren() != childrenToTest - 2  || grandParent.FindChild(idToCheck, nil) == nil {
 		t.Error("Reparenting failed when removing a task with children.")
 	}
 
-}
\ No newline at end of file
+}
+
+// tasks are found by their due times, and only incomplete ones are overdue
+func TestTaskDue(t *testing.T) {
+	now := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	yesterday := now.AddDate(0, 0, -1)
+	tomorrow := now.AddDate(0, 0, 1)
+
+	late := NewTask("late")
+	late.SetDueTime(&yesterday)
+	done := NewTask("done")
+	done.SetDueTime(&yesterday)
+	done.SetState(complete)
+	soon := NewTask("soon")
+	soon.SetDueTime(&tomorrow)
+	whenever := NewTask("whenever")
+	list := Tasks{late, done, soon, whenever}
+
+	overdue := list.FindOverdue(now)
+	if len(overdue) != 1 || overdue[0] != late {
+		t.Error("Expected only <late> to be overdue but found", len(overdue), "tasks")
+	}
+	due := list.FindDueBetween(yesterday, now)
+	if len(due) != 2 || due[0] != late || due[1] != done {
+		t.Error("Expected <late> and <done> due before now but found", len(due), "tasks")
+	}
+	due = list.FindDueBetween(now, tomorrow.Add(time.Second))
+	if len(due) != 1 || due[0] != soon {
+		t.Error("Expected only <soon> due after now but found", len(due), "tasks")
+	}
+}
+
+// lists sort on each key either way, keeping list order for ties
+func TestTaskSort(t *testing.T) {
+	day := func(d int) *time.Time {
+		when := time.Date(2026, time.October, d, 9, 0, 0, 0, time.UTC)
+		return &when
+	}
+	a := NewTask("a")
+	a.SetPriority(1)
+	a.SetDueTime(day(20))
+	a.SetCreatedTime(day(3))
+	b := NewTask("b")
+	b.SetPriority(3)
+	b.SetCreatedTime(day(1))
+	c := NewTask("c")
+	c.SetPriority(1)
+	c.SetDueTime(day(18))
+	c.SetCreatedTime(day(2))
+	list := Tasks{a, b, c}
+
+	names := func(ts Tasks) string {
+		s := ""
+		for _, task := range ts {
+			s += task.GetName()
+		}
+		return s
+	}
+	tests := []struct {
+		key        TaskSortKey
+		descending bool
+		expected   string
+	}{
+		{sortPosition, false, "abc"},
+		{sortPosition, true, "cba"},
+		{sortPriority, false, "acb"},
+		{sortPriority, true, "bac"},
+		{sortDue, false, "cab"},
+		{sortDue, true, "acb"},
+		{sortCreated, false, "bca"},
+		{sortCreated, true, "acb"},
+	}
+	for _, test := range tests {
+		if sorted := names(list.Sort(test.key, test.descending)); sorted != test.expected {
+			t.Error("Sort on", test.key, "descending", test.descending, "expected", test.expected, "but found", sorted)
+		}
+	}
+	if names(list) != "abc" {
+		t.Error("Sort changed the list it sorted:", names(list))
+	}
+}
+
+// work sessions add up to the time spent, and only one runs at a time
+func TestTaskTimer(t *testing.T) {
+	start := time.Date(2026, time.October, 17, 9, 0, 0, 0, time.UTC)
+	task := NewTask("timed")
+
+	if err := task.StopTimer(start); err == nil {
+		t.Error("Expected stopping a timer that never started to fail")
+	}
+	if err := task.StartTimer(nil, start); err != nil {
+		t.Error("Unable to start timer:", err)
+	}
+	if err := task.StartTimer(nil, start); err == nil {
+		t.Error("Expected starting a running timer to fail")
+	}
+	if task.GetActualStartTime() == nil || !task.GetActualStartTime().Equal(start) {
+		t.Error("Expected first session to set the actual start time")
+	}
+	task.StopTimer(start.Add(30 * time.Minute))
+	task.StartTimer(nil, start.Add(time.Hour))
+
+	if spent := task.TimeSpent(start.Add(75 * time.Minute)); spent != 45 * time.Minute {
+		t.Error("Expected 45 minutes spent (counting the running session) but found", spent)
+	}
+	if copied := task.Copy(nil); copied.RunningSession() == nil || copied.RunningSession() == task.RunningSession() {
+		t.Error("Expected a copy to have its own running session")
+	}
+}
+
+// today and this week depend on the time zone and week of who is asking
+func TestTaskTodayAt(t *testing.T) {
+	newYork, errNY := time.LoadLocation("America/New_York")
+	tokyo, errTokyo := time.LoadLocation("Asia/Tokyo")
+	if errNY != nil || errTokyo != nil {
+		t.Skip("time zone data is not available")
+	}
+	target := time.Date(2026, time.October, 17, 2, 0, 0, 0, time.UTC) // Friday evening in New York
+	task := NewTask("call home")
+	task.SetTargetStartTime(&target)
+	list := Tasks{task}
+
+	nowNY := time.Date(2026, time.October, 16, 12, 0, 0, 0, newYork)
+	nowTokyo := time.Date(2026, time.October, 17, 12, 0, 0, 0, tokyo)
+	if len(list.FindToday(nowNY)) != 1 || len(list.FindToday(nowTokyo)) != 1 {
+		t.Error("Expected the task to be today on Friday in New York and Saturday in Tokyo")
+	}
+	if task.IsTodayAt(nowNY.AddDate(0, 0, 1)) || task.IsTodayAt(nowTokyo.AddDate(0, 0, -1)) {
+		t.Error("Expected the task not to be today on the days either side")
+	}
+
+	sunday := time.Date(2026, time.October, 18, 12, 0, 0, 0, newYork)
+	if len(list.FindThisWeek(sunday, time.Sunday)) != 0 || len(list.FindThisWeek(sunday, time.Monday)) != 1 {
+		t.Error("Expected the task to be this week on Sunday only for weeks that start on Monday")
+	}
+}
@@ -3,8 +3,10 @@ package main
 import "fmt"
 import "errors"
 import "log"
+import "net"
 import "os"
 import "database/sql"
+import "strings"
 import "time"
 import "github.com/lib/pq"
 
@@ -18,7 +20,7 @@ const (
     // the migration version is used with my homemade migration code
     // and maps to a 4-digit set of migration files for Origin, Up
     // and Down files to be run on clean DBs, to upgrade or rollback.
-    DB_MIGRATION_VERSION = 5
+    DB_MIGRATION_VERSION = 21
 )
 
 type PimPersistPostgreSQL struct {
@@ -33,6 +35,7 @@ type TaskDataMapperPostgreSQL struct {
   dbName string
   id int // can we get rid of both of these id and parentIds?
   parentIds []string
+  childIds []string // order of my children last saved or loaded
   err error
 }
 
@@ -280,16 +283,16 @@ func (tm TaskDataMapperPostgreSQL) CopyDataMapper() TaskDataMapper {
 // on the task into the task_tags db table.  Now that tags are stored as tags in
 // memory as well, we should change this stuff to just dump all the tags into the
 // DB but we need a good way to RESET a tag that was removed.
-func (tm TaskDataMapperPostgreSQL) syncSystemTag(tagBool bool, t *Task, tagName string, tagId int, newTask bool) error {
+func (tm TaskDataMapperPostgreSQL) syncSystemTag(tx dbRunner, tagBool bool, t *Task, tagName string, tagId int, newTask bool) error {
   if (tagBool) {
-    _, err := dbExec(env, `INSERT INTO task_tags (task_id, tag_id) VALUES ($1, $2) 
+    _, err := dbTxExec(tx, `INSERT INTO task_tags (task_id, tag_id) VALUES ($1, $2) 
                          ON CONFLICT ON CONSTRAINT pk_tasktags DO NOTHING`, t.GetId(), tagId)
     if (err != nil) { 
       err = errors.New(fmt.Sprintf("tdmp.Save(): Unable to insert %s tag over task %s: %s", tagName, t.GetName(), err))
       return err
     }
   } else if !newTask {
-    _, err := dbExec(env, `DELETE FROM task_tags WHERE task_id = $1 AND tag_id = $2`, t.GetId(), tagId)
+    _, err := dbTxExec(tx, `DELETE FROM task_tags WHERE task_id = $1 AND tag_id = $2`, t.GetId(), tagId)
     if (err != nil) { 
       // eat this error - we always try to delete if system tag is not set on the object
       // this is dangerous - if other DB errors cause this we will eat the error!
@@ -298,16 +301,16 @@ func (tm TaskDataMapperPostgreSQL) syncSystemTag(tagBool bool, t *Task, tagName
   return nil;
 }
 
-func (tm TaskDataMapperPostgreSQL) syncSystemTags(t *Task, newTask bool) error {
-  err := tm.syncSystemTag(t.IsTagSet("today"), t, "today", 1, newTask)
+func (tm TaskDataMapperPostgreSQL) syncSystemTags(tx dbRunner, t *Task, newTask bool) error {
+  err := tm.syncSystemTag(tx, t.IsTagSet("today"), t, "today", 1, newTask)
   if (err != nil) {
     return err;
   }
-  err = tm.syncSystemTag(t.IsTagSet("thisweek"), t, "thisweek", 2, newTask)
+  err = tm.syncSystemTag(tx, t.IsTagSet("thisweek"), t, "thisweek", 2, newTask)
   if (err != nil) {
     return err;
   }
-  err = tm.syncSystemTag(t.IsDontForget(), t, "dontforget", 3, newTask)
+  err = tm.syncSystemTag(tx, t.IsDontForget(), t, "dontforget", 3, newTask)
   if (err != nil) {
     return err;
   }
@@ -338,11 +341,11 @@ func (tm TaskDataMapperPostgreSQL) syncSystemTags(t *Task, newTask bool) error {
  hyperlinks.  This will require the new task_links table coded (but never tested)
  in migration version 0004.
 ================================================================================*/
-func (tm TaskDataMapperPostgreSQL) loadTaskTags(t *Task) (map[string]int, error) {
+func (tm TaskDataMapperPostgreSQL) loadTaskTags(q dbRunner, t *Task) (map[string]int, error) {
   taskTagsDB := make(map[string]int)
   taskQuery := fmt.Sprintf(`SELECT tags.name, tags.id FROM tags JOIN task_tags ON task_tags.tag_id = tags.id WHERE task_tags.task_id = '%s'`, 
                    t.GetId())
-  taskTags, err := env.db.Query(taskQuery)
+  taskTags, err := q.Query(taskQuery)
   if err != nil {
     log.Printf("query for the task tags failed: %s\n", taskQuery)
     return nil, err
@@ -366,10 +369,10 @@ func (tm TaskDataMapperPostgreSQL) loadTaskTags(t *Task) (map[string]int, error)
 // tbd - cache this (on the tm?) since it will be used over and over - but keeping
 // the cache up to date as things get saved might be a pain.  If cached, this
 // can abstract it - just return the cached map of tags.
-func (tm TaskDataMapperPostgreSQL) loadAllTags() (map[string]int, error) {
+func (tm TaskDataMapperPostgreSQL) loadAllTags(q dbRunner) (map[string]int, error) {
   allTags := make(map[string]int)
   tagQuery := `SELECT tags.name, tags.id FROM tags`
-  tags, err := env.db.Query(tagQuery)
+  tags, err := q.Query(tagQuery)
   if err != nil {
     log.Printf("query for the tags failed: %s\n", tagQuery)
     return nil, err
@@ -388,19 +391,19 @@ func (tm TaskDataMapperPostgreSQL) loadAllTags() (map[string]int, error) {
   return allTags, nil
 }
 
-func (tm TaskDataMapperPostgreSQL) syncTags(t *Task, newTask bool) error {
+func (tm TaskDataMapperPostgreSQL) syncTags(tx dbRunner, t *Task, newTask bool) error {
 
   // LOCK NEEDED?
 
   // collect the list of all tags in the DB (someday just the ones for this user)
-  allTags, err := tm.loadAllTags()
+  allTags, err := tm.loadAllTags(tx)
   if err != nil {
     return err
   }
   // log.Printf("syncTags(): allTags=%v\n", allTags)
 
   // collect the list of tags on the DB-version of this task in a modifiable form
-  taskTagsDB, err := tm.loadTaskTags(t)
+  taskTagsDB, err := tm.loadTaskTags(tx, t)
   if err != nil {
     return err
   }
@@ -422,14 +425,14 @@ func (tm TaskDataMapperPostgreSQL) syncTags(t *Task, newTask bool) error {
       // log.Printf("syncTags(): Tag <%v> is not in DB yet, adding to DB...\n", tagMem)
 
         var tagId int
-        err := env.db.QueryRow(`INSERT INTO tags (name, system) VALUES ($1, FALSE) RETURNING id`, tagMem).Scan(&tagId)
+        err := tx.QueryRow(`INSERT INTO tags (name, system) VALUES ($1, FALSE) RETURNING id`, tagMem).Scan(&tagId)
       if (err != nil) { 
         err = errors.New(fmt.Sprintf("tdmp.syncTags(): Unable to insert tag %s: %s", tagMem, err))
         return err
       }
       
       // log.Printf("syncTags(): Tag <%v> is now in DB as id <%v>, adding to task id <%v>...\n", tagMem, tagId, t.GetId())
-        _, err = dbExec(env, `INSERT INTO task_tags (task_id, tag_id) VALUES ($1, $2)`, t.GetId(), tagId)
+        _, err = dbTxExec(tx, `INSERT INTO task_tags (task_id, tag_id) VALUES ($1, $2)`, t.GetId(), tagId)
       if (err != nil) { 
         err = errors.New(fmt.Sprintf("tdmp.syncTags(): Unable to insert tag linkage %s to %s: %s", tagMem, t.GetName(), err))
         return err
@@ -447,7 +450,7 @@ func (tm TaskDataMapperPostgreSQL) syncTags(t *Task, newTask bool) error {
 
         // link the tag to the task
         tagId = allTags[tagMem]
-          _, err := dbExec(env, `INSERT INTO task_tags (task_id, tag_id) VALUES ($1, $2)`, t.GetId(), tagId)
+          _, err := dbTxExec(tx, `INSERT INTO task_tags (task_id, tag_id) VALUES ($1, $2)`, t.GetId(), tagId)
         if (err != nil) { 
           err = errors.New(fmt.Sprintf("tdmp.syncTags(): Unable to insert tag linkage %s to %s: %s", tagMem, t.GetName(), err))
           return err
@@ -473,7 +476,7 @@ func (tm TaskDataMapperPostgreSQL) syncTags(t *Task, newTask bool) error {
     }
 
     // unlink (remove) the tags' relations to the task
-    _, err := dbExec(env, "DELETE FROM task_tags WHERE task_id = $1 AND tag_id = ANY ($2)", t.GetId(), pq.Array(tagIdsToDelete))
+    _, err := dbTxExec(tx, "DELETE FROM task_tags WHERE task_id = $1 AND tag_id = ANY ($2)", t.GetId(), pq.Array(tagIdsToDelete))
     if err != nil {
       err = errors.New(fmt.Sprintf("tdmp.syncTags(): Unable to remove tags from task %s: %s", t.GetName(), err))
       return err
@@ -509,11 +512,11 @@ func (tm TaskDataMapperPostgreSQL) syncTags(t *Task, newTask bool) error {
  supported.  The fields are on the in-memory objects and are in the DB, but the
  mapper does not yet load or save them properly - only the URI is used.
 ================================================================================*/
-func (tm TaskDataMapperPostgreSQL) loadTaskLinks(t *Task) (map[string]int, error) {
+func (tm TaskDataMapperPostgreSQL) loadTaskLinks(q dbRunner, t *Task) (map[string]int, error) {
   taskLinksDB := make(map[string]int)
   taskQuery := fmt.Sprintf(`SELECT links.uri, links.nameOffset, links.nameLength, links.id FROM task_links AS links WHERE links.task_id = '%s'`, 
                    t.GetId())
-  taskLinks, err := env.db.Query(taskQuery)
+  taskLinks, err := q.Query(taskQuery)
   if err != nil {
     log.Printf("query for the task links failed: %s (%s)\n", err, taskQuery)
     return nil, err
@@ -535,10 +538,10 @@ func (tm TaskDataMapperPostgreSQL) loadTaskLinks(t *Task) (map[string]int, error
   return taskLinksDB, err
 }
 
-func (tm TaskDataMapperPostgreSQL) syncLinks(t *Task, newTask bool) error {
+func (tm TaskDataMapperPostgreSQL) syncLinks(tx dbRunner, t *Task, newTask bool) error {
 
   // collect the list of tags on the DB-version of this task in a modifiable form
-  taskLinksDB, err := tm.loadTaskLinks(t)
+  taskLinksDB, err := tm.loadTaskLinks(tx, t)
   if err != nil {
     return err
   }
@@ -552,7 +555,7 @@ func (tm TaskDataMapperPostgreSQL) syncLinks(t *Task, newTask bool) error {
     _, inDBAlready := taskLinksDB[linkMem.GetURI()]
     if !inDBAlready {
         var linkId int
-        err := env.db.QueryRow(`INSERT INTO task_links (uri, nameOffset, nameLength, task_id) VALUES ($1, $2, $3, $4) RETURNING id`, 
+        err := tx.QueryRow(`INSERT INTO task_links (uri, nameOffset, nameLength, task_id) VALUES ($1, $2, $3, $4) RETURNING id`, 
                                linkMem.GetURI(), linkMem.NameOffset, linkMem.NameLen, t.GetId()).Scan(&linkId)
       if (err != nil) { 
         err = errors.New(fmt.Sprintf("tdmp.syncLinks(): Unable to insert link %s: %s", linkMem.GetURI(), err))
@@ -580,7 +583,7 @@ func (tm TaskDataMapperPostgreSQL) syncLinks(t *Task, newTask bool) error {
     }
 
     // remove the links from the task
-    _, err := dbExec(env, "DELETE FROM task_links WHERE id = ANY ($1)", pq.Array(linkIdsToDelete))
+    _, err := dbTxExec(tx, "DELETE FROM task_links WHERE id = ANY ($1)", pq.Array(linkIdsToDelete))
     if err != nil {
       err = errors.New(fmt.Sprintf("tdmp.syncLinks(): Unable to remove links from task %s: %s", t.GetName(), err))
       return err
@@ -600,10 +603,10 @@ func (tm TaskDataMapperPostgreSQL) syncLinks(t *Task, newTask bool) error {
  Given a loaded task, go find the users that have access to the task and give
  those users access to the task by assigning them to the task in memory.
 ================================================================================*/
-func (tm TaskDataMapperPostgreSQL) loadTaskUsers(t *Task) (Users, error) {
+func (tm TaskDataMapperPostgreSQL) loadTaskUsers(q dbRunner, t *Task) (Users, error) {
   us := make(Users, 0)
   taskQuery := fmt.Sprintf(`SELECT tu.user_id FROM task_users AS tu WHERE tu.task_id = '%s'`, t.GetId())
-  taskUsers, err := env.db.Query(taskQuery)
+  taskUsers, err := q.Query(taskQuery)
   if err != nil {
     log.Printf("query for the task users failed: %s (%s)\n", err, taskQuery)
     return nil, err
@@ -620,7 +623,7 @@ func (tm TaskDataMapperPostgreSQL) loadTaskUsers(t *Task) (Users, error) {
     // we assume all users are already loaded in our global list
     // so we merely have to find it - but ugly - have to get it
     // off a global list :-(
-    u := users.FindById(userId)
+    u := repo.Users().FindById(userId)
     if u == nil {
       log.Printf("User %s referenced on task in DB is not loaded in memory - failing.\n", userId)
       return nil, errors.New("User referenced on task in DB is not loaded in memory - failing.")
@@ -644,13 +647,13 @@ func (tm TaskDataMapperPostgreSQL) loadTaskUsers(t *Task) (Users, error) {
  access to the task from both the DB and memory, add any not already in the DB
  and delete any that ARE in the DB but are not on the in-memory task.
 ================================================================================*/
-func (tm TaskDataMapperPostgreSQL) syncUsers(t *Task) error {
+func (tm TaskDataMapperPostgreSQL) syncUsers(tx dbRunner, t *Task) error {
 
   log.Printf("syncUsers(): Enterd for task <%s> with %v users.\n", t.GetName(), len(t.GetUsers()))
 
   // collect the list of users in the DB for this task
   // note this list can be changed in this function - it is our own copy to play with
-  usersDB, err := tm.loadTaskUsers(t)
+  usersDB, err := tm.loadTaskUsers(tx, t)
   if err != nil {
     return err
   }
@@ -663,7 +666,7 @@ func (tm TaskDataMapperPostgreSQL) syncUsers(t *Task) error {
     // for now we'll add them one at a time.
     inDBAlready := usersDB.FindById(u.GetId())
     if inDBAlready == nil {
-      _, err := dbExec(env, `INSERT INTO task_users (user_id, task_id) VALUES ($1, $2)`, u.GetId(), t.GetId())
+      _, err := dbTxExec(tx, `INSERT INTO task_users (user_id, task_id) VALUES ($1, $2)`, u.GetId(), t.GetId())
       if (err != nil) { 
         err = errors.New(fmt.Sprintf("tdmp.syncLinks(): Unable to insert user access %s: %s", u.GetEmail(), err))
         return err
@@ -682,7 +685,7 @@ func (tm TaskDataMapperPostgreSQL) syncUsers(t *Task) error {
 
   // remove any "unused" remaining users in the list of links on the DB-version of this task
   for _, uDelete := range usersDB {
-    _, err := dbExec(env, "DELETE FROM task_users WHERE user_id = $1 AND task_id = %2", uDelete.GetId(), t.GetId())
+    _, err := dbTxExec(tx, "DELETE FROM task_users WHERE user_id = $1 AND task_id = $2", uDelete.GetId(), t.GetId())
     if err != nil {
       err = errors.New(fmt.Sprintf("tdmp.syncUsers(): Unable to remove user access from task %s: %s", t.GetName(), err))
       return err
@@ -693,6 +696,90 @@ func (tm TaskDataMapperPostgreSQL) syncUsers(t *Task) error {
 }
 
 
+/*
+==================================================================================
+ loadTaskSessions() / syncSessions()
+----------------------------------------------------------------------------------
+ Work sessions are only ever added, or stopped, and a task has few of them,
+ so rather than work out what changed we just replace the task's sessions
+ with the ones in memory.  Sessions are keyed by the task and start time.
+================================================================================*/
+func (tm TaskDataMapperPostgreSQL) loadTaskSessions(q dbRunner, t *Task) ([]WorkSession, error) {
+  var sessions []WorkSession
+  rows, err := q.Query(`SELECT started_at, stopped_at, user_id FROM task_sessions
+                        WHERE task_id = $1 ORDER BY started_at`, t.GetId())
+  if err != nil {
+    return nil, errors.New(fmt.Sprintf("tdmp.loadTaskSessions(): Unable to load work sessions of task %s: %s", t.GetName(), err))
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var (
+      started time.Time
+      db_stopped pq.NullTime
+      db_user_id sql.NullString
+    )
+    err := rows.Scan(&started, &db_stopped, &db_user_id)
+    if err != nil {
+      return nil, errors.New(fmt.Sprintf("tdmp.loadTaskSessions(): Unable to read work session of task %s: %s", t.GetName(), err))
+    }
+    sessions = append(sessions, WorkSession{Start:started, Stop:dbTimeCheck(db_stopped), UserId:db_user_id.String})
+  }
+  return sessions, rows.Err()
+}
+
+func (tm TaskDataMapperPostgreSQL) syncSessions(tx dbRunner, t *Task) error {
+  _, err := dbTxExec(tx, "DELETE FROM task_sessions WHERE task_id = $1", t.GetId())
+  if err != nil {
+    return errors.New(fmt.Sprintf("tdmp.syncSessions(): Unable to clear work sessions of task %s: %s", t.GetName(), err))
+  }
+  for _, s := range t.GetSessions() {
+    _, err := dbTxExec(tx, `INSERT INTO task_sessions (task_id, started_at, stopped_at, user_id) VALUES ($1, $2, $3, $4)`,
+                       t.GetId(), s.Start.UTC(), dbUTC(s.Stop), dbNullString(s.UserId))
+    if err != nil {
+      return errors.New(fmt.Sprintf("tdmp.syncSessions(): Unable to insert work session of task %s: %s", t.GetName(), err))
+    }
+  }
+  return nil
+}
+
+/*
+==================================================================================
+ syncPositions()
+----------------------------------------------------------------------------------
+ Store the order of the parent's children if it has changed since we last
+ stored or loaded it - one statement numbers them all.  Top-level tasks
+ have no row in task_parents (their parent is the memory-only root) so
+ their order is kept in tasks.position instead.  Children not yet in the
+ DB are simply skipped, and get their position once they are saved.
+================================================================================*/
+func (tm *TaskDataMapperPostgreSQL) syncPositions(tx dbRunner, before *pgSaveState, p *Task) error {
+  tmParent, ok := p.DataMapper().(*TaskDataMapperPostgreSQL)
+  if !ok {
+    return nil
+  }
+  childIds := p.GetChildIds()
+  if stringSlicesEqual(childIds, tmParent.childIds) {
+    return nil
+  }
+  before.remember(tmParent, p)
+
+  var err error
+  if !p.IsMemoryOnly() {
+    _, err = dbTxExec(tx, `UPDATE task_parents tp SET position = o.position
+                           FROM unnest($2::text[]) WITH ORDINALITY AS o(child_id, position)
+                           WHERE tp.parent_id = $1 AND tp.child_id = o.child_id`, p.GetId(), pq.Array(childIds))
+  } else {
+    _, err = dbTxExec(tx, `UPDATE tasks t SET position = o.position
+                           FROM unnest($1::text[]) WITH ORDINALITY AS o(id, position)
+                           WHERE t.id = o.id`, pq.Array(childIds))
+  }
+  if err != nil {
+    return errors.New(fmt.Sprintf("tdmp.Save(): Unable to store order of the children of %s: %s", p.GetName(), err))
+  }
+  tmParent.childIds = childIds
+  return nil
+}
+
 /*
 ==================================================================================
  Save()
@@ -704,8 +791,60 @@ func (tm TaskDataMapperPostgreSQL) syncUsers(t *Task) error {
                           group tasks, but doesn't want to save them)
 
  This function writes the provided in-memory task into the PostgreSQL database.
+ All the statements of the save, including those for any children saved with
+ it, run in a single transaction so a failure leaves the database untouched.
+ The in-memory state the save changes (mapper loaded flags and saved parent
+ ids, and task versions) is put back as it was if the transaction is rolled
+ back so it still matches the DB.
+
+ Each update bumps the version of the task, and only succeeds if the DB still
+ holds the version we have in memory - otherwise ErrVersionConflict.
 ================================================================================*/
 func (tm *TaskDataMapperPostgreSQL) Save(t *Task, saveChildren bool, saveMyself bool) error {
+  before := &pgSaveState{mappers:make(map[*TaskDataMapperPostgreSQL]TaskDataMapperPostgreSQL), versions:make(map[*Task]int)}
+  err := dbTransact(env, func(tx *sql.Tx) error {
+    return tm.save(tx, before, t, saveChildren, saveMyself)
+  })
+  if err != nil {
+    before.restore()
+  }
+  return err
+}
+
+// pgSaveState: in-memory state as it was before a save, for rollback
+type pgSaveState struct {
+  mappers map[*TaskDataMapperPostgreSQL]TaskDataMapperPostgreSQL
+  versions map[*Task]int
+}
+
+func (ss *pgSaveState) remember(tm *TaskDataMapperPostgreSQL, t *Task) {
+  if _, found := ss.mappers[tm]; !found {
+    tmBefore := *tm
+    tmBefore.parentIds = append([]string(nil), tm.parentIds...)
+    tmBefore.childIds = append([]string(nil), tm.childIds...)
+    ss.mappers[tm] = tmBefore
+  }
+  if _, found := ss.versions[t]; !found {
+    ss.versions[t] = t.GetVersion()
+  }
+}
+
+func (ss *pgSaveState) restore() {
+  for tmSaved, tmBefore := range ss.mappers {
+    *tmSaved = tmBefore
+  }
+  for t, version := range ss.versions {
+    t.SetVersion(version)
+  }
+}
+
+// save: does the work of Save() within the transaction provided, recording
+// the state of each mapper and task it touches in before so it can be restored
+func (tm *TaskDataMapperPostgreSQL) save(tx *sql.Tx, before *pgSaveState, 
+                                         t *Task, saveChildren bool, saveMyself bool) error {
+
+  // remember my in-memory state in case of rollback
+  before.remember(tm, t)
 
   // log.Printf("Save(%t, %t): task = %s, id = %s, loaded = %t len(parentIds) = %d", saveChildren, saveMyself, t.name, t.id, tm.loaded, len(tm.parentIds))
 
@@ -714,55 +853,78 @@ func (tm *TaskDataMapperPostgreSQL) Save(t *Task, saveChildren bool, saveMyself
 
     // upsert the task itself
     if tm.loaded {
-      _, err := dbExec(env, `UPDATE tasks SET name = $1, state = $2, target_start_time = $3, actual_start_time = $4, actual_completion_time = $5, estimate_minutes = $6 
-                           WHERE ID = $7`, t.GetName(), t.GetState(), t.TargetStartTime, t.ActualStartTime, t.ActualCompletionTime, int(t.Estimate.Minutes()), t.GetId())
+      result, err := dbTxExec(tx, `UPDATE tasks SET name = $1, state = $2, target_start_time = $3, actual_start_time = $4, actual_completion_time = $5, estimate_minutes = $6,
+                           recurrence = $9, due_time = $10, priority = $11, notes = $12, version = version + 1, modified_at = now() 
+                           WHERE ID = $7 AND version = $8`, t.GetName(), t.GetState(), dbUTC(t.TargetStartTime), dbUTC(t.ActualStartTime), dbUTC(t.ActualCompletionTime), int(t.Estimate.Minutes()), t.GetId(), t.GetVersion(),
+                           dbNullString(t.GetRecurrence().String()), dbUTC(t.DueTime), t.GetPriority(), t.GetNotes())
       if (err != nil) {
         err = errors.New(fmt.Sprintf("tdmp.Save(): Unable to update task %s: %s", t.GetName(), err))
         return err
       }
 
+      // no row updated means someone else saved a newer version
+      rows, err := result.RowsAffected()
+      if err == nil && rows == 0 {
+        return &ErrVersionConflict{Id:t.GetId(), Version:t.GetVersion()}
+      }
+      t.SetVersion(t.GetVersion() + 1)
+
       // update today, thisweek and dontforget tags - false means its an update
-      // tm.syncSystemTags(t, false)
-      err = tm.syncTags(t, false)
+      // tm.syncSystemTags(tx, t, false)
+      err = tm.syncTags(tx, t, false)
       if (err != nil) {
         return err;
       }
 
       // update all links - adding or removing to match the in-memory task
-      err = tm.syncLinks(t, true)
+      err = tm.syncLinks(tx, t, true)
       if (err != nil) {
         return err;
       }
 
       // update all users - adding or removing to match the in-memory task
-      err = tm.syncUsers(t)
+      err = tm.syncUsers(tx, t)
+      if (err != nil) {
+        return err;
+      }
+
+      // update all work sessions to match the in-memory task
+      err = tm.syncSessions(tx, t)
       if (err != nil) {
         return err;
       }
 
 
     } else {
-        _, err := dbExec(env, `INSERT INTO tasks (id, name, state, target_start_time, actual_start_time, actual_completion_time, estimate_minutes) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`, 
-                       t.GetId(), t.GetName(), t.GetState(), t.TargetStartTime, t.ActualStartTime, t.ActualCompletionTime, int(t.Estimate.Minutes()))
+        _, err := dbTxExec(tx, `INSERT INTO tasks (id, name, state, target_start_time, actual_start_time, actual_completion_time, estimate_minutes, recurrence, due_time, priority, created_at, notes, version) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, COALESCE($11, now()), $12, 1) RETURNING id`, 
+                       t.GetId(), t.GetName(), t.GetState(), dbUTC(t.TargetStartTime), dbUTC(t.ActualStartTime), dbUTC(t.ActualCompletionTime), int(t.Estimate.Minutes()), dbNullString(t.GetRecurrence().String()), dbUTC(t.DueTime),
+                       t.GetPriority(), t.GetCreatedTime().UTC(), t.GetNotes())
       if (err != nil) { 
         err = errors.New(fmt.Sprintf("tdmp.Save(): Unable to insert task %s: %s", t.GetName(), err))
         return err
       }
+      t.SetVersion(1)
 
       // update all tags - adding or removing to match the in-memory task
-      err = tm.syncTags(t, true)
+      err = tm.syncTags(tx, t, true)
       if (err != nil) {
         return err;
       }
 
       // update all links - adding or removing to match the in-memory task
-      err = tm.syncLinks(t, true)
+      err = tm.syncLinks(tx, t, true)
       if (err != nil) {
         return err;
       }
 
       // update all users - adding or removing to match the in-memory task
-      err = tm.syncUsers(t)
+      err = tm.syncUsers(tx, t)
+      if (err != nil) {
+        return err;
+      }
+
+      // update all work sessions to match the in-memory task
+      err = tm.syncSessions(tx, t)
       if (err != nil) {
         return err;
       }
@@ -779,9 +941,10 @@ func (tm *TaskDataMapperPostgreSQL) Save(t *Task, saveChildren bool, saveMyself
     // first collect the parent ids we already have recorded as saved
     // we'll remove them as we go and anything no longer in the parent
     // list we'll remove at the end
-    savedParentIds := tm.parentIds
+    savedParentIds := append([]string(nil), tm.parentIds...)
 
-    for p := t.FirstParent(); p != nil; p = t.NextParent() {
+    itParents := t.IterParents()
+    for p := itParents.First(); p != nil; p = itParents.Next() {
 
       // for this parent get it's id
       id := p.GetId()
@@ -809,7 +972,7 @@ func (tm *TaskDataMapperPostgreSQL) Save(t *Task, saveChildren bool, saveMyself
           // DB before we call insert - because the insert will fail for data
           // integrity reasons if the parent is not already in the DB
           // assert tmParent.IsInDB()
-          _, err := dbInsert(env, "INSERT INTO task_parents (parent_id, child_id) VALUES ($1, $2)", id, t.GetId())
+          _, err := dbTxInsert(tx, "INSERT INTO task_parents (parent_id, child_id, position) VALUES ($1, $2, $3)", id, t.GetId(), t.Position(p))
           if err != nil { 
             err = errors.New(fmt.Sprintf("tdmp.Save(): Unable to insert parent relationship between parent task %s and child task %s: %s", p.GetName(), t.GetName(), err))
             return err
@@ -822,9 +985,18 @@ func (tm *TaskDataMapperPostgreSQL) Save(t *Task, saveChildren bool, saveMyself
     // once we've looped through all the parents, anything left needs to
     // be removed - it means the parentage that was once saved is no longer there
     for _, idParent := range savedParentIds {
-      _, err := dbExec(env, "DELETE FROM task_parents WHERE parent_id = $1 AND child_id = $2", idParent, t.GetId())
+      _, err := dbTxExec(tx, "DELETE FROM task_parents WHERE parent_id = $1 AND child_id = $2", idParent, t.GetId())
+      if err != nil {
+        err = errors.New(fmt.Sprintf("tdmp.Save(): Unable to remove obsolete parent relationship with parent id %s to child task %s: %s", idParent, t.GetName(), err))
+        return err
+      }
+      tm.RemoveParentId(idParent)
+    }
+
+    // and make sure the order of my siblings under each parent is stored
+    for p := itParents.First(); p != nil; p = itParents.Next() {
+      err := tm.syncPositions(tx, before, p)
       if err != nil {
-        err = errors.New(fmt.Sprintf("tdmp.Save(): Unable to remove obsolete parent relationship with parent id %d to child task %s: %s", idParent, t.GetName(), err))
         return err
       }
     }
@@ -833,10 +1005,15 @@ func (tm *TaskDataMapperPostgreSQL) Save(t *Task, saveChildren bool, saveMyself
   // now recurse if requested
   if saveChildren {
     var err error = nil
-    for c := t.FirstChild(); c != nil && err == nil; c = t.NextChild() {
+    itKids := t.IterChildren()
+    for c := itKids.First(); c != nil && err == nil; c = itKids.Next() {
       // log.Printf("about to save id=%s, name=%s\n", c.Id(), c.Name())
       // err = c.Save(true, true) - could use this to enforce memory-only objects within hierarchy
-      err = c.persist.Save(c, true, true)
+      tmChild, ok := c.persist.(*TaskDataMapperPostgreSQL)
+      if !ok {
+        return errors.New(fmt.Sprintf("tdmp.Save(): child task %s is not mapped to PostgreSQL", c.GetName()))
+      }
+      err = tmChild.save(tx, before, c, true, true)
     }
     if err != nil {
       return err
@@ -846,6 +1023,16 @@ func (tm *TaskDataMapperPostgreSQL) Save(t *Task, saveChildren bool, saveMyself
   return nil
 }
 
+// dbUTC: a time as we store it - our TIMESTAMP columns have no time zone
+// so every time goes in as UTC (and so comes back out as UTC)
+func dbUTC(t *time.Time) *time.Time {
+  if t == nil {
+    return nil
+  }
+  utc := t.UTC()
+  return &utc
+}
+
 func dbTimeCheck(db_time pq.NullTime) *time.Time {
   db_time_value, _ := db_time.Value()
   if db_time_value != nil {
@@ -860,12 +1047,14 @@ func (tm TaskDataMapperPostgreSQL) setTaskFields(t *Task,
                          db_target_start_time      pq.NullTime,
                          db_actual_start_time      pq.NullTime,
                          db_actual_completion_time pq.NullTime,
+                         db_due_time               pq.NullTime,
                          db_estimate_minutes       sql.NullInt64) error {
   // go is terrible dealing with null values from databases
   // must use this intervening struct to track if null or not
   t.SetTargetStartTime(dbTimeCheck(db_target_start_time))
   t.SetActualStartTime(dbTimeCheck(db_actual_start_time))
   t.SetActualCompletionTime(dbTimeCheck(db_actual_completion_time))
+  t.SetDueTime(dbTimeCheck(db_due_time))
 
     /* -- this was the old way - keeping in case I have to debug dbTimeCheck()
   db_value_start_time, _ := db_start_time.Value()
@@ -904,6 +1093,18 @@ func (tm TaskDataMapperPostgreSQL) setTaskFields(t *Task,
   return nil  
 }
 
+// setRecurrence: a rule the DB holds that we can no longer parse is dropped
+func (tm TaskDataMapperPostgreSQL) setRecurrence(t *Task, db_recurrence sql.NullString) {
+  t.SetRecurrence(nil)
+  if db_recurrence.Valid && len(db_recurrence.String) > 0 {
+    r, err := ParseRecurrence(db_recurrence.String)
+    if err != nil {
+      log.Printf("tdmp.Load(): ignoring recurrence of task %s: %s\n", t.GetId(), err)
+    }
+    t.SetRecurrence(r)
+  }
+}
+
 /*
 =============================================================================
  Load()
@@ -941,7 +1142,7 @@ func (tm TaskDataMapperPostgreSQL) setTaskFields(t *Task,
      task.
 ===========================================================================*/
 func (tm TaskDataMapperPostgreSQL) loadAndSetTags(t *Task) error {
-  tagMap, err := tm.loadTaskTags(t)
+  tagMap, err := tm.loadTaskTags(env.db, t)
   if err != nil {
     return err
   }
@@ -952,7 +1153,7 @@ func (tm TaskDataMapperPostgreSQL) loadAndSetTags(t *Task) error {
 }
 
 func (tm TaskDataMapperPostgreSQL) loadAndSetLinks(t *Task) error {
-  linkMap, err := tm.loadTaskLinks(t)
+  linkMap, err := tm.loadTaskLinks(env.db, t)
   if err != nil {
     return err
   }
@@ -963,7 +1164,7 @@ func (tm TaskDataMapperPostgreSQL) loadAndSetLinks(t *Task) error {
 }
 
 func (tm TaskDataMapperPostgreSQL) loadAndSetUsers(t *Task) error {
-  us, err := tm.loadTaskUsers(t)
+  us, err := tm.loadTaskUsers(env.db, t)
   if err != nil {
     return err
   }
@@ -973,16 +1174,31 @@ func (tm TaskDataMapperPostgreSQL) loadAndSetUsers(t *Task) error {
   return nil
 }
 
+func (tm TaskDataMapperPostgreSQL) loadAndSetSessions(t *Task) error {
+  sessions, err := tm.loadTaskSessions(env.db, t)
+  if err != nil {
+    return err
+  }
+  t.SetSessions(sessions)
+  return nil
+}
+
 
 func (tm TaskDataMapperPostgreSQL) Load(t *Task, loadChildren bool, root bool) error {
 
   var (
     name string
     state TaskState
+    version int
+    priority int
+    notes string
     db_target_start_time pq.NullTime
     db_actual_start_time pq.NullTime
     db_actual_completion_time pq.NullTime
+    db_due_time pq.NullTime
     db_estimate_minutes sql.NullInt64
+    db_recurrence sql.NullString
+    db_created_at pq.NullTime
   )
 
   // if we're told this is "root" that means we should not attempt
@@ -990,11 +1206,11 @@ func (tm TaskDataMapperPostgreSQL) Load(t *Task, loadChildren bool, root bool) e
   if (!root) {
 
     // build and execute the query for the task
-    taskQuery := `SELECT t.name, t.state, t.target_start_time, t.actual_start_time, t.actual_completion_time, t.estimate_minutes 
+    taskQuery := `SELECT t.name, t.state, t.target_start_time, t.actual_start_time, t.actual_completion_time, t.due_time, t.estimate_minutes, t.version, t.recurrence, t.priority, t.created_at, t.notes 
                   FROM tasks t
                   WHERE id = '" + t.GetId() + "'" + "
                   GROUP BY t.id`
-    err := env.db.QueryRow(taskQuery).Scan(&name, &state, &db_target_start_time, &db_actual_start_time, &db_actual_completion_time, &db_estimate_minutes)
+    err := env.db.QueryRow(taskQuery).Scan(&name, &state, &db_target_start_time, &db_actual_start_time, &db_actual_completion_time, &db_due_time, &db_estimate_minutes, &version, &db_recurrence, &priority, &db_created_at, &notes)
     if err != nil {
       // log.Printf("query for a task failed: %s, err: %s\n", taskQuery, err)
       return err
@@ -1003,7 +1219,12 @@ func (tm TaskDataMapperPostgreSQL) Load(t *Task, loadChildren bool, root bool) e
     // overwrite my in-memory values
     t.SetName(name)
     t.SetState(state)
-    tm.setTaskFields(t, db_target_start_time, db_actual_start_time, db_actual_completion_time, db_estimate_minutes)
+    t.SetVersion(version)
+    t.SetPriority(priority)
+    t.SetNotes(notes)
+    tm.setTaskFields(t, db_target_start_time, db_actual_start_time, db_actual_completion_time, db_due_time, db_estimate_minutes)
+    tm.setRecurrence(t, db_recurrence)
+    t.SetCreatedTime(dbTimeCheck(db_created_at))
 
     // now go get and set the tags
     err = tm.loadAndSetTags(t)
@@ -1017,6 +1238,12 @@ func (tm TaskDataMapperPostgreSQL) Load(t *Task, loadChildren bool, root bool) e
       return err
     }
 
+    // and the time spent on the task
+    err = tm.loadAndSetSessions(t)
+    if err != nil {
+      return err
+    }
+
     // set myself as loaded from the DB
     tm.loaded = true
   }
@@ -1025,14 +1252,14 @@ func (tm TaskDataMapperPostgreSQL) Load(t *Task, loadChildren bool, root bool) e
   // passing along "root=true" will tell loadChildren to attach all tasks in
   // the DB whose parents are NULL (top-level tasks) to me
   if loadChildren {
-    return tm.loadChildren(t, root)
+    return tm.loadChildren(t, root, make(map[string]*Task))
   } else {
     return nil
   }
 }
 
 
-func (tm TaskDataMapperPostgreSQL) loadChildren(parent *Task, root bool) error {
+func (tm TaskDataMapperPostgreSQL) loadChildren(parent *Task, root bool, loaded map[string]*Task) error {
   // log.Printf("LoadChildren(): for parent task %s\n", parent.name)
   var (
     dbid string
@@ -1041,23 +1268,29 @@ func (tm TaskDataMapperPostgreSQL) loadChildren(parent *Task, root bool) error {
     db_target_start_time pq.NullTime
     db_actual_start_time pq.NullTime
     db_actual_completion_time pq.NullTime
+    db_due_time pq.NullTime
     db_estimate_minutes sql.NullInt64
+    dbversion int
+    dbpriority int
+    dbnotes string
+    db_recurrence sql.NullString
+    db_created_at pq.NullTime
   )
 
   // note that we are limiting to 1000 records - we need a smarter lazy loading technique here
-  var baseQuery string = `SELECT t.id, t.name, t.state, t.target_start_time, t.actual_start_time, t.actual_completion_time, t.estimate_minutes
+  var baseQuery string = `SELECT t.id, t.name, t.state, t.target_start_time, t.actual_start_time, t.actual_completion_time, t.due_time, t.estimate_minutes, t.version, t.recurrence, t.priority, t.created_at, t.notes
                           FROM tasks t
                         LEFT JOIN task_parents tp ON tp.child_id = t.id
                         WHERE tp.parent_id %s
                         GROUP BY t.id
-                        ORDER BY t.actual_completion_time DESC LIMIT 1000`
+                        ORDER BY %s, t.actual_completion_time DESC LIMIT 1000`
 
     // if no parent on this guy then we're at the top - load root tasks
     var sqlSelect string
     if root {
-      sqlSelect = fmt.Sprintf(baseQuery, "IS NULL")
+      sqlSelect = fmt.Sprintf(baseQuery, "IS NULL", "t.position")
   } else {
-    sqlSelect = fmt.Sprintf(baseQuery, fmt.Sprintf("= '%s'", parent.GetId()))
+    sqlSelect = fmt.Sprintf(baseQuery, fmt.Sprintf("= '%s'", parent.GetId()), "MIN(tp.position)")
   }
 
   // make the query for the kids
@@ -1070,16 +1303,27 @@ func (tm TaskDataMapperPostgreSQL) loadChildren(parent *Task, root bool) error {
 
   // for each child task in the DB
   for rows.Next() {
-    err := rows.Scan(&dbid, &dbname, &dbstate, &db_target_start_time, &db_actual_start_time, &db_actual_completion_time, &db_estimate_minutes)
+    err := rows.Scan(&dbid, &dbname, &dbstate, &db_target_start_time, &db_actual_start_time, &db_actual_completion_time, &db_due_time, &db_estimate_minutes, &dbversion, &db_recurrence, &dbpriority, &db_created_at, &dbnotes)
     if err != nil {
       log.Printf("tmpg.loadChildren(): row scan failed\n")
       log.Fatal(err)
     }
     // log.Printf("LoadChildren(): read id=%s, name=%s\n", id, name)
 
+    // a task with many parents is only loaded the first time we reach
+    // it - after that we just link it to each additional parent
+    if k, found := loaded[dbid]; found {
+      parent.AddChild(k)
+      k.persist.(*TaskDataMapperPostgreSQL).AddParentId(parent.GetId())
+      continue
+    }
+
     // create the child task
-    k := &Task{id:dbid, name:dbname, state:dbstate}
-    tm.setTaskFields(k, db_target_start_time, db_actual_start_time, db_actual_completion_time, db_estimate_minutes)
+    k := &Task{id:dbid, name:dbname, state:dbstate, version:dbversion, priority:dbpriority, notes:dbnotes}
+    loaded[dbid] = k
+    tm.setTaskFields(k, db_target_start_time, db_actual_start_time, db_actual_completion_time, db_due_time, db_estimate_minutes)
+    tm.setRecurrence(k, db_recurrence)
+    k.SetCreatedTime(dbTimeCheck(db_created_at))
 
     // load and set the tags
     err = tm.loadAndSetTags(k)
@@ -1099,6 +1343,12 @@ func (tm TaskDataMapperPostgreSQL) loadChildren(parent *Task, root bool) error {
       return err
     }    
 
+    // and the time spent on the task
+    err = tm.loadAndSetSessions(k)
+    if err != nil {
+      return err
+    }
+
     // set the data mapper onto the child indicating that it was loaded from DB
     // TBD - this should copy the mapper from tm - shouldn't it??? I think DB_NAME will be
     // wrong if we don't use the default database name
@@ -1114,16 +1364,31 @@ func (tm TaskDataMapperPostgreSQL) loadChildren(parent *Task, root bool) error {
     kdm.AddParentId(parent.GetId())
 
     // now that the child is fully loaded, recurse to go get it's children
-    err = kdm.loadChildren(k, false)
+    err = kdm.loadChildren(k, false, loaded)
   }
   err = rows.Err()
   if err != nil {
     log.Fatal(err)
+  }
+
+  // remember the order we loaded the children in so saves know what changed
+  if tmParent, ok := parent.DataMapper().(*TaskDataMapperPostgreSQL); ok {
+    tmParent.childIds = parent.GetChildIds()
   } 
   return nil
 
 }
 
+/*
+==================================================================================
+ Delete()
+----------------------------------------------------------------------------------
+ Inputs: t        *Task - the in-memory task to delete from the database
+         reparent *Task - if not nil, children of t move to this parent
+
+ Remove the task and all its relationships from the database.  All the
+ statements run in a single transaction so a failure leaves the task as it was.
+================================================================================*/
 func (tm *TaskDataMapperPostgreSQL) Delete(t *Task, reparent *Task) error {
 
   // if the task has never been saved then no work to here
@@ -1131,6 +1396,23 @@ func (tm *TaskDataMapperPostgreSQL) Delete(t *Task, reparent *Task) error {
     return nil
   }
 
+  err := dbTransact(env, func(tx *sql.Tx) error {
+    return tm.delete(tx, t, reparent)
+  })
+  if err != nil {
+    return err
+  }
+
+  // clean in-memory tm structures
+  tm.loaded = false
+  tm.parentIds = nil
+
+  return nil
+}
+
+// delete: does the work of Delete() within the transaction provided
+func (tm *TaskDataMapperPostgreSQL) delete(tx *sql.Tx, t *Task, reparent *Task) error {
+
   // if a reparenting is requested - update all tasks to have the new parent
   bReparent := false
   if reparent != nil {
@@ -1143,62 +1425,65 @@ func (tm *TaskDataMapperPostgreSQL) Delete(t *Task, reparent *Task) error {
   if bReparent {
     // if reparenting is requested and that parent is in the DB already
     // then reparent this task to the requested new parent
-    _, err := dbExec(env, "UPDATE task_parents SET parent_id = $1 WHERE parent_id = $2", reparent.GetId(), t.GetId())
+    _, err := dbTxExec(tx, "UPDATE task_parents SET parent_id = $1 WHERE parent_id = $2", reparent.GetId(), t.GetId())
     if err != nil {
-      err = errors.New(fmt.Sprintf("tdmp.Delete(): Unable to set new parent on children of task %s from id %d to id %d: %s", t.GetName(), t.GetId(), reparent.GetId(), err))
+      err = errors.New(fmt.Sprintf("tdmp.Delete(): Unable to set new parent on children of task %s from id %s to id %s: %s", t.GetName(), t.GetId(), reparent.GetId(), err))
       return err
     }
   } else {
 
     // delete all references to this task from task_parents table
-    _, err := dbExec(env, "DELETE FROM task_parents WHERE parent_id = $1", t.GetId())
+    _, err := dbTxExec(tx, "DELETE FROM task_parents WHERE parent_id = $1", t.GetId())
     if err != nil {
-      err = errors.New(fmt.Sprintf("tdmp.Delete(): Unable to delete parent references to task %s with id %d: %s", t.GetName(), t.GetId(), err))
+      err = errors.New(fmt.Sprintf("tdmp.Delete(): Unable to delete parent references to task %s with id %s: %s", t.GetName(), t.GetId(), err))
       return err
     }
 
   }
 
   // remove myself as a child from any parent tasks - no re-childing necessary
-  _, err := dbExec(env, "DELETE FROM task_parents WHERE child_id = $1", t.GetId())
+  _, err := dbTxExec(tx, "DELETE FROM task_parents WHERE child_id = $1", t.GetId())
   if err != nil {
     err = errors.New(fmt.Sprintf("tdmp.Delete(): Unable to delete child references to task %s with id %s: %s", t.GetName(), t.GetId(), err))
     return err
   }
 
   // delete all tag references from the task_tags table
-  _, err = dbExec(env, "DELETE FROM task_tags WHERE task_id = $1", t.GetId())
+  _, err = dbTxExec(tx, "DELETE FROM task_tags WHERE task_id = $1", t.GetId())
   if err != nil { // we should make sure this doesn't return an error if no tags are on the task - if it does we should eat that error
     err = errors.New(fmt.Sprintf("tdmp.Delete(): Unable to remove tags from task %s with id %s: %s", t.GetName(), t.GetId(), err))
     return err
   }
 
   // delete all link references from the task_links table
-  _, err = dbExec(env, "DELETE FROM task_links WHERE task_id = $1", t.GetId())
+  _, err = dbTxExec(tx, "DELETE FROM task_links WHERE task_id = $1", t.GetId())
   if err != nil { // we should make sure this doesn't return an error if no links are on the task - if it does we should eat that error
     err = errors.New(fmt.Sprintf("tdmp.Delete(): Unable to remove links from task %s with id %s: %s", t.GetName(), t.GetId(), err))
     return err
   }
 
   // delete all user references from the task_users table
-  _, err = dbExec(env, "DELETE FROM task_users WHERE task_id = $1", t.GetId())
+  _, err = dbTxExec(tx, "DELETE FROM task_users WHERE task_id = $1", t.GetId())
   if err != nil { // we should make sure this doesn't return an error if no users are on the task - if it does we should eat that error
     err = errors.New(fmt.Sprintf("tdmp.Delete(): Unable to remove users from task %s with id %s: %s", t.GetName(), t.GetId(), err))
     return err
   }  
 
+  // delete the time spent on the task from the task_sessions table
+  _, err = dbTxExec(tx, "DELETE FROM task_sessions WHERE task_id = $1", t.GetId())
+  if err != nil {
+    err = errors.New(fmt.Sprintf("tdmp.Delete(): Unable to remove work sessions from task %s with id %s: %s", t.GetName(), t.GetId(), err))
+    return err
+  }
+
   // delete this task from the tasks table - must do this after deleting from
   // parent table
-  _, err = dbExec(env, "DELETE FROM tasks WHERE id = $1", t.GetId())
+  _, err = dbTxExec(tx, "DELETE FROM tasks WHERE id = $1", t.GetId())
   if err != nil {
     err = errors.New(fmt.Sprintf("tdmp.Delete(): Unable to remove task <%s> with id %s: %s", t.GetName(), t.GetId(), err))
     return err
   }
 
-  // clean in-memory tm structures
-  tm.loaded = false
-  tm.parentIds = nil
-
   return nil
 }
 
@@ -1219,12 +1504,22 @@ func (tm *TaskDataMapperPostgreSQL) Delete(t *Task, reparent *Task) error {
 
  TBD: Implement some kind of dirty flag to minimize unneeded UPDATES.
 ===========================================================================*/
+// dbCapacity: a user's capacity as stored - NULL for the default capacity
+func dbCapacity(u *User) pq.Int64Array {
+  var minutes pq.Int64Array
+  for _, m := range u.GetCapacityMinutes() {
+    minutes = append(minutes, int64(m))
+  }
+  return minutes
+}
+
 func (tm *TaskDataMapperPostgreSQL) UserSave(u *User) error {
   log.Printf("UserSave(%v)\n",u)
 
   if tm.loaded {
-    _, err := dbExec(env, `UPDATE users SET name = $1, email = $2, password = $3 
-                         WHERE ID = $4`, u.GetName(), u.GetEmail(), u.GetPassword(), u.GetId())
+    _, err := dbExec(env, `UPDATE users SET name = $1, email = $2, password = $3, capacity_minutes = $5,
+                         time_zone = $6, week_start = $7 WHERE ID = $4`, u.GetName(), u.GetEmail(), u.GetPassword(), u.GetId(),
+                         dbCapacity(u), dbNullString(u.GetTimeZone()), int(u.GetWeekStart()))
     if err != nil {
       errStr := fmt.Sprintf("tdmp.UserSave(): Unable to update user %s: %s\n", u.GetEmail(), err)
       log.Printf(errStr)
@@ -1233,8 +1528,9 @@ func (tm *TaskDataMapperPostgreSQL) UserSave(u *User) error {
     }
 
   } else {
-      _, err := dbExec(env, `INSERT INTO users (id, name, email, password) VALUES ($1, $2, $3, $4) RETURNING id`, 
-                     u.GetId(), u.GetName(), u.GetEmail(), u.GetPassword())
+      _, err := dbExec(env, `INSERT INTO users (id, name, email, password, capacity_minutes, time_zone, week_start)
+                     VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`, 
+                     u.GetId(), u.GetName(), u.GetEmail(), u.GetPassword(), dbCapacity(u), dbNullString(u.GetTimeZone()), int(u.GetWeekStart()))
     if err != nil {
       errStr := fmt.Sprintf("tdmp.UserSave(): Unable to insert task %s: %s\n", u.GetEmail(), err)
       log.Printf(errStr)
@@ -1309,7 +1605,7 @@ func (tm *TaskDataMapperPostgreSQL) UserDelete(u *User) error {
   // delete this user
   _, err := dbExec(env, "DELETE FROM users WHERE id = $1", u.GetId())
   if err != nil {
-    err = errors.New(fmt.Sprintf("tdmp.Delete(): Unable to remove user %s with id %d: %s", u.GetEmail(), u.GetId(), err))
+    err = errors.New(fmt.Sprintf("tdmp.Delete(): Unable to remove user %s with id %s: %s", u.GetEmail(), u.GetId(), err))
     return err
   }
 
@@ -1337,9 +1633,12 @@ func (tm *TaskDataMapperPostgreSQL) UserLoadAll() (Users, error) {
     dbname string
     dbemail string
     dbpassword string
+    dbcapacity pq.Int64Array
+    dbtimezone sql.NullString
+    dbweekstart int
   )
 
-  var sqlSelect string = `SELECT u.id, u.name, u.email, u.password FROM users u`
+  var sqlSelect string = `SELECT u.id, u.name, u.email, u.password, u.capacity_minutes, u.time_zone, u.week_start FROM users u`
   rows, err := env.db.Query(sqlSelect)
   if err != nil {
     log.Printf("query for users failed: %s\n", sqlSelect)
@@ -1352,7 +1651,8 @@ func (tm *TaskDataMapperPostgreSQL) UserLoadAll() (Users, error) {
 
   // for each user in our database of users
   for rows.Next() {
-    err := rows.Scan(&dbid, &dbname, &dbemail, &dbpassword)
+    dbcapacity = nil
+    err := rows.Scan(&dbid, &dbname, &dbemail, &dbpassword, &dbcapacity, &dbtimezone, &dbweekstart)
     if err != nil {
       log.Printf("tmpg.UserLoadAll(): row scan failed\n")
       log.Fatal(err)
@@ -1366,6 +1666,21 @@ func (tm *TaskDataMapperPostgreSQL) UserLoadAll() (Users, error) {
       log.Printf("tmpg.UserLoadAll(): user creation failed\n")
       log.Fatal(pimError(errid))
     }
+    if dbcapacity != nil {
+      minutes := make([]int, len(dbcapacity))
+      for i, m := range dbcapacity {
+        minutes[i] = int(m)
+      }
+      if err := u.SetCapacityMinutes(minutes); err != nil {
+        log.Printf("tmpg.UserLoadAll(): ignoring capacity of %s: %s\n", dbemail, err)
+      }
+    }
+    if err := u.SetTimeZone(dbtimezone.String); err != nil {
+      log.Printf("tmpg.UserLoadAll(): ignoring time zone of %s: %s\n", dbemail, err)
+    }
+    if err := u.SetWeekStart(time.Weekday(dbweekstart)); err != nil {
+      log.Printf("tmpg.UserLoadAll(): ignoring week start of %s: %s\n", dbemail, err)
+    }
     us = append(us, u)
   }
 
@@ -1375,3 +1690,440 @@ func (tm *TaskDataMapperPostgreSQL) UserLoadAll() (Users, error) {
   } 
   return us, nil
 }
+
+/*
+=============================================================================
+ JournalSave()
+-----------------------------------------------------------------------------
+ Inputs:  JournalEntry e - command journal entry to save
+ Returns: error          - DB call could fail - likely cause is bad DB
+
+ Upsert a command journal entry.  New entries (no id yet) are inserted and
+ given the id assigned by the database.  Existing entries can only change
+ whether or not they have been undone.
+===========================================================================*/
+func (tm *TaskDataMapperPostgreSQL) JournalSave(e *JournalEntry) error {
+  if e.Id == 0 {
+    id, err := dbInsert(env, `INSERT INTO command_journal (user_id, record, undone, created_at) VALUES ($1, $2, $3, $4) RETURNING id`,
+                        e.UserId, e.Record, e.Undone, e.CreatedAt)
+    if err != nil {
+      return errors.New(fmt.Sprintf("tdmp.JournalSave(): Unable to insert journal entry for user %s: %s", e.UserId, err))
+    }
+    e.Id = id
+  } else {
+    _, err := dbExec(env, `UPDATE command_journal SET undone = $1 WHERE id = $2`, e.Undone, e.Id)
+    if err != nil {
+      return errors.New(fmt.Sprintf("tdmp.JournalSave(): Unable to update journal entry %d: %s", e.Id, err))
+    }
+  }
+  return nil
+}
+
+/*
+=============================================================================
+ JournalLoad()
+-----------------------------------------------------------------------------
+ Inputs:  User u          - user whose command journal we want
+ Returns: []*JournalEntry - entries in the order they were created
+          error           - DB call could fail - likely cause is bad DB
+===========================================================================*/
+func (tm *TaskDataMapperPostgreSQL) JournalLoad(u *User) ([]*JournalEntry, error) {
+  rows, err := env.db.Query(`SELECT id, user_id, record, undone, created_at FROM command_journal WHERE user_id = $1 ORDER BY id`, u.GetId())
+  if err != nil {
+    log.Printf("query for the command journal failed: %s\n", err)
+    return nil, err
+  }
+  defer rows.Close()
+
+  var entries []*JournalEntry
+  for rows.Next() {
+    e := new(JournalEntry)
+    err := rows.Scan(&e.Id, &e.UserId, &e.Record, &e.Undone, &e.CreatedAt)
+    if err != nil {
+      log.Printf("tmpg.JournalLoad(): row scan failed\n")
+      return nil, err
+    }
+    entries = append(entries, e)
+  }
+  return entries, rows.Err()
+}
+
+func (tm *TaskDataMapperPostgreSQL) JournalDelete(e *JournalEntry) error {
+  _, err := dbExec(env, `DELETE FROM command_journal WHERE id = $1`, e.Id)
+  if err != nil {
+    return errors.New(fmt.Sprintf("tdmp.JournalDelete(): Unable to delete journal entry %d: %s", e.Id, err))
+  }
+  return nil
+}
+
+func (tm *TaskDataMapperPostgreSQL) JournalPrune(before time.Time) error {
+  _, err := dbExec(env, `DELETE FROM command_journal WHERE created_at < $1`, before)
+  if err != nil {
+    return errors.New(fmt.Sprintf("tdmp.JournalPrune(): Unable to prune command journal: %s", err))
+  }
+  return nil
+}
+
+/*
+=============================================================================
+ CommentSave()
+-----------------------------------------------------------------------------
+ Inputs:  TaskComment c - comment to save
+ Returns: error         - DB call could fail - likely cause is bad DB
+
+ Upsert a comment on a task.  New comments (no id yet) are inserted and
+ given the id assigned by the database.  Existing comments can only have
+ their text edited.
+===========================================================================*/
+func (tm *TaskDataMapperPostgreSQL) CommentSave(c *TaskComment) error {
+  if c.Id == 0 {
+    id, err := dbInsert(env, `INSERT INTO task_comments (task_id, user_id, text, created_at) VALUES ($1, $2, $3, $4) RETURNING id`,
+                        c.TaskId, c.UserId, c.Text, c.CreatedAt)
+    if err != nil {
+      return errors.New(fmt.Sprintf("tdmp.CommentSave(): Unable to insert comment on task %s: %s", c.TaskId, err))
+    }
+    c.Id = id
+  } else {
+    _, err := dbExec(env, `UPDATE task_comments SET text = $1, edited_at = $2 WHERE id = $3`, c.Text, c.EditedAt, c.Id)
+    if err != nil {
+      return errors.New(fmt.Sprintf("tdmp.CommentSave(): Unable to update comment %d: %s", c.Id, err))
+    }
+  }
+  return nil
+}
+
+func (tm *TaskDataMapperPostgreSQL) CommentLoad(t *Task) ([]*TaskComment, error) {
+  rows, err := env.db.Query(`SELECT id, task_id, user_id, text, created_at, edited_at FROM task_comments WHERE task_id = $1 ORDER BY id`, t.GetId())
+  if err != nil {
+    return nil, errors.New(fmt.Sprintf("tdmp.CommentLoad(): Unable to load comments on task %s: %s", t.GetName(), err))
+  }
+  defer rows.Close()
+
+  var comments []*TaskComment
+  for rows.Next() {
+    c := new(TaskComment)
+    var edited pq.NullTime
+    err := rows.Scan(&c.Id, &c.TaskId, &c.UserId, &c.Text, &c.CreatedAt, &edited)
+    if err != nil {
+      return nil, errors.New(fmt.Sprintf("tdmp.CommentLoad(): Unable to read comment on task %s: %s", t.GetName(), err))
+    }
+    c.EditedAt = dbTimeCheck(edited)
+    comments = append(comments, c)
+  }
+  return comments, rows.Err()
+}
+
+func (tm *TaskDataMapperPostgreSQL) ActivitySave(a *TaskActivity) error {
+  id, err := dbInsert(env, `INSERT INTO task_activity (task_id, user_id, action, log, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+                      a.TaskId, a.UserId, a.Action, a.Log, a.CreatedAt)
+  if err != nil {
+    return errors.New(fmt.Sprintf("tdmp.ActivitySave(): Unable to insert activity on task %s: %s", a.TaskId, err))
+  }
+  a.Id = id
+  return nil
+}
+
+func (tm *TaskDataMapperPostgreSQL) ActivityLoad(t *Task) ([]*TaskActivity, error) {
+  rows, err := env.db.Query(`SELECT id, task_id, user_id, action, log, created_at FROM task_activity WHERE task_id = $1 ORDER BY id`, t.GetId())
+  if err != nil {
+    return nil, errors.New(fmt.Sprintf("tdmp.ActivityLoad(): Unable to load activity of task %s: %s", t.GetName(), err))
+  }
+  defer rows.Close()
+
+  var entries []*TaskActivity
+  for rows.Next() {
+    a := new(TaskActivity)
+    err := rows.Scan(&a.Id, &a.TaskId, &a.UserId, &a.Action, &a.Log, &a.CreatedAt)
+    if err != nil {
+      return nil, errors.New(fmt.Sprintf("tdmp.ActivityLoad(): Unable to read activity of task %s: %s", t.GetName(), err))
+    }
+    entries = append(entries, a)
+  }
+  return entries, rows.Err()
+}
+
+/*
+=============================================================================
+ SessionSave() / SessionLoad() / SessionDelete() / SessionPrune()
+-----------------------------------------------------------------------------
+ Sessions of signed in users and password resets (see session.go).  A session is never changed
+ once it is saved - refreshing one replaces it with a new one.
+===========================================================================*/
+func (tm *TaskDataMapperPostgreSQL) SessionSave(s *UserSession) error {
+  _, err := dbExec(env, `INSERT INTO user_sessions (id, user_id, kind, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+                   s.Id, s.UserId, s.Kind, s.CreatedAt, s.ExpiresAt)
+  if err != nil {
+    return errors.New(fmt.Sprintf("tdmp.SessionSave(): Unable to insert session for user %s: %s", s.UserId, err))
+  }
+  return nil
+}
+
+func (tm *TaskDataMapperPostgreSQL) SessionLoad(id string) (*UserSession, error) {
+  s := new(UserSession)
+  err := env.db.QueryRow(`SELECT id, user_id, kind, created_at, expires_at FROM user_sessions WHERE id = $1`, id).
+                Scan(&s.Id, &s.UserId, &s.Kind, &s.CreatedAt, &s.ExpiresAt)
+  if err == sql.ErrNoRows {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, errors.New(fmt.Sprintf("tdmp.SessionLoad(): Unable to load session: %s", err))
+  }
+  return s, nil
+}
+
+func (tm *TaskDataMapperPostgreSQL) SessionDelete(s *UserSession) error {
+  _, err := dbExec(env, `DELETE FROM user_sessions WHERE id = $1`, s.Id)
+  if err != nil {
+    return errors.New(fmt.Sprintf("tdmp.SessionDelete(): Unable to delete session of user %s: %s", s.UserId, err))
+  }
+  return nil
+}
+
+func (tm *TaskDataMapperPostgreSQL) SessionDeleteAll(u *User) error {
+  _, err := dbExec(env, `DELETE FROM user_sessions WHERE user_id = $1`, u.GetId())
+  if err != nil {
+    return errors.New(fmt.Sprintf("tdmp.SessionDeleteAll(): Unable to delete sessions of user %s: %s", u.GetEmail(), err))
+  }
+  return nil
+}
+
+func (tm *TaskDataMapperPostgreSQL) SessionPrune(before time.Time) error {
+  _, err := dbExec(env, `DELETE FROM user_sessions WHERE expires_at < $1`, before)
+  if err != nil {
+    return errors.New(fmt.Sprintf("tdmp.SessionPrune(): Unable to prune sessions: %s", err))
+  }
+  return nil
+}
+
+/*
+=============================================================================
+ LoginSave()
+-----------------------------------------------------------------------------
+ Inputs:  UserLogin l - sign-in attempt to record
+ Returns: error       - DB call could fail - likely cause is bad DB
+
+ Attempts on emails that aren't ours are recorded without a user.
+===========================================================================*/
+func (tm *TaskDataMapperPostgreSQL) LoginSave(l *UserLogin) error {
+  var userId, ip interface{}
+  if l.UserId != "" {
+    userId = l.UserId
+  }
+  if net.ParseIP(l.IPAddress) != nil {
+    ip = l.IPAddress
+  }
+  _, err := dbExec(env, `INSERT INTO user_logins (user_id, email, ip_address, succeeded, created_at) VALUES ($1, $2, $3, $4, $5)`,
+                   userId, l.Email, ip, l.Succeeded, l.CreatedAt)
+  if err != nil {
+    return errors.New(fmt.Sprintf("tdmp.LoginSave(): Unable to record sign-in of %s: %s", l.Email, err))
+  }
+  return nil
+}
+
+/*
+=============================================================================
+ TokenSave() / TokenLoad() / TokenLoadAll() / TokenDelete()
+-----------------------------------------------------------------------------
+ Personal access tokens (see apitokens.go).  Only the time a token was last
+ used ever changes once it is saved.
+===========================================================================*/
+func (tm *TaskDataMapperPostgreSQL) TokenSave(k *UserToken) error {
+  _, err := dbExec(env, `INSERT INTO user_tokens (id, user_id, name, token_hash, scopes, created_at, last_used_at)
+                         VALUES ($1, $2, $3, $4, $5, $6, $7)
+                         ON CONFLICT (id) DO UPDATE SET last_used_at = EXCLUDED.last_used_at`,
+                   k.Id, k.UserId, k.Name, k.Hash, pq.Array(k.Scopes), k.CreatedAt, k.LastUsedAt)
+  if err != nil {
+    return errors.New(fmt.Sprintf("tdmp.TokenSave(): Unable to save token %s of user %s: %s", k.Id, k.UserId, err))
+  }
+  return nil
+}
+
+// scanToken: a token from a row of tokenColumns
+const tokenColumns = `id, user_id, name, token_hash, scopes, created_at, last_used_at`
+
+func scanToken(row interface{ Scan(...interface{}) error }) (*UserToken, error) {
+  k := new(UserToken)
+  var scopes pq.StringArray
+  var db_last_used pq.NullTime
+  if err := row.Scan(&k.Id, &k.UserId, &k.Name, &k.Hash, &scopes, &k.CreatedAt, &db_last_used); err != nil {
+    return nil, err
+  }
+  k.Scopes = []string(scopes)
+  k.LastUsedAt = dbTimeCheck(db_last_used)
+  return k, nil
+}
+
+func (tm *TaskDataMapperPostgreSQL) TokenLoad(hash string) (*UserToken, error) {
+  k, err := scanToken(env.db.QueryRow(`SELECT `+tokenColumns+` FROM user_tokens WHERE token_hash = $1`, hash))
+  if err == sql.ErrNoRows {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, errors.New(fmt.Sprintf("tdmp.TokenLoad(): Unable to load token: %s", err))
+  }
+  return k, nil
+}
+
+func (tm *TaskDataMapperPostgreSQL) TokenLoadAll(u *User) ([]*UserToken, error) {
+  rows, err := env.db.Query(`SELECT `+tokenColumns+` FROM user_tokens WHERE user_id = $1 ORDER BY created_at`, u.GetId())
+  if err != nil {
+    return nil, errors.New(fmt.Sprintf("tdmp.TokenLoadAll(): Unable to load tokens of user %s: %s", u.GetEmail(), err))
+  }
+  defer rows.Close()
+  var tokens []*UserToken
+  for rows.Next() {
+    k, err := scanToken(rows)
+    if err != nil {
+      return nil, errors.New(fmt.Sprintf("tdmp.TokenLoadAll(): Unable to read token of user %s: %s", u.GetEmail(), err))
+    }
+    tokens = append(tokens, k)
+  }
+  return tokens, rows.Err()
+}
+
+func (tm *TaskDataMapperPostgreSQL) TokenDelete(k *UserToken) error {
+  _, err := dbExec(env, `DELETE FROM user_tokens WHERE id = $1`, k.Id)
+  if err != nil {
+    return errors.New(fmt.Sprintf("tdmp.TokenDelete(): Unable to delete token %s of user %s: %s", k.Id, k.UserId, err))
+  }
+  return nil
+}
+
+/*
+=============================================================================
+ TaskQuery()
+-----------------------------------------------------------------------------
+ Inputs:  TaskQuery q - parsed query to run (see query.go)
+          User u      - only this user's tasks are searched
+ Returns: []string    - ids of the user's tasks that match
+          error       - DB call could fail - likely cause is bad DB
+
+ Runs the query in the database rather than over the tasks in memory by
+ translating it into a WHERE clause (see querySQL()).
+===========================================================================*/
+func (tm *TaskDataMapperPostgreSQL) TaskQuery(q *TaskQuery, u *User) ([]string, error) {
+  args := []interface{}{u.GetId()}
+  where, err := querySQL(q.Root, &args)
+  if err != nil {
+    return nil, err
+  }
+  rows, err := env.db.Query(`SELECT t.id FROM tasks t
+                             WHERE t.id IN (SELECT task_id FROM task_users WHERE user_id = $1) AND ` + where, args...)
+  if err != nil {
+    return nil, errors.New(fmt.Sprintf("tdmp.TaskQuery(): query for tasks failed: %s", err))
+  }
+  defer rows.Close()
+
+  var ids []string
+  for rows.Next() {
+    var id string
+    if err := rows.Scan(&id); err != nil {
+      return nil, errors.New(fmt.Sprintf("tdmp.TaskQuery(): row scan failed: %s", err))
+    }
+    ids = append(ids, id)
+  }
+  return ids, rows.Err()
+}
+
+// the columns of the times a query can compare against
+var queryTimeColumns = map[string]string{
+  "due": "t.due_time",
+  "target": "t.target_start_time",
+  "started": "t.actual_start_time",
+  "completed": "t.actual_completion_time",
+  "created": "t.created_at",
+}
+
+// escape the wildcards of LIKE so text is matched as it is
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// querySQL: translate a query node into a condition on tasks t, adding the
+// values it compares against to args.  Conditions never evaluate to NULL
+// so that NOT gives the same answers as it does in memory.
+func querySQL(n QueryNode, args *[]interface{}) (string, error) {
+  arg := func(v interface{}) string {
+    *args = append(*args, v)
+    return fmt.Sprintf("$%d", len(*args))
+  }
+  join := func(nodes []QueryNode, op string, empty string) (string, error) {
+    if len(nodes) == 0 {
+      return empty, nil
+    }
+    var parts []string
+    for _, node := range nodes {
+      part, err := querySQL(node, args)
+      if err != nil {
+        return "", err
+      }
+      parts = append(parts, part)
+    }
+    return "(" + strings.Join(parts, " " + op + " ") + ")", nil
+  }
+
+  switch node := n.(type) {
+  case *queryAnd:
+    return join(node.nodes, "AND", "TRUE")
+  case *queryOr:
+    return join(node.nodes, "OR", "FALSE")
+  case *queryNot:
+    inner, err := querySQL(node.node, args)
+    if err != nil {
+      return "", err
+    }
+    return "(NOT " + inner + ")", nil
+  case *queryTag:
+    return `EXISTS (SELECT 1 FROM task_tags tt JOIN tags g ON g.id = tt.tag_id
+                    WHERE tt.task_id = t.id AND g.name = ` + arg(node.tag) + `)`, nil
+  case *queryState:
+    return "(t.state = " + arg(int(node.state)) + ")", nil
+  case *queryTime:
+    column := queryTimeColumns[node.field]
+    sql := "(" + column + " IS NOT NULL"
+    if node.from != nil {
+      sql += " AND " + column + " >= " + arg(node.from.UTC())
+    }
+    if node.to != nil {
+      sql += " AND " + column + " < " + arg(node.to.UTC())
+    }
+    return sql + ")", nil
+  case *queryEstimate:
+    return "(COALESCE(t.estimate_minutes, 0) " + node.op + " " + arg(node.estimate.Minutes()) + ")", nil
+  case *queryText:
+    return "(t.name ILIKE " + arg("%" + likeEscaper.Replace(node.text) + "%") + ")", nil
+  }
+  return "", errors.New(fmt.Sprintf("tdmp.querySQL(): cannot translate %T to SQL", n))
+}
+
+/*
+=============================================================================
+ TaskSearch()
+-----------------------------------------------------------------------------
+ Inputs:  string text  - words to search for
+          User u       - only this user's tasks are searched
+ Returns: []SearchHit  - the user's tasks with every word, best first
+          error        - DB call could fail - likely cause is bad DB
+
+ Tasks keep a tsvector of the words of their name and notes (see
+ migrations 0015 and 0016) with a GIN index over it, so PostgreSQL does
+ the matching, stemming and ranking.  Words in the name rank higher.
+===========================================================================*/
+func (tm *TaskDataMapperPostgreSQL) TaskSearch(text string, u *User) ([]SearchHit, error) {
+  rows, err := env.db.Query(`SELECT t.id, ts_rank(t.search, q) AS rank
+                             FROM tasks t, plainto_tsquery('english', $2) q
+                             WHERE t.search @@ q AND t.id IN (SELECT task_id FROM task_users WHERE user_id = $1)
+                             ORDER BY rank DESC, t.id`, u.GetId(), text)
+  if err != nil {
+    return nil, errors.New(fmt.Sprintf("tdmp.TaskSearch(): search for tasks failed: %s", err))
+  }
+  defer rows.Close()
+
+  var hits []SearchHit
+  for rows.Next() {
+    var hit SearchHit
+    if err := rows.Scan(&hit.Id, &hit.Rank); err != nil {
+      return nil, errors.New(fmt.Sprintf("tdmp.TaskSearch(): row scan failed: %s", err))
+    }
+    hits = append(hits, hit)
+  }
+  return hits, rows.Err()
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"sort"
 	"testing"
+	"time"
 )
 
 // create, save and load a basic task - note that since we leverage the
@@ -18,7 +22,7 @@ func TestSingleTask(t *testing.T) {
 	}
 
 	// initialize the database connection
-    tdmpg1 := NewTaskDataMapperPostgreSQL(false)
+    tdmpg1 := NewTaskDataMapperPostgreSQL(false, DB_NAME)
     if tdmpg1 == nil {
     	t.Error("PIM-Testing requires a local PostgreSQL database to running.  Exiting...")
     	return
@@ -37,12 +41,12 @@ func TestSingleTask(t *testing.T) {
 		t.Error("Could not test - unable to create a basic task")
 		return
 	}
-    tdmpg2 := NewTaskDataMapperPostgreSQL(false)
+    tdmpg2 := NewTaskDataMapperPostgreSQL(false, DB_NAME)
     task_two.SetDataMapper(tdmpg2)
-    task_two.id = task_one.Id() // never in real life, but useful for testing
+    task_two.id = task_one.GetId() // never in real life, but useful for testing
     err = task_two.Load(false) // don't load children
     if err != nil {
-    	t.Error("Failed to load simple task we just saved with id: ", task_one.Id(), "err: ", err)
+    	t.Error("Failed to load simple task we just saved with id: ", task_one.GetId(), "err: ", err)
     }
 
     if !task_one.DeepEqual(task_two) {
@@ -56,11 +60,96 @@ func TestSingleTask(t *testing.T) {
     }
 
     // make sure it is gone
-    task_two.id = task_one.Id()
+    task_two.id = task_one.GetId()
     err = task_two.Load(false)
     if err == nil {
-    	t.Error("Was able to load a task that should have been deleted: ", task_one.Id())
+    	t.Error("Was able to load a task that should have been deleted: ", task_one.GetId())
     }
 }
 
 
+
+// queries translate into SQL conditions with their values as arguments
+func TestQuerySQL(t *testing.T) {
+	tq, err := ParseTaskQuery(`tag:Work -state:complete (estimate<30 OR "50%")`, time.UTC)
+	if err != nil {
+		t.Fatal("Unable to parse query:", err)
+	}
+	args := []interface{}{"user"}
+	sql, err := querySQL(tq.Root, &args)
+	if err != nil {
+		t.Fatal("Unable to translate query:", err)
+	}
+	expected := `(EXISTS (SELECT 1 FROM task_tags tt JOIN tags g ON g.id = tt.tag_id
+                    WHERE tt.task_id = t.id AND g.name = $2) AND (NOT (t.state = $3)) AND ((COALESCE(t.estimate_minutes, 0) < $4) OR (t.name ILIKE $5)))`
+	if sql != expected {
+		t.Error("Unexpected SQL:", sql)
+	}
+	if len(args) != 5 || args[1] != "Work" || args[2] != int(complete) || args[3] != float64(30) || args[4] != `%50\%%` {
+		t.Error("Unexpected arguments:", args)
+	}
+}
+
+// a query in a zone other than UTC finds the same tasks in the database as
+// in memory - times are stored and compared as UTC
+func TestQuerySQLTimeZone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("Unable to load time zone:", err)
+	}
+	tq, err := ParseTaskQuery("due<2026-11-01", newYork)
+	if err != nil {
+		t.Fatal("Unable to parse query:", err)
+	}
+	midnight := time.Date(2026, time.November, 1, 0, 0, 0, 0, newYork)
+	args := []interface{}{"user"}
+	if _, err := querySQL(tq.Root, &args); err != nil {
+		t.Fatal("Unable to translate query:", err)
+	}
+	if when, ok := args[1].(time.Time); !ok || when.Location() != time.UTC || !when.Equal(midnight) {
+		t.Error("Expected midnight in New York as a UTC argument but got", args[1])
+	}
+
+	if os.Getenv(DB_HOST_ENV) == "" {
+		t.Skip("Comparing with the database requires a local PostgreSQL database")
+	}
+	tdm := NewTaskDataMapperPostgreSQL(false, DB_NAME)
+	if tdm == nil {
+		t.Skip("Comparing with the database requires a local PostgreSQL database")
+	}
+	u, errId := NewUser("", "Tester", "tz-tester@example.com", "Correct-Horse-9", NewTaskDataMapperPostgreSQL(false, DB_NAME))
+	if errId != success || u.Save() != nil {
+		t.Fatal("Could not test - unable to create a user")
+	}
+	var tasks Tasks
+	defer func() {
+		for _, task := range tasks {
+			task.Remove(nil)
+		}
+		u.persist.UserDelete(u)
+	}()
+	for i, due := range []time.Time{midnight.Add(-time.Hour), midnight.Add(time.Hour), midnight.Add(5 * time.Hour)} {
+		task := NewTask(fmt.Sprintf("Due %d", i))
+		task.SetDataMapper(NewTaskDataMapperPostgreSQL(false, DB_NAME))
+		task.SetDueTime(&due)
+		task.AddUser(u)
+		if err := task.Save(false); err != nil {
+			t.Fatal("Could not test - unable to save a task:", err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	found, err := tdm.TaskQuery(tq, u)
+	if err != nil {
+		t.Fatal("Unable to query tasks:", err)
+	}
+	var expected []string
+	for _, task := range tasks.FindMatching(tq) {
+		expected = append(expected, task.GetId())
+	}
+	sort.Strings(found)
+	sort.Strings(expected)
+	if fmt.Sprint(found) != fmt.Sprint(expected) || len(expected) != 1 {
+		t.Error("Expected the database to find", expected, "but found", found)
+	}
+}
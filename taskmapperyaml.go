@@ -4,6 +4,7 @@ import (
   "fmt"
   "log"
   "os"
+  "strconv"
   "time"
   "strings"
   "sync"
@@ -16,6 +17,11 @@ import (
 type TaskDataMapperYAML struct {
   fileName string
   err error             // error state of the data mapper
+  versions map[string]int // version of each task last written to or read from the file (shared by copies)
+  search *SearchIndex     // words of the tasks in the file for TaskSearch() (shared by copies)
+  discussion *taskDiscussion // comments and activity, in memory only (shared by copies)
+  sessions *sessionStore  // sessions of signed in users, in memory only (shared by copies)
+  tokens *tokenStore      // personal access tokens, in memory only (shared by copies)
 }
 
 // Note: struct fields must be public in order for unmarshal to
@@ -28,10 +34,23 @@ type TaskYAML struct {
   TargetStartTime *time.Time    // targeted start time of the task
   ActualStartTime *time.Time    // actual start time of the task
   ActualCompletionTime *time.Time // time task is marked done
+  DueTime *time.Time      // deadline for the task
   Estimate int          // estimate for the task in minutes
   Tags []string           // attributes of the task
   Links []string          // hyperlinks assoicated with the task
   Parents []string        // ids of the parents for later hookup
+  Positions []int         // where the task is in each parent's children (same order as Parents)
+  Version int             // number of times the task has been saved
+  Recurrence string       // RRULE-style rule if the task recurs (see recurrence.go)
+  Priority int            // larger is more important
+  Created *time.Time      // when the task was created (missing in older files)
+  Sessions []WorkSessionYAML // time spent working on the task
+  Notes string            // long-form description in Markdown
+}
+type WorkSessionYAML struct {
+  Start time.Time
+  Stop *time.Time         // null while the timer is running
+  User string             // id of the user working
 }
 type TasksYAML struct {
   Tasks []TaskYAML
@@ -53,7 +72,7 @@ type TasksYAML struct {
 // download and go get the uuid library)
 
 func NewTaskDataMapperYAML(fileName string) *TaskDataMapperYAML {
-  return &TaskDataMapperYAML{fileName:fileName,err:nil}
+  return &TaskDataMapperYAML{fileName:fileName,err:nil,versions:make(map[string]int),search:NewSearchIndex(),discussion:newTaskDiscussion(),sessions:newSessionStore(),tokens:newTokenStore()}
 }
 
 
@@ -61,12 +80,12 @@ func NewTaskDataMapperYAML(fileName string) *TaskDataMapperYAML {
 // and unpersisted instance of the mapper that can later be filled in
 // by the object with the list of saved parent ids
 func (tm TaskDataMapperYAML) NewDataMapper(fileName string) TaskDataMapper {
-  return &TaskDataMapperYAML{fileName:fileName,err:nil}
+  return NewTaskDataMapperYAML(fileName)
 }
 
 // not sure anymore what CopyDataMapper is for - so this implementation may be wrong
 func (tm TaskDataMapperYAML) CopyDataMapper() TaskDataMapper {
-  return &TaskDataMapperYAML{fileName:tm.fileName,err:nil}
+  return &TaskDataMapperYAML{fileName:tm.fileName,err:nil,versions:tm.versions,search:tm.search,discussion:tm.discussion,sessions:tm.sessions,tokens:tm.tokens}
 }
 
 func (tm *TaskDataMapperYAML) Error() error {
@@ -86,6 +105,13 @@ func singleQuoteYAML(raw string) string {
   return "'" + strings.Replace(raw, "'", "''", -1) + "'"
 }
 
+// single quoted YAML folds line breaks into spaces, so text that may span
+// lines (like notes) is double quoted with its line breaks escaped - Go's
+// escapes are all ones YAML understands
+func doubleQuoteYAML(raw string) string {
+  return strconv.Quote(raw)
+}
+
 func (tm *TaskDataMapperYAML) writeTask(f *os.File , t *Task) error {
   if len(t.links) > 0 {
     // fmt.Printf("writeTask: links= %v, %v\n", t.links, t.GetLinks())
@@ -102,18 +128,46 @@ func (tm *TaskDataMapperYAML) writeTask(f *os.File , t *Task) error {
   if len(links) > 0 {
     strLinks = "'" + strings.Join(links, "', '") + "'"
   }
+  var positions []string
+  for _, parentId := range parentIds {
+    positions = append(positions, strconv.Itoa(t.Position(t.FindParent(parentId))))
+  }
+  strPositions := strings.Join(positions, ", ")
+  var sessions []string
+  for _, s := range t.GetSessions() {
+    sessions = append(sessions, fmt.Sprintf("{start: %s, stop: %s, user: %s}", TimeYAML(&s.Start), TimeYAML(s.Stop), singleQuoteYAML(s.UserId)))
+  }
+  strSessions := strings.Join(sessions, ", ")
 
-  _, err := fmt.Fprintf(f, "- {id: %s, parents: %v, name: %s, state: %s, estimate: %d, tags: [%s], links: [%s], targetstarttime: %s, actualstarttime: %s, actualcompletiontime: %s }\n", 
-                      t.GetId(), parentIds, singleQuoteYAML(t.GetName()), t.GetState(), estimate, strTags, strLinks,
+  _, err := fmt.Fprintf(f, "- {id: %s, parents: [%s], name: %s, state: %s, estimate: %d, tags: [%s], links: [%s], positions: [%s], targetstarttime: %s, actualstarttime: %s, actualcompletiontime: %s, duetime: %s, version: %d, recurrence: %s, priority: %d, created: %s, sessions: [%s], notes: %s }\n", 
+                      t.GetId(), strings.Join(parentIds, ", "), singleQuoteYAML(t.GetName()), t.GetState(), estimate, strTags, strLinks, strPositions,
                       TimeYAML(t.GetTargetStartTime()),
                       TimeYAML(t.GetActualStartTime()),
-                      TimeYAML(t.GetActualCompletionTime()))
+                      TimeYAML(t.GetActualCompletionTime()),
+                      TimeYAML(t.GetDueTime()),
+                      t.GetVersion(),
+                      singleQuoteYAML(t.GetRecurrence().String()),
+                      t.GetPriority(),
+                      TimeYAML(t.GetCreatedTime()),
+                      strSessions,
+                      doubleQuoteYAML(t.GetNotes()))
+  if err == nil {
+    tm.versions[t.GetId()] = t.GetVersion()
+    tm.search.Add(t)
+  }
   return err
 }
 
-func (tm *TaskDataMapperYAML) saveTask(f *os.File, t *Task) error {
+func (tm *TaskDataMapperYAML) saveTask(f *os.File, t *Task, written map[string]bool) error {
   var err error = nil
 
+  // a task with many parents is reached once per parent but
+  // written only once, the parent list links it back up on load
+  if written[t.GetId()] {
+    return nil
+  }
+  written[t.GetId()] = true
+
   // unless i'm the master root, save myself
   if t.HasParents() {
     err = tm.writeTask(f, t)
@@ -123,8 +177,9 @@ func (tm *TaskDataMapperYAML) saveTask(f *os.File, t *Task) error {
   }
 
   // now save all my kids
-  for c := t.FirstChild(); c != nil && err == nil; c = t.NextChild() {
-    err = tm.saveTask(f, c)
+  itKids := t.IterChildren()
+  for c := itKids.First(); c != nil && err == nil; c = itKids.Next() {
+    err = tm.saveTask(f, c, written)
   }
   if err != nil {
     return err
@@ -137,7 +192,7 @@ func (tm *TaskDataMapperYAML) saveTask(f *os.File, t *Task) error {
 
 // dump our tasks into the YAML file
 var mu sync.Mutex
-func (tm *TaskDataMapperYAML) Save(t *Task, saveChildren bool, saveMyself bool) error {
+func (tm *TaskDataMapperYAML) Save(t *Task, saveChildren bool, saveMyself bool) (err error) {
 
   // fmt.Println("tdmYAML.Save(): task = %s\b", t.GetName())
 
@@ -147,6 +202,21 @@ func (tm *TaskDataMapperYAML) Save(t *Task, saveChildren bool, saveMyself bool)
 
   // log.Printf("Save(%t, %t): task = %s, id = %s\n", saveChildren, saveMyself, t.name, t.id)
 
+  // like the database we only save over the version last written, and
+  // each save of the task bumps its version
+  if saveMyself && t.HasParents() {
+    saved, found := tm.versions[t.GetId()]
+    if found && saved != t.GetVersion() {
+      return &ErrVersionConflict{Id:t.GetId(), Version:t.GetVersion()}
+    }
+    t.SetVersion(t.GetVersion() + 1)
+    defer func() {
+      if err != nil {
+        t.SetVersion(t.GetVersion() - 1) // nothing was saved
+      }
+    }()
+  }
+
   // open the file and clear it's contents if it exists
     f, err := os.Create(tm.fileName)
     if err != nil {
@@ -174,26 +244,79 @@ func (tm *TaskDataMapperYAML) Save(t *Task, saveChildren bool, saveMyself bool)
   for root != nil && root.HasParents() {
     root = root.FirstParent()
   }
-  err = tm.saveTask(f, root);
+  written := make(map[string]bool)
+  err = tm.saveTask(f, root, written);
   if err != nil {
       log.Printf("unable to write tasks to YAML file: %s\n", tm.fileName)
     f.Close()
     return err
   }
 
+  // tasks no longer written (deleted) can no longer be found
+  tm.search.Retain(written)
+
   f.Close()
   return nil
 }
 
-func (tm *TaskDataMapperYAML) addChildTask(parent* Task, yt* TaskYAML) (error, *Task) {
-  
-  child := &Task{id:yt.Id}
-  child.SetName(yt.Name)
-  child.Estimate = time.Duration(yt.Estimate) * time.Minute
-  child.ActualStartTime = yt.ActualStartTime
-  child.ActualCompletionTime = yt.ActualCompletionTime
-  child.TargetStartTime = yt.TargetStartTime
-  child.SetState(TaskStateFromString(yt.State))
+// FromTask: fill the YAML form of a task from the in-memory task.  Besides
+// writing our YAML file this is also a handy serializable snapshot of a
+// task (the command journal uses it).
+func (yt *TaskYAML) FromTask(t *Task) {
+  yt.Id = t.GetId()
+  yt.Name = t.GetName()
+  yt.State = t.GetState().String()
+  yt.TargetStartTime = copyTime(t.GetTargetStartTime())
+  yt.ActualStartTime = copyTime(t.GetActualStartTime())
+  yt.ActualCompletionTime = copyTime(t.GetActualCompletionTime())
+  yt.DueTime = copyTime(t.GetDueTime())
+  yt.Estimate = int(t.GetEstimate().Minutes())
+  yt.Tags = t.GetTags()
+  yt.Links = t.GetLinks()
+  yt.Version = t.GetVersion()
+  yt.Recurrence = t.GetRecurrence().String()
+  yt.Priority = t.GetPriority()
+  yt.Created = copyTime(t.GetCreatedTime())
+  yt.Notes = t.GetNotes()
+  yt.Sessions = nil
+  for _, s := range t.GetSessions() {
+    yt.Sessions = append(yt.Sessions, WorkSessionYAML{Start:s.Start, Stop:copyTime(s.Stop), User:s.UserId})
+  }
+  yt.Parents = nil
+  for _, p := range t.parents {
+    if !p.IsMemoryOnly() {
+      yt.Parents = append(yt.Parents, p.GetId())
+    }
+  }
+}
+
+// ToTask: set all the fields of the in-memory task from the YAML form
+// of the task (does not touch the id, parents or children)
+func (yt *TaskYAML) ToTask(t *Task) {
+  t.SetName(yt.Name)
+  t.Estimate = time.Duration(yt.Estimate) * time.Minute
+  t.ActualStartTime = yt.ActualStartTime
+  t.ActualCompletionTime = yt.ActualCompletionTime
+  t.TargetStartTime = yt.TargetStartTime
+  t.DueTime = yt.DueTime
+  t.SetState(TaskStateFromString(yt.State))
+  t.SetVersion(yt.Version)
+  t.SetPriority(yt.Priority)
+  t.SetCreatedTime(yt.Created)
+  t.SetNotes(yt.Notes)
+  var sessions []WorkSession
+  for _, s := range yt.Sessions {
+    sessions = append(sessions, WorkSession{Start:s.Start, Stop:copyTime(s.Stop), UserId:s.User})
+  }
+  t.SetSessions(sessions)
+  t.SetRecurrence(nil)
+  if len(yt.Recurrence) > 0 {
+    r, err := ParseRecurrence(yt.Recurrence)
+    if err != nil {
+      log.Printf("TaskYAML.ToTask(): ignoring recurrence of <%s>: %s\n", yt.Id, err)
+    }
+    t.SetRecurrence(r)
+  }
   /*
   switch yt.State {
     case "notStarted": child.SetState(notStarted)
@@ -204,15 +327,25 @@ func (tm *TaskDataMapperYAML) addChildTask(parent* Task, yt* TaskYAML) (error, *
   }
   */
 
+  t.ClearTags()
   for _,v := range yt.Tags {
-    child.SetTag(v)
+    t.SetTag(v)
   }
 
+  t.ClearLinks()
   for _,v := range yt.Links {
     if (len(v) > 0) {
-      child.AddLink(v, 0, 0)
+      t.AddLink(v, 0, 0)
     }
   } 
+}
+
+func (tm *TaskDataMapperYAML) addChildTask(parent* Task, yt* TaskYAML) (error, *Task) {
+  
+  child := &Task{id:yt.Id}
+  yt.ToTask(child)
+  tm.versions[child.GetId()] = child.GetVersion()
+  tm.search.Add(child)
 
   parent.AddChild(child)
   return nil, child
@@ -267,8 +400,21 @@ func (tm *TaskDataMapperYAML) Load(t *Task, loadChildren bool, root bool) error
  
     // log.Printf("--- YAML Tasks:\n%+v\n\n", yamlTasks)
 
-    // convert yaml tasks into real tasks
-    for _, v := range yamlTasks.Tasks {
+    // convert yaml tasks into real tasks - a task with many parents may
+    // come before some of its parents in the file so we remember those
+    // links and make them once every task has been created
+    pending := make(map[*Task][]string)
+    positions := make(map[string]map[string]int) // parent id -> child id -> position
+    for i := range yamlTasks.Tasks {
+      v := yamlTasks.Tasks[i]
+      if len(v.Positions) == len(v.Parents) {
+        for j, parentId := range v.Parents {
+          if positions[parentId] == nil {
+            positions[parentId] = make(map[string]int)
+          }
+          positions[parentId][v.Id] = v.Positions[j]
+        }
+      }
 
       // if no parents, then add this task to the master
       if len(v.Parents) == 0 {
@@ -276,6 +422,7 @@ func (tm *TaskDataMapperYAML) Load(t *Task, loadChildren bool, root bool) error
       } else {
         var parent *Task
         var child *Task
+        var missing []string
         child = nil
         for _, parentId := range v.Parents {
           parent = t.FindDescendent(parentId)
@@ -290,20 +437,54 @@ func (tm *TaskDataMapperYAML) Load(t *Task, loadChildren bool, root bool) error
           } else {
             parent.AddChild(child)
           }
+          } else if child != nil {
+            pending[child] = append(pending[child], parentId)
           } else {
-            log.Printf("TaskDataMapperYAML.Load(): Unable to find parent id <%s> for <%s>.\n", parentId, v.Id)
+            missing = append(missing, parentId)
           }
         } // for each requested parent id
 
-        // parents were specified but none found, so don't orphan the task - link to the root
+        // parents were specified but none found yet, so don't orphan the task - link
+        // to the root for now and move it under its parents once they are loaded
         if child == nil {
-          tm.addChildTask(t, &v)
-          log.Printf("TaskDataMapperYAML.Load(): Unable to find any parents for <%s> so linked <%s>to root task.\n", v.Id, v.Name)
+          _, child = tm.addChildTask(t, &v)
+          pending[child] = append(pending[child], missing...)
         }
 
       } // else there are parents to be sought
     }
 
+    // now link up the parents that were not yet loaded when their child was
+    for child, parentIds := range pending {
+      for _, parentId := range parentIds {
+        parent := t.FindDescendent(parentId)
+        if parent != nil && parent != child && !child.IsAncestorOf(parent) {
+          if child.FindParent(t.GetId()) != nil {
+            child.RemoveParent(t)
+          }
+          parent.AddChild(child)
+        } else {
+          log.Printf("TaskDataMapperYAML.Load(): Unable to find parent id <%s> for <%s>.\n", parentId, child.GetId())
+        }
+      }
+      if !child.HasParents() {
+        t.AddChild(child)
+        log.Printf("TaskDataMapperYAML.Load(): Unable to find any parents for <%s> so linked <%s> to root task.\n", child.GetId(), child.GetName())
+      }
+    }
+
+    // a child is linked to each parent as it is read, which need not be
+    // the order the parent had its children in - so put them back in the
+    // order saved.  Top-level tasks are written (and so read) in order.
+    for parentId, childPositions := range positions {
+      parent := t.FindDescendent(parentId)
+      if parent != nil && len(childPositions) == parent.NumChildren() {
+        parent.SortChildren(func(a *Task, b *Task) bool {
+          return childPositions[a.GetId()] < childPositions[b.GetId()]
+        })
+      }
+    }
+
   return tm.err
 }
 
@@ -313,6 +494,12 @@ func (tm *TaskDataMapperYAML) Load(t *Task, loadChildren bool, root bool) error
 // the server stops).  You could argue we only have to do it when the server stops.
 func (tm *TaskDataMapperYAML) Delete(t *Task, reparent *Task) error {
   // return tm.Save(nil, true, true)
+
+  // like a deleted row a deleted task has no version to save over, so
+  // putting it back (e.g. redoing its create) starts afresh
+  mu.Lock()
+  defer mu.Unlock()
+  delete(tm.versions, t.GetId())
   return nil
 }
 
@@ -332,3 +519,98 @@ func (tm *TaskDataMapperYAML) UserLoadAll() (Users, error) {
   return nil, nil
 }
 
+// TaskSearch: search the index of the tasks loaded from and saved to the
+// file - it holds every user's tasks so callers must check access
+func (tm *TaskDataMapperYAML) TaskSearch(text string, u *User) ([]SearchHit, error) {
+  return tm.search.Search(text), nil
+}
+
+// the command journal is not persisted by the YAML mapper - undo history
+// simply starts empty each time the server is started
+func (tm *TaskDataMapperYAML) JournalSave(e *JournalEntry) error {
+  return nil
+}
+
+func (tm *TaskDataMapperYAML) JournalLoad(u *User) ([]*JournalEntry, error) {
+  return nil, nil
+}
+
+func (tm *TaskDataMapperYAML) JournalDelete(e *JournalEntry) error {
+  return nil
+}
+
+func (tm *TaskDataMapperYAML) JournalPrune(before time.Time) error {
+  return nil
+}
+
+
+// comments and activity are not written to the file either - they are
+// kept in memory and start empty each time the server is started
+func (tm *TaskDataMapperYAML) CommentSave(c *TaskComment) error {
+  tm.discussion.saveComment(c)
+  return nil
+}
+
+func (tm *TaskDataMapperYAML) CommentLoad(t *Task) ([]*TaskComment, error) {
+  return tm.discussion.loadComments(t.GetId()), nil
+}
+
+func (tm *TaskDataMapperYAML) ActivitySave(a *TaskActivity) error {
+  tm.discussion.saveActivity(a)
+  return nil
+}
+
+func (tm *TaskDataMapperYAML) ActivityLoad(t *Task) ([]*TaskActivity, error) {
+  return tm.discussion.loadActivity(t.GetId()), nil
+}
+
+// sessions are kept in memory too, so everyone is signed out each time
+// the server is started
+func (tm *TaskDataMapperYAML) SessionSave(s *UserSession) error {
+  tm.sessions.save(s)
+  return nil
+}
+
+func (tm *TaskDataMapperYAML) SessionLoad(id string) (*UserSession, error) {
+  return tm.sessions.load(id), nil
+}
+
+func (tm *TaskDataMapperYAML) SessionDelete(s *UserSession) error {
+  tm.sessions.delete(s.Id)
+  return nil
+}
+
+func (tm *TaskDataMapperYAML) SessionDeleteAll(u *User) error {
+  tm.sessions.deleteUser(u.GetId())
+  return nil
+}
+
+func (tm *TaskDataMapperYAML) SessionPrune(before time.Time) error {
+  tm.sessions.prune(before)
+  return nil
+}
+
+// sign-in attempts are not recorded by the YAML mapper
+func (tm *TaskDataMapperYAML) LoginSave(l *UserLogin) error {
+  return nil
+}
+
+// personal access tokens are kept in memory too, so scripts need new ones
+// each time the server is started
+func (tm *TaskDataMapperYAML) TokenSave(k *UserToken) error {
+  tm.tokens.save(k)
+  return nil
+}
+
+func (tm *TaskDataMapperYAML) TokenLoad(hash string) (*UserToken, error) {
+  return tm.tokens.load(hash), nil
+}
+
+func (tm *TaskDataMapperYAML) TokenLoadAll(u *User) ([]*UserToken, error) {
+  return tm.tokens.loadAll(u.GetId()), nil
+}
+
+func (tm *TaskDataMapperYAML) TokenDelete(k *UserToken) error {
+  tm.tokens.delete(k)
+  return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// the order of each parent's children survives a save and load, even for
+// a child with many parents that is read before some of its parents
+func TestYAMLChildOrder(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "tasks.yaml")
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(NewTaskDataMapperYAML(fileName))
+
+	a := NewTask("a")
+	b := NewTask("b")
+	c := NewTask("c")
+	x := NewTask("x")
+	y := NewTask("y")
+	master.AddChild(a)
+	master.AddChild(b)
+	master.AddChild(c)
+	a.AddChild(x)
+	b.AddChild(x)
+	b.AddChild(y)
+	x.MoveBefore(b, nil) // b's children are now y, x but x is written under a
+	y.MoveBefore(b, nil) // and now back to x, y
+	c.MoveBefore(master, a)
+	if err := master.Save(true); err != nil {
+		t.Fatal("Unable to save tasks:", err)
+	}
+
+	loaded := NewTaskMemoryOnly("Your Task List")
+	loaded.SetDataMapper(NewTaskDataMapperYAML(fileName))
+	if err := loaded.Load(true); err != nil {
+		t.Fatal("Unable to load tasks:", err)
+	}
+
+	expectChildren := func(parent *Task, expected Tasks) {
+		kids := parent.Kids(nil)
+		if len(kids) != len(expected) {
+			t.Error("Expected", len(expected), "children of", parent.GetName(), "but found", len(kids))
+			return
+		}
+		for i := range expected {
+			if kids[i].GetId() != expected[i].GetId() {
+				t.Error("Child", i, "of", parent.GetName(), "expected", expected[i].GetName(), "but found", kids[i].GetName())
+			}
+		}
+	}
+	expectChildren(loaded, Tasks{c, a, b})
+	expectChildren(loaded.FindDescendent(b.GetId()), Tasks{x, y})
+}
+
+// work sessions, stopped and running, survive a save and load
+func TestYAMLSessions(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "tasks.yaml")
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(NewTaskDataMapperYAML(fileName))
+	task := NewTask("timed")
+	master.AddChild(task)
+	start := time.Date(2026, time.October, 17, 9, 0, 0, 0, time.UTC)
+	task.StartTimer(nil, start)
+	task.StopTimer(start.Add(20 * time.Minute))
+	task.StartTimer(nil, start.Add(time.Hour))
+	if err := master.Save(true); err != nil {
+		t.Fatal("Unable to save tasks:", err)
+	}
+
+	loaded := NewTaskMemoryOnly("Your Task List")
+	loaded.SetDataMapper(NewTaskDataMapperYAML(fileName))
+	if err := loaded.Load(true); err != nil {
+		t.Fatal("Unable to load tasks:", err)
+	}
+	found := loaded.FindDescendent(task.GetId())
+	if found == nil || len(found.GetSessions()) != 2 || found.RunningSession() == nil {
+		t.Fatal("Expected the task to come back with one stopped and one running session")
+	}
+	if spent := found.TimeSpent(start.Add(90 * time.Minute)); spent != 50 * time.Minute {
+		t.Error("Expected 50 minutes spent after loading but found", spent)
+	}
+}
+
+// the search index is built when tasks are loaded and kept up to date as
+// they are saved
+func TestYAMLSearch(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "tasks.yaml")
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(NewTaskDataMapperYAML(fileName))
+	analysis := NewTask("Twillio Cost Analysis")
+	master.AddChild(analysis)
+	sub := NewTask("Gather Twillio invoices")
+	analysis.AddChild(sub)
+	if err := master.Save(true); err != nil {
+		t.Fatal("Unable to save tasks:", err)
+	}
+
+	mapper := NewTaskDataMapperYAML(fileName)
+	loaded := NewTaskMemoryOnly("Your Task List")
+	loaded.SetDataMapper(mapper)
+	if err := loaded.Load(true); err != nil {
+		t.Fatal("Unable to load tasks:", err)
+	}
+	hits, _ := mapper.TaskSearch("twillio", nil)
+	if len(hits) != 2 {
+		t.Fatal("Expected both loaded tasks to be found but found", hits)
+	}
+
+	found := loaded.FindDescendent(sub.GetId())
+	found.SetName("Gather phone invoices")
+	if err := found.Save(false); err != nil {
+		t.Fatal("Unable to save task:", err)
+	}
+	if hits, _ := mapper.TaskSearch("twillio", nil); len(hits) != 1 || hits[0].Id != analysis.GetId() {
+		t.Error("Expected only the analysis after the rename but found", hits)
+	}
+}
+
+// notes keep their line breaks, quotes and Markdown through a save and
+// load, and their words can be searched
+func TestYAMLNotes(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "tasks.yaml")
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(NewTaskDataMapperYAML(fileName))
+	notes := "# Plan\n\n- call \"Acme\" about the invoice\n- it's `urgent`: really\n"
+	task := NewTask("Pay bills")
+	task.SetNotes(notes)
+	master.AddChild(task)
+	if err := master.Save(true); err != nil {
+		t.Fatal("Unable to save tasks:", err)
+	}
+
+	mapper := NewTaskDataMapperYAML(fileName)
+	loaded := NewTaskMemoryOnly("Your Task List")
+	loaded.SetDataMapper(mapper)
+	if err := loaded.Load(true); err != nil {
+		t.Fatal("Unable to load tasks:", err)
+	}
+	found := loaded.FindDescendent(task.GetId())
+	if found == nil || found.GetNotes() != notes {
+		t.Fatalf("Expected notes %q after loading but found %v", notes, found)
+	}
+	if hits, _ := mapper.TaskSearch("acme", nil); len(hits) != 1 || hits[0].Id != task.GetId() {
+		t.Error("Expected to find the task by a word in its notes but found", hits)
+	}
+}
@@ -4,6 +4,7 @@ import (
     "fmt"    
     "errors"
     "log"
+    "time"
 )
 
 /*
@@ -21,13 +22,20 @@ import (
  it may make sense to separate the "generic" Command and commandHistory
  objects from the PIM-specific commands.
 
- We don't actually expose the command stack except through the two functions
- which execute or undo, and take care of all the pushing and popping to the
- stack:
-    CommandDo(cmd Command) - executes the command and pushes it to history
-    CommandUndo()          - pops the most recent command and undoes it
+ We don't actually expose the command stack except through the functions
+ which execute, undo or redo, and take care of all the pushing and popping to
+ the stacks:
+    CommandDo(u, cmd)  - executes the command and pushes it to history
+    CommandUndo(u)     - pops the most recent command and undoes it
+    CommandRedo(u)     - pops the most recently undone command and redoes it
 
- TBD: create a redo stack and a CommandRedo() function.
+ Each command that succeeds is also recorded in the activity of the task it
+ acted upon (see comments.go) so everyone sharing the task can see it.
+
+ Each user has their own commandHistory (see User.History()) so that one
+ user can never undo or redo the work of another user who shares the
+ server (or even shares a task).  Executing a brand new command clears the
+ redo stack, since the commands on it may no longer make sense.
 
  TBD: Of course, all of this depends on the creation of new Commands that 
  exec and undo their work, and making sure these commands are actually used 
@@ -37,17 +45,24 @@ type Command interface {
     Exec() error
     Undo() error
     Log() string
+    Target() *Task // the task the command acted upon
+    Record() *commandRecord // serializable form of the command (see journal.go)
 }
 
 type commandHistory struct {
     cmds []Command
-    redo []Command // TBD - not yet used
+    redo []Command
+    journal map[Command]*JournalEntry // journal entries of commands in either stack
 }
 
 func (h *commandHistory) IsEmpty() bool {
     return len(h.cmds) == 0
 }
 
+func (h *commandHistory) IsRedoEmpty() bool {
+    return len(h.redo) == 0
+}
+
 func (h *commandHistory) Push(c Command) error {
     h.cmds = append(h.cmds, c)
     return nil
@@ -65,30 +80,158 @@ func (h *commandHistory) Pop() Command {
     }
 }
 
+func (h *commandHistory) PushRedo(c Command) error {
+    h.redo = append(h.redo, c)
+    return nil
+}
+
+func (h *commandHistory) PopRedo() Command {
+    if h.IsRedoEmpty() {
+        return nil
+    }
+    index := len(h.redo) - 1
+    c := h.redo[index]
+    h.redo = h.redo[:index]
+    return c
+}
+
+func (h *commandHistory) ClearRedo() {
+    h.redo = nil
+}
+
+// commandUpdates: the update commands in a command (a transaction may
+// hold several)
+func commandUpdates(cmd Command) []*updateTaskCmd {
+    switch c := cmd.(type) {
+    case *updateTaskCmd:
+        return []*updateTaskCmd{c}
+    case *compositeCmd:
+        var found []*updateTaskCmd
+        for _, child := range c.cmds {
+            found = append(found, commandUpdates(child)...)
+        }
+        return found
+    }
+    return nil
+}
+
+// commandVersions: the versions of the tasks a command acts upon, taken
+// before it runs
+func commandVersions(cmd Command) map[*Task]int {
+    versions := make(map[*Task]int)
+    if cc, ok := cmd.(*compositeCmd); ok {
+        for _, child := range cc.cmds {
+            for t, v := range commandVersions(child) {
+                if _, found := versions[t]; !found {
+                    versions[t] = v
+                }
+            }
+        }
+    } else if t := cmd.Target(); t != nil {
+        versions[t] = t.GetVersion()
+    }
+    return versions
+}
+
+// followVersions: once one of the user's commands has saved tasks, the
+// user's updates of those tasks that were current before it are current
+// after it too - a user's own changes never conflict with their undo
+func (h *commandHistory) followVersions(before map[*Task]int) {
+    for _, stack := range [][]Command{h.cmds, h.redo} {
+        for _, cmd := range stack {
+            for _, utc := range commandUpdates(cmd) {
+                if v, found := before[utc.tUpdate]; found && utc.iVersion == v {
+                    utc.iVersion = utc.tUpdate.GetVersion()
+                }
+            }
+        }
+    }
+}
+
 // since the pattern we chose is to keep the actual command objects
 // internal, this function should only be called from a command object.
-func CommandDo(cmd Command) error {
+func CommandDo(u *User, cmd Command) error {
+    return commandDo(u, cmd, true)
+}
+
+// CommandDoKeepRedo: execute a command the system runs on the user's
+// behalf (e.g. the midnight rollover), which is undoable like any other
+// but leaves what the user undid available to redo
+func CommandDoKeepRedo(u *User, cmd Command) error {
+    return commandDo(u, cmd, false)
+}
+
+func commandDo(u *User, cmd Command, clearRedo bool) error {
+    before := commandVersions(cmd)
     err := cmd.Exec()
     if err == nil {
-        if commands == nil {
-            commands = new(commandHistory)
+        h := u.History()
+        h.followVersions(before)
+        h.Push(cmd)
+        if clearRedo {
+            h.journalDrop(u, h.redo)
+            h.ClearRedo()
         }
-        commands.Push(cmd)
+        h.journalAdd(u, cmd)
+        recordActivity(u, cmd)
     }
     log.Print(cmd.Log())
     return err
 }
 
-func CommandUndo() error {
-    if commands == nil || commands.IsEmpty() {
+// undo the most recent command of the user, returning the command
+// that was undone so callers can report on what was affected
+func CommandUndo(u *User) (Command, error) {
+    h := u.History()
+    if h.IsEmpty() {
         err := errors.New("nothing to undo")
         log.Print(commandLog("UNDO-ERROR", "Nothing to Undo", err))
-        return err
+        return nil, err
+    }
+    cmd := h.Pop()
+    before := commandVersions(cmd)
+    err := cmd.Undo()
+    log.Print(cmd.Log())
+    if err == nil {
+        h.followVersions(before)
+        h.PushRedo(cmd)
+        h.journalMark(u, cmd, true)
+        recordActivity(u, cmd)
     } else {
-        cmd := commands.Pop()
-        err := cmd.Undo()
-        return err
+        h.journalDrop(u, []Command{cmd}) // failed commands leave the history
     }
+    return cmd, err
+}
+
+// redo the most recently undone command of the user - redo simply
+// executes the command again, and puts it back on the undo stack
+func CommandRedo(u *User) (Command, error) {
+    h := u.History()
+    if h.IsRedoEmpty() {
+        err := errors.New("nothing to redo")
+        log.Print(commandLog("REDO-ERROR", "Nothing to Redo", err))
+        return nil, err
+    }
+    cmd := h.PopRedo()
+    before := commandVersions(cmd)
+    err := cmd.Exec()
+    log.Print(cmd.Log())
+    if err == nil {
+        h.followVersions(before)
+        h.Push(cmd)
+        h.journalMark(u, cmd, false)
+        recordActivity(u, cmd)
+    } else {
+        h.journalDrop(u, []Command{cmd}) // failed commands leave the history
+    }
+    return cmd, err
+}
+
+func commandTargetName(cmd Command) string {
+    if t := cmd.Target(); t != nil {
+        return t.GetName()
+    }
+    return "unknown task"
 }
 
 func commandLog(cmd string, context string, err error) string {
@@ -146,12 +289,16 @@ func (dtc *deleteTaskCmd) Log() string {
     return dtc.sLog
 }
 
-func CommandDeleteTask(t *Task, tNewParent *Task) error {
+func (dtc *deleteTaskCmd) Target() *Task {
+    return dtc.tDelete
+}
+
+func CommandDeleteTask(u *User, t *Task, tNewParent *Task) error {
     var cmdDelete *deleteTaskCmd
     cmdDelete = new(deleteTaskCmd)
     cmdDelete.tDelete = t
     cmdDelete.tNewParent = nil
-    err := CommandDo(cmdDelete)
+    err := CommandDo(u, cmdDelete)
     return err
 }
 
@@ -165,6 +312,7 @@ func CommandDeleteTask(t *Task, tNewParent *Task) error {
 ============================================================================*/
 type createTaskCmd struct {
     tCreate    *Task
+    tParent    *Task // remembered on undo so a redo can reattach the task
     sLog       string
     // TBD: save multiple parents
     // TBD: save previous children so we can restore them
@@ -176,6 +324,11 @@ func (ctc *createTaskCmd) Exec() error {
 
     // TBD: save additional parents and children
 
+    // on a redo the task was orphaned by the undo so put it back
+    if ctc.tParent != nil && !ctc.tCreate.HasParents() {
+        ctc.tParent.AddChild(ctc.tCreate)
+    }
+
     // create the task which will immediately create in storage
     // fmt.Printf("createTaskCmd.Exec(): creating %s\n", ctc.tCreate.GetName())
     err := ctc.tCreate.Save(true)   
@@ -188,6 +341,7 @@ func (ctc *createTaskCmd) Undo() error {
     // TBD - consider how we would redo multiple parents and children
     // delete the task
     // fmt.Printf("createTaskCmd.Undo(): undoing create of %s\n", ctc.tCreate.GetName())    
+    ctc.tParent = ctc.tCreate.FirstParent()
     err := ctc.tCreate.Remove(nil) // nil -> orphan any of my children ???
     ctc.sLog = commandLog("UNDO-CREATE", ctc.tCreate.GetName(), err)
     return err
@@ -197,11 +351,19 @@ func (ctc *createTaskCmd) Log() string {
     return ctc.sLog
 }
 
-func CommandCreateTask(t *Task) error {
+func (ctc *createTaskCmd) Target() *Task {
+    return ctc.tCreate
+}
+
+func newCreateTaskCmd(t *Task) *createTaskCmd {
     var cmdCreate *createTaskCmd
     cmdCreate = new(createTaskCmd)
     cmdCreate.tCreate = t
-    return CommandDo(cmdCreate)
+    return cmdCreate
+}
+
+func CommandCreateTask(u *User, t *Task) error {
+    return CommandDo(u, newCreateTaskCmd(t))
 }
 
 
@@ -215,6 +377,8 @@ func CommandCreateTask(t *Task) error {
 type updateTaskCmd struct {
     tPrior    *Task
     tUpdate   *Task
+    tAfter    *Task // copy of the updated task taken on undo for redo
+    iVersion  int   // version of the task as the command last left it (0 = not yet run)
     bPrepared bool
     sLog      string
     // TBD: save multiple parents
@@ -246,34 +410,100 @@ func (utc *updateTaskCmd) Exec() error {
 
     // TBD: save additional parents and children
 
+    // on a redo we put back the values that were undone - unless someone
+    // else has changed the task since, whose change we would overwrite
+    if utc.tAfter != nil {
+        if err := utc.checkVersion(); err != nil {
+            utc.sLog = updateLog("EXEC", utc.tPrior, utc.tUpdate, err)
+            return err
+        }
+        restoreTaskFields(utc.tAfter, utc.tUpdate)
+    }
+
     // modify the task which will immediately change in storage
     // all we need to do is save the modified task
     // fmt.Printf("updateTaskCmd.Exec(): changing %s\n", utc.tPrior.GetName())
     err := utc.tUpdate.Save(false) 
-    utc.sLog = commandLog("EXEC-UPDATE", utc.tUpdate.GetName(), err)
+    if _, conflict := err.(*ErrVersionConflict); conflict {
+        restoreTaskFields(utc.tPrior, utc.tUpdate) // keep memory matching what is stored
+    } else if err == nil {
+        utc.iVersion = utc.tUpdate.GetVersion()
+    }
+    utc.sLog = updateLog("EXEC", utc.tPrior, utc.tUpdate, err)
     return err
 }
 
 func (utc *updateTaskCmd) Undo() error {
+    if err := utc.checkVersion(); err != nil {
+        utc.sLog = updateLog("UNDO", utc.tUpdate, utc.tUpdate, err)
+        return err
+    }
+
     // copy the object values back and resave
-    errContext := utc.tUpdate.GetName()
-    utc.tPrior.Copy(utc.tUpdate)
-    err := utc.tUpdate.Save(true)
-    utc.sLog = commandLog("UNDO-UPDATE", errContext, err)
+    utc.tAfter = utc.tUpdate.Copy(nil)
+    restoreTaskFields(utc.tPrior, utc.tUpdate)
+    err := utc.tUpdate.Save(false)
+    if err != nil {
+        restoreTaskFields(utc.tAfter, utc.tUpdate) // keep memory matching what is stored
+    } else {
+        utc.iVersion = utc.tUpdate.GetVersion()
+    }
+    utc.sLog = updateLog("UNDO", utc.tAfter, utc.tUpdate, err)
     return err
 }
 
+// checkVersion: undo and redo put back a whole copy of the task, so they
+// may only run over the version the command left - if anyone else has
+// saved the task since, putting the copy back would lose their change.
+// The user's own later commands don't count (see followVersions())
+func (utc *updateTaskCmd) checkVersion() error {
+    if utc.iVersion != 0 && utc.tUpdate.GetVersion() != utc.iVersion {
+        return &ErrVersionConflict{Id:utc.tUpdate.GetId(), Version:utc.iVersion}
+    }
+    return nil
+}
+
+// an update that changes the state of a task is logged as a STATE command
+// so the activity of a task can show who started or finished it
+func updateLog(verb string, before *Task, after *Task, err error) string {
+    if before.GetState() != after.GetState() {
+        context := fmt.Sprintf("%s (%s to %s)", after.GetName(), before.GetState(), after.GetState())
+        return commandLog(verb + "-STATE", context, err)
+    }
+    return commandLog(verb + "-UPDATE", after.GetName(), err)
+}
+
+// restoreTaskFields: copy the fields of a saved copy of a task back onto the
+// task, keeping its current version - undo and redo are new changes to the
+// task rather than a return to an old version.  The work sessions are kept
+// too: they belong to the timer commands (see TimerTaskCmd), so undoing an
+// update never takes away time someone logged since.  So are the parents
+// and children, which belong to the commands that change the hierarchy
+func restoreTaskFields(from *Task, to *Task) {
+    version := to.GetVersion()
+    sessions := to.GetSessions()
+    parents, kids := to.parents, to.kids
+    from.Copy(to)
+    to.SetVersion(version)
+    to.SetSessions(sessions)
+    to.parents, to.kids = parents, kids
+}
+
 func (utc *updateTaskCmd) Log() string {
     return utc.sLog
 }
 
+func (utc *updateTaskCmd) Target() *Task {
+    return utc.tUpdate
+}
+
 
 /*
 func CommandModifyTask(t *Task) error { // not there yet with handler - need 2 tasks to be passed in?
     var cmdUpdate *updateTaskCmd
     cmdUpdate = new(updateTaskCmd)
     cmdUpdate.tPrior = t
-    return CommandDo(cmdUpdate)
+    return CommandDo(u, cmdUpdate)
 }
 */
 
@@ -285,7 +515,382 @@ func CommandModifyTaskBegin(t *Task) *updateTaskCmd {
     return cmdUpdate
 }
 
-func CommandModifyTaskEnd(cmdUpdate *updateTaskCmd, t *Task) error { 
+// completing an occurrence of a recurring task also creates the next
+// occurrence (under the same parent) - both in one command so a single
+// undo takes back the completion and the new occurrence together.  The
+// rule moves to the new occurrence so completing this one again won't
+// schedule another
+func CommandModifyTaskEnd(u *User, cmdUpdate *updateTaskCmd, t *Task) error { 
     cmdUpdate.tUpdate = t
-    return CommandDo(cmdUpdate)
+    if t.IsComplete() && !cmdUpdate.tPrior.IsComplete() {
+        next := t.NextOccurrence()
+        if next != nil {
+            t.SetRecurrence(nil)
+            cmdCreate := newCreateTaskCmd(next)
+            cmdCreate.tParent = t.FirstParent()
+            return CommandTransaction(u, "COMPLETE", cmdUpdate, cmdCreate)
+        }
+    }
+    return CommandDo(u, cmdUpdate)
+}
+
+
+/*
+==============================================================================
+ MoveTaskCmd
+------------------------------------------------------------------------------
+ This command moves a task before one of its siblings (or to the end of its
+ parent's list of children) and allows for undo.  It remembers the sibling
+ the task used to be in front of so the task can be put back.
+============================================================================*/
+type moveTaskCmd struct {
+    tMove      *Task
+    tParent    *Task // parent whose list of children is reordered
+    tBefore    *Task // sibling to move in front of (nil = end of list)
+    tOldBefore *Task // sibling the task was in front of before the move
+    sLog       string
+}
+
+func (mtc *moveTaskCmd) Exec() error {
+    mtc.tOldBefore = mtc.tMove.NextSibling(mtc.tParent)
+    err := mtc.tMove.MoveBefore(mtc.tParent, mtc.tBefore)
+    if err == nil {
+        err = mtc.tMove.Save(false)
+    }
+    mtc.sLog = commandLog("EXEC-MOVE", mtc.tMove.GetName(), err)
+    return err
+}
+
+func (mtc *moveTaskCmd) Undo() error {
+    err := mtc.tMove.MoveBefore(mtc.tParent, mtc.tOldBefore)
+    if err == nil {
+        err = mtc.tMove.Save(false)
+    }
+    mtc.sLog = commandLog("UNDO-MOVE", mtc.tMove.GetName(), err)
+    return err
+}
+
+func (mtc *moveTaskCmd) Log() string {
+    return mtc.sLog
+}
+
+func (mtc *moveTaskCmd) Target() *Task {
+    return mtc.tMove
+}
+
+func newMoveTaskCmd(t *Task, tParent *Task, tBefore *Task) *moveTaskCmd {
+    return &moveTaskCmd{tMove:t, tParent:tParent, tBefore:tBefore}
+}
+
+func CommandMoveTask(u *User, t *Task, tParent *Task, tBefore *Task) error {
+    return CommandDo(u, newMoveTaskCmd(t, tParent, tBefore))
+}
+
+
+/*
+==============================================================================
+ ReparentTaskCmd
+------------------------------------------------------------------------------
+ This command moves a task from one parent to another and allows for undo.
+ Either parent can be nil, so the same command adds a parent (no old parent)
+ or removes a parent (no new parent) as well as moving between parents.
+============================================================================*/
+type reparentTaskCmd struct {
+    tMove      *Task
+    tOldParent *Task // parent to remove (nil = only add the new parent)
+    tNewParent *Task // parent to add (nil = only remove the old parent)
+    tOldBefore *Task // sibling the task was in front of under the old parent
+    sLog       string
+}
+
+func (rtc *reparentTaskCmd) Exec() error {
+    var err error
+    if rtc.tOldParent != nil {
+        rtc.tOldBefore = rtc.tMove.NextSibling(rtc.tOldParent)
+        err = rtc.tMove.RemoveParent(rtc.tOldParent)
+    }
+    if err == nil && rtc.tNewParent != nil {
+        err = rtc.tNewParent.AddChild(rtc.tMove)
+    }
+    if err == nil {
+        err = rtc.tMove.Save(false)
+    }
+    rtc.sLog = commandLog("EXEC-REPARENT", rtc.tMove.GetName(), err)
+    return err
+}
+
+func (rtc *reparentTaskCmd) Undo() error {
+    var err error
+    if rtc.tNewParent != nil {
+        err = rtc.tMove.RemoveParent(rtc.tNewParent)
+    }
+    if err == nil && rtc.tOldParent != nil {
+        rtc.tOldParent.AddChild(rtc.tMove)
+        if rtc.tOldBefore == nil || rtc.tOldParent.findChild(rtc.tOldBefore) != -1 {
+            err = rtc.tMove.MoveBefore(rtc.tOldParent, rtc.tOldBefore)
+        }
+    }
+    if err == nil {
+        err = rtc.tMove.Save(false)
+    }
+    rtc.sLog = commandLog("UNDO-REPARENT", rtc.tMove.GetName(), err)
+    return err
+}
+
+func (rtc *reparentTaskCmd) Log() string {
+    return rtc.sLog
+}
+
+func (rtc *reparentTaskCmd) Target() *Task {
+    return rtc.tMove
+}
+
+func newReparentTaskCmd(t *Task, tOldParent *Task, tNewParent *Task) *reparentTaskCmd {
+    return &reparentTaskCmd{tMove:t, tOldParent:tOldParent, tNewParent:tNewParent}
+}
+
+func CommandReparentTask(u *User, t *Task, tOldParent *Task, tNewParent *Task) error {
+    return CommandDo(u, newReparentTaskCmd(t, tOldParent, tNewParent))
+}
+
+
+/*
+==============================================================================
+ TagTaskCmd
+------------------------------------------------------------------------------
+ This command sets and resets tags on a task and allows for undo.  Like the
+ update API, if a tag is both set and reset then set "wins".  It keeps a copy
+ of the tags prior to the change so they can be restored exactly.
+============================================================================*/
+type tagTaskCmd struct {
+    tTag       *Task
+    setTags    []string
+    resetTags  []string
+    priorTags  []string
+    sLog       string
+}
+
+func (ttc *tagTaskCmd) Exec() error {
+    ttc.priorTags = ttc.tTag.GetTags()
+    for _, tag := range ttc.resetTags {
+        ttc.tTag.ResetTag(tag)
+    }
+    for _, tag := range ttc.setTags {
+        ttc.tTag.SetTag(tag)
+    }
+    err := ttc.tTag.Save(false)
+    ttc.sLog = commandLog("EXEC-TAG", ttc.tTag.GetName(), err)
+    return err
+}
+
+func (ttc *tagTaskCmd) Undo() error {
+    ttc.tTag.ClearTags()
+    for _, tag := range ttc.priorTags {
+        ttc.tTag.SetTag(tag)
+    }
+    err := ttc.tTag.Save(false)
+    ttc.sLog = commandLog("UNDO-TAG", ttc.tTag.GetName(), err)
+    return err
+}
+
+func (ttc *tagTaskCmd) Log() string {
+    return ttc.sLog
+}
+
+func (ttc *tagTaskCmd) Target() *Task {
+    return ttc.tTag
+}
+
+func newTagTaskCmd(t *Task, setTags []string, resetTags []string) *tagTaskCmd {
+    return &tagTaskCmd{tTag:t, setTags:setTags, resetTags:resetTags}
+}
+
+func CommandTagTask(u *User, t *Task, setTags []string, resetTags []string) error {
+    return CommandDo(u, newTagTaskCmd(t, setTags, resetTags))
+}
+
+
+/*
+==============================================================================
+ TimerTaskCmd
+------------------------------------------------------------------------------
+ This command starts or stops the timer on a task and allows for undo.  It
+ only touches the work session it started or stopped (found again by when
+ it started), so undoing it leaves the rest of the task - and any sessions
+ logged by others since - as they are.  Undoing the first session also
+ forgets when work on the task actually started.
+============================================================================*/
+type timerTaskCmd struct {
+    tTimer     *Task
+    user       *User     // user who starts the session
+    bStart     bool      // true to start the timer, false to stop it
+    tWhen      time.Time // when the timer is started or stopped
+    tSession   time.Time // start of the session started or stopped
+    sLog       string
+}
+
+// apply: start or stop the timer in memory
+func (ttc *timerTaskCmd) apply() error {
+    if ttc.bStart {
+        ttc.tSession = ttc.tWhen
+        return ttc.tTimer.StartTimer(ttc.user, ttc.tWhen)
+    }
+    if s := ttc.tTimer.RunningSession(); s != nil {
+        ttc.tSession = s.Start
+    }
+    return ttc.tTimer.StopTimer(ttc.tWhen)
+}
+
+// reverse: take back in memory what apply() did
+func (ttc *timerTaskCmd) reverse() error {
+    sessions := ttc.tTimer.GetSessions()
+    for i := len(sessions) - 1; i >= 0; i-- {
+        if !sessions[i].Start.Equal(ttc.tSession) {
+            continue
+        }
+        if ttc.bStart {
+            ttc.tTimer.SetSessions(append(sessions[:i:i], sessions[i+1:]...))
+            started := ttc.tTimer.GetActualStartTime()
+            if len(ttc.tTimer.GetSessions()) == 0 && started != nil && started.Equal(ttc.tSession) {
+                ttc.tTimer.SetActualStartTime(nil)
+            }
+            return nil
+        }
+        if i != len(sessions) - 1 {
+            return errors.New("pim-timer: timer was started again since")
+        }
+        sessions[i].Stop = nil
+        return nil
+    }
+    return errors.New("pim-timer: work session is no longer on the task")
+}
+
+func (ttc *timerTaskCmd) Exec() error {
+    err := ttc.apply()
+    if err == nil {
+        err = ttc.tTimer.Save(false)
+        if err != nil {
+            ttc.reverse() // keep memory matching what is stored
+        }
+    }
+    ttc.sLog = commandLog("EXEC-TIMER", ttc.context(), err)
+    return err
+}
+
+func (ttc *timerTaskCmd) Undo() error {
+    err := ttc.reverse()
+    if err == nil {
+        err = ttc.tTimer.Save(false)
+        if err != nil {
+            ttc.apply() // keep memory matching what is stored
+        }
+    }
+    ttc.sLog = commandLog("UNDO-TIMER", ttc.context(), err)
+    return err
+}
+
+func (ttc *timerTaskCmd) context() string {
+    if ttc.bStart {
+        return ttc.tTimer.GetName() + " (start)"
+    }
+    return ttc.tTimer.GetName() + " (stop)"
+}
+
+func (ttc *timerTaskCmd) Log() string {
+    return ttc.sLog
+}
+
+func (ttc *timerTaskCmd) Target() *Task {
+    return ttc.tTimer
+}
+
+func newTimerTaskCmd(u *User, t *Task, start bool, when time.Time) *timerTaskCmd {
+    return &timerTaskCmd{tTimer:t, user:u, bStart:start, tWhen:when}
+}
+
+func CommandTimerTask(u *User, t *Task, start bool, when time.Time) error {
+    return CommandDo(u, newTimerTaskCmd(u, t, start, when))
+}
+
+
+/*
+==============================================================================
+ CompositeCmd
+------------------------------------------------------------------------------
+ This command groups other commands into a single transaction so they are
+ executed, undone and redone as one unit (e.g. a bulk create or a drag and
+ drop that touches many tasks).  If any command fails during Exec() then
+ the commands already executed are undone so the transaction has no effect,
+ and if any fails during Undo() the commands already undone are executed
+ again so the transaction is left as it was.  Commands are undone in
+ reverse order.
+============================================================================*/
+type compositeCmd struct {
+    sName      string
+    cmds       []Command
+    sLog       string
+}
+
+func (cc *compositeCmd) Exec() error {
+    for i, cmd := range cc.cmds {
+        err := cmd.Exec()
+        if err != nil {
+            // roll back what we have done so far
+            for j := i - 1; j >= 0; j-- {
+                cc.cmds[j].Undo()
+                log.Print(cc.cmds[j].Log())
+            }
+            cc.sLog = commandLog("EXEC-" + cc.sName, commandTargetName(cmd), err)
+            return err
+        }
+        log.Print(cmd.Log())
+    }
+    cc.sLog = commandLog("EXEC-" + cc.sName, fmt.Sprintf("%d commands", len(cc.cmds)), nil)
+    return nil
+}
+
+func (cc *compositeCmd) Undo() error {
+    for i := len(cc.cmds) - 1; i >= 0; i-- {
+        err := cc.cmds[i].Undo()
+        log.Print(cc.cmds[i].Log())
+        if err != nil {
+            // redo what we have undone so far so the transaction is
+            // left whole rather than half undone
+            for j := i + 1; j < len(cc.cmds); j++ {
+                cc.cmds[j].Exec()
+                log.Print(cc.cmds[j].Log())
+            }
+            cc.sLog = commandLog("UNDO-" + cc.sName, commandTargetName(cc.cmds[i]), err)
+            return err
+        }
+    }
+    cc.sLog = commandLog("UNDO-" + cc.sName, fmt.Sprintf("%d commands", len(cc.cmds)), nil)
+    return nil
+}
+
+func (cc *compositeCmd) Log() string {
+    return cc.sLog
+}
+
+// the target of a transaction is the target of its first command
+func (cc *compositeCmd) Target() *Task {
+    if len(cc.cmds) == 0 {
+        return nil
+    }
+    return cc.cmds[0].Target()
+}
+
+// run a set of commands as a single transaction on the user's history
+// so that a single undo reverts all of them.  A transaction of one
+// command is simply that command.
+func CommandTransaction(u *User, name string, cmds ...Command) error {
+    return CommandDo(u, transaction(name, cmds))
+}
+
+// transaction: the command that runs cmds as one
+func transaction(name string, cmds []Command) Command {
+    if len(cmds) == 1 {
+        return cmds[0]
+    }
+    return &compositeCmd{sName:name, cmds:cmds}
 }
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// each user undoes and redoes only their own commands, and is told which
+// task was affected
+func TestUndoPerUser(t *testing.T) {
+	author, tdm := testRepository(t)
+	teammate, _ := NewUser("", "Teammate", "teammate@example.com", "Correct-Horse-9", tdm)
+	repo.SetUsers(Users{author, teammate})
+	undo := func(h http.HandlerFunc, u *User) (int, CmdJSON) {
+		var j CmdJSON
+		w := routeRequest(h, u, "POST", nil, "")
+		json.NewDecoder(w.Body).Decode(&j)
+		return w.Code, j
+	}
+
+	routeRequest(TaskCreate, author, "POST", nil, `{"name":"Author's task"}`)
+	routeRequest(TaskCreate, teammate, "POST", nil, `{"name":"Teammate's task"}`)
+
+	if _, j := undo(Undo, teammate); j.Status != 0 || j.TargetName != "Teammate's task" || j.TargetId == "" {
+		t.Fatal("Expected the teammate to undo their own task but got", j)
+	}
+	if childNames(repo.Master()) != "Author's task" {
+		t.Fatal("Expected the author's task to be left alone but found", childNames(repo.Master()))
+	}
+	if code, _ := undo(Undo, teammate); code != pimErr(undoEmpty).Response {
+		t.Error("Expected the teammate to have nothing left to undo but got", code)
+	}
+	if code, _ := undo(Redo, author); code != pimErr(redoEmpty).Response {
+		t.Error("Expected the author to have nothing to redo but got", code)
+	}
+
+	if _, j := undo(Redo, teammate); j.Status != 0 || j.TargetName != "Teammate's task" {
+		t.Fatal("Expected the teammate to redo their task but got", j)
+	}
+	if _, j := undo(Undo, author); j.Status != 0 || j.TargetName != "Author's task" {
+		t.Fatal("Expected the author to undo their own task but got", j)
+	}
+	if childNames(repo.Master()) != "Teammate's task" {
+		t.Error("Expected only the teammate's task to be left but found", childNames(repo.Master()))
+	}
+}
+
+// undoing or redoing an update fails rather than overwrite a newer change
+// to the task by someone else, but the user's own later changes are fine
+func TestUndoVersionConflict(t *testing.T) {
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(NewTaskDataMapperYAML(filepath.Join(t.TempDir(), "tasks.yaml")))
+	author := &User{id: "author"}
+	collaborator := &User{id: "collaborator"}
+	task := NewTask("Plan offsite")
+	master.AddChild(task)
+	rename := func(u *User, name string) {
+		cmd := CommandModifyTaskBegin(task)
+		task.SetName(name)
+		if err := CommandModifyTaskEnd(u, cmd, task); err != nil {
+			t.Fatal("Unable to rename task:", err)
+		}
+	}
+
+	rename(author, "Plan team offsite")
+	if err := CommandTagTask(author, task, []string{"today"}, nil); err != nil {
+		t.Fatal("Unable to tag task:", err)
+	}
+	rename(author, "Plan team offsite in May")
+	for i := 0; i < 3; i++ {
+		if _, err := CommandUndo(author); err != nil {
+			t.Fatal("Expected the author's own changes not to conflict but got", err)
+		}
+	}
+	if task.GetName() != "Plan offsite" || task.IsTagSet("today") {
+		t.Fatal("Expected all three changes to be undone but found", task.GetName(), task.GetTags())
+	}
+
+	// a redo over someone else's change conflicts too
+	rename(collaborator, "Plan offsite (venue booked)")
+	if _, err := CommandRedo(author); err == nil {
+		t.Fatal("Expected the redo to conflict with the collaborator's change")
+	} else if _, conflict := err.(*ErrVersionConflict); !conflict {
+		t.Fatal("Expected a version conflict but got", err)
+	}
+
+	rename(author, "Plan offsite (venue booked, catering)")
+	version := task.GetVersion()
+	rename(collaborator, "Plan offsite (venue and catering booked)")
+	cmd, err := CommandUndo(author)
+	if _, conflict := err.(*ErrVersionConflict); !conflict {
+		t.Fatal("Expected the undo to conflict with the collaborator's change but got", err)
+	}
+	if task.GetName() != "Plan offsite (venue and catering booked)" || task.GetVersion() != version+1 {
+		t.Error("Expected the collaborator's change to be kept but found", task.GetName(), task.GetVersion())
+	}
+	if !author.History().IsEmpty() {
+		t.Error("Expected the conflicting command to leave the history")
+	}
+
+	w := httptest.NewRecorder()
+	cmdResponse(w, "UNDO", cmd, err)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Error("Expected a 412 for the conflicting undo but got", w.Code)
+	}
+}
+
+// undoing an update puts back the fields it changed but not the work
+// sessions, which someone else may have logged since (here before a
+// restart, after which their change no longer conflicts)
+func TestUndoKeepsSessions(t *testing.T) {
+	mapper := newJournalMapper(t)
+	master := NewTaskMemoryOnly("Your Task List")
+	master.SetDataMapper(mapper.TaskDataMapperYAML)
+	author := &User{id: "author", persist: mapper}
+	collaborator := &User{id: "collaborator"}
+	start := time.Date(2026, time.October, 17, 9, 0, 0, 0, time.UTC)
+
+	task := NewTask("Write report")
+	master.AddChild(task)
+	cmd := CommandModifyTaskBegin(task)
+	task.SetName("Write quarterly report")
+	if err := CommandModifyTaskEnd(author, cmd, task); err != nil {
+		t.Fatal("Unable to update task:", err)
+	}
+	if err := CommandTimerTask(collaborator, task, true, start); err != nil {
+		t.Fatal("Unable to start timer:", err)
+	}
+	if err := CommandTimerTask(collaborator, task, false, start.Add(time.Hour)); err != nil {
+		t.Fatal("Unable to stop timer:", err)
+	}
+
+	restartHistory(t, author, master)
+	if _, err := CommandUndo(author); err != nil {
+		t.Fatal("Unable to undo update:", err)
+	}
+	if task.GetName() != "Write report" {
+		t.Error("Expected the update to be undone but found", task.GetName())
+	}
+	if task.TimeSpent(start) != time.Hour {
+		t.Error("Expected the collaborator's hour to be kept but found", task.TimeSpent(start))
+	}
+
+	// the timer commands take back only their own session
+	if _, err := CommandUndo(collaborator); err != nil || task.RunningSession() == nil {
+		t.Fatal("Expected undoing the stop to restart the timer", err)
+	}
+	if _, err := CommandUndo(collaborator); err != nil || len(task.GetSessions()) != 0 || task.GetActualStartTime() != nil {
+		t.Error("Expected undoing the start to remove the session", err)
+	}
+}
+
+// one undo takes back a whole bulk create or a drag and drop reorder, and
+// one redo puts it back
+func TestUndoBulkCreateAndReorder(t *testing.T) {
+	u, _ := testRepository(t)
+	master := repo.Master()
+
+	w := routeRequest(TaskCreate, u, "POST", nil, `[{"name":"a"},{"name":"b"},{"name":"c"}]`)
+	if w.Code != http.StatusCreated || childNames(master) != "a,b,c" {
+		t.Fatal("Expected three tasks to be created but got", w.Code, childNames(master))
+	}
+	if _, err := CommandUndo(u); err != nil || childNames(master) != "" {
+		t.Fatal("Expected one undo to take back the whole bulk create but found", childNames(master), err)
+	}
+	if _, err := CommandRedo(u); err != nil || childNames(master) != "a,b,c" {
+		t.Fatal("Expected one redo to create all three again but found", childNames(master), err)
+	}
+
+	a, c := master.kids[0], master.kids[2]
+	reorder := func(body string) int {
+		return routeRequest(TaskReorder, u, "PATCH", map[string]string{"taskId": c.GetId()}, body).Code
+	}
+	if code := reorder(`{"before":"` + c.GetId() + `"}`); code != http.StatusUnprocessableEntity {
+		t.Error("Expected moving a task next to itself to be refused but got", code)
+	}
+	if code := reorder(`{"before":"` + a.GetId() + `"}`); code != http.StatusOK || childNames(master) != "c,a,b" {
+		t.Fatal("Expected the task to move to the front but got", code, childNames(master))
+	}
+	if _, err := CommandUndo(u); err != nil || childNames(master) != "a,b,c" {
+		t.Error("Expected one undo to take back the reorder but found", childNames(master), err)
+	}
+	if _, err := CommandUndo(u); err != nil || childNames(master) != "" {
+		t.Error("Expected the next undo to take back the bulk create but found", childNames(master), err)
+	}
+}
+
+// failingUndoCmd: a command that can't be undone, to make a transaction
+// fail partway through its undo
+type failingUndoCmd struct{}
+
+func (c *failingUndoCmd) Exec() error            { return nil }
+func (c *failingUndoCmd) Undo() error            { return errors.New("unable to undo") }
+func (c *failingUndoCmd) Log() string            { return "" }
+func (c *failingUndoCmd) Target() *Task          { return nil }
+func (c *failingUndoCmd) Record() *commandRecord { return &commandRecord{Kind: "failing"} }
+
+// a transaction whose undo fails partway through puts back what it had
+// already undone rather than leave the tasks half reverted
+func TestUndoTransactionRollback(t *testing.T) {
+	u, _ := testRepository(t)
+	master := repo.Master()
+	var cmds []Command
+	for _, name := range []string{"a", "b", "c"} {
+		task := NewTask(name)
+		task.AddUser(u)
+		master.AddChild(task)
+		cmds = append(cmds, newCreateTaskCmd(task))
+		if name == "a" {
+			cmds = append(cmds, &failingUndoCmd{})
+		}
+	}
+	if err := CommandTransaction(u, "CREATE", cmds...); err != nil {
+		t.Fatal("Unable to create tasks:", err)
+	}
+
+	if _, err := CommandUndo(u); err == nil {
+		t.Fatal("Expected the undo to fail")
+	}
+	if childNames(master) != "a,b,c" {
+		t.Error("Expected the failed undo to leave every task created but found", childNames(master))
+	}
+}
@@ -23,8 +23,7 @@ import (
  authenticate access tokens.
 -----------------------------------------------------------------------------*/
 
-// Create the JWT key used to create the signature
-var jwtKey = []byte("pim_secret_key_to_be_more_secure")
+// the keys used to create and check the signature are in jwtkeys.go
 
 // set the expiration timeout on any created tokens
 const jwtTimeout = (5 * time.Minute)
@@ -50,11 +49,9 @@ func UserGetAuthToken(username string, expirationTime time.Time) (string, error)
       },
    }
 
-   // declare the token with the algorithm used for signing, and the claims
-   token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-   // Create the JWT string or any error generated
-   return token.SignedString(jwtKey)
+   // sign the token with the current signing key, returning the JWT
+   // string or any error generated
+   return jwtKeys.sign(claims)
 }
 
 func UserValidateAuthToken(tknStr string) (string, PimErrId) {
@@ -63,12 +60,10 @@ func UserValidateAuthToken(tknStr string) (string, PimErrId) {
    claims := &Claims{}
 
    // Parse the JWT string and store the result in `claims`.
-   // Note that we are passing the key in this method as well. This method will return an error
-   // if the token is invalid (if it has expired according to the expiry time we set on sign in),
-   // or if the signature does not match
-   tkn, err := jwt.ParseWithClaims(tknStr, claims, func(token *jwt.Token) (interface{}, error) {
-     return jwtKey, nil
-   })
+   // Note that we are passing the key ring in this method as well to find the key the token
+   // names. This method will return an error if the token is invalid (if it has expired
+   // according to the expiry time we set on sign in), or if the signature does not match
+   tkn, err := jwt.ParseWithClaims(tknStr, claims, jwtKeys.keyFunc)
    if err != nil {
       if err == jwt.ErrSignatureInvalid {
           // w.WriteHeader(http.StatusUnauthorized)
@@ -101,9 +96,15 @@ type User struct {
    email string     // email address of the user
    password []byte  // encrypted password
    persist TaskDataMapper // interface to store the user
-
+   history *commandHistory // this user's undo / redo stacks (created on first use)
+   capacity []time.Duration // working time available each weekday, Sunday first (nil = default)
+   location *time.Location  // time zone the user's days start and end in (nil = server's)
+   weekStart time.Weekday   // first day of the user's week
 }
 
+// unless a user says otherwise they work 8 hours a day Monday to Friday
+var defaultCapacity = []time.Duration{0, 8 * time.Hour, 8 * time.Hour, 8 * time.Hour, 8 * time.Hour, 8 * time.Hour, 0}
+
 // Create a struct to read the username and password from a request body
 type UserCredentials struct {
     Password string `json:"password"`
@@ -201,7 +202,13 @@ func (u *User) GetPassword() string {
 }
 
 func (u *User) CheckPassword(presented string) bool {
-  err := bcrypt.CompareHashAndPassword(u.password, []byte(presented))
+  return passwordMatches(u.GetPassword(), presented)
+}
+
+// passwordMatches: true if the presented password hashes to hashedPassword
+// (so a password can be checked without holding the repository lock)
+func passwordMatches(hashedPassword string, presented string) bool {
+  err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(presented))
   return err == nil
 }
 
@@ -209,6 +216,108 @@ func (u *User) Save() error {
   return u.persist.UserSave(u)
 }
 
+// Capacity: how much working time the user has on each day of the week -
+// used to plan whether the tasks for a day or week fit in it
+func (u *User) GetCapacity(day time.Weekday) time.Duration {
+  if u.capacity == nil {
+    return defaultCapacity[day]
+  }
+  return u.capacity[day]
+}
+func (u *User) SetCapacity(day time.Weekday, capacity time.Duration) {
+  if u.capacity == nil {
+    u.capacity = append([]time.Duration(nil), defaultCapacity...)
+  }
+  u.capacity[day] = capacity
+}
+
+// CapacityMinutes: capacity of each weekday in minutes, Sunday first, or
+// nil if the user has the default capacity - the form the mappers store
+func (u *User) GetCapacityMinutes() []int {
+  if u.capacity == nil {
+    return nil
+  }
+  minutes := make([]int, len(u.capacity))
+  for i, c := range u.capacity {
+    minutes[i] = int(c.Minutes())
+  }
+  return minutes
+}
+func (u *User) SetCapacityMinutes(minutes []int) error {
+  if minutes == nil {
+    u.capacity = nil
+    return nil
+  }
+  if len(minutes) != 7 {
+    return errors.New("capacity needs minutes for each of the 7 days of the week")
+  }
+  capacity := make([]time.Duration, 7)
+  for i, m := range minutes {
+    if m < 0 || m > 24 * 60 {
+      return errors.New("capacity of a day must be between 0 and 24 hours")
+    }
+    capacity[i] = time.Duration(m) * time.Minute
+  }
+  u.capacity = capacity
+  return nil
+}
+
+// GetTimeZone: the IANA name of the time zone the user's days start and end
+// in (e.g. "America/New_York"), or "" to use the server's
+func (u *User) GetTimeZone() string {
+  if u.location == nil {
+    return ""
+  }
+  return u.location.String()
+}
+func (u *User) SetTimeZone(name string) error {
+  if name == "" {
+    u.location = nil
+    return nil
+  }
+  loc, err := time.LoadLocation(name)
+  if err != nil {
+    return errors.New(fmt.Sprintf("unknown time zone <%s>", name))
+  }
+  u.location = loc
+  return nil
+}
+
+// Location: the time zone the user's days start and end in
+func (u *User) Location() *time.Location {
+  if u.location == nil {
+    return time.Local
+  }
+  return u.location
+}
+
+// Now: the current time where the user is - what "today" and "this
+// week" are measured from
+func (u *User) Now() time.Time {
+  return time.Now().In(u.Location())
+}
+
+// GetWeekStart: the first day of the user's week (Sunday unless they say)
+func (u *User) GetWeekStart() time.Weekday {
+  return u.weekStart
+}
+func (u *User) SetWeekStart(day time.Weekday) error {
+  if day < time.Sunday || day > time.Saturday {
+    return errors.New(fmt.Sprintf("week cannot start on day %d", day))
+  }
+  u.weekStart = day
+  return nil
+}
+
+// History returns the command history of this user, creating an
+// empty one the first time it is needed.
+func (u *User) History() *commandHistory {
+  if u.history == nil {
+    u.history = new(commandHistory)
+  }
+  return u.history
+}
+
 /*
 ===============================================================================
  Users
@@ -298,15 +407,15 @@ func userCheckAuthToken(w http.ResponseWriter, r *http.Request) *User {
     var username string
     var errCode  PimErrId
     username, errCode = UserValidateAuthToken(tknStr)
-    if (err != nil) {
+    if errCode != success {
         errorResponse(w, pimErr(errCode)) // this may need to be typed to pimErr
         return nil
     }
 
     // now look up the user in our user list and make sure it is there
-    user := users.FindByEmail(username)
+    user := repo.Users().FindByEmail(username)
     if user == nil { // note this error is unlikely since user was in valid token
-        log.Printf("userCheckAuthToken() - suspicious activity - valid token with invalid user <%s>\n")
+        log.Printf("userCheckAuthToken() - suspicious activity - valid token with invalid user <%s>\n", username)
         errorResponse(w, pimErr(authFail))
         return nil // not strictly needed, but return here for clarity
     }
@@ -321,15 +430,15 @@ func userCheckAuthToken(w http.ResponseWriter, r *http.Request) *User {
 ------------------------------------------------------------------------------
  Inputs: w        ResponseWriter - response to write results / cookie into
          username string         - username whose password is already ok-ed
-
- Errors: This function returns no errors.  It either writes an error to the
-         response or it sets the cookie and assumes its caller doesn't care.
+ Returns:         bool           - false if a response with an error was
+                                   written, true if the cookie was set
 
  Takes care of the "http" level of signing in with an authentication token.
  This function also decides on the length of the token (TBD move into JWT
- code somehow).
+ code somehow).  The token is short-lived - see session.go for how it is
+ refreshed.
 ============================================================================*/
-func UserSetAuthToken(w http.ResponseWriter, username string) {
+func UserSetAuthToken(w http.ResponseWriter, username string) bool {
 
     // get a JWT token for this user that expires in the time specified
     expirationTime := time.Now().Add(jwtTimeout)
@@ -337,16 +446,13 @@ func UserSetAuthToken(w http.ResponseWriter, username string) {
     if err != nil {
         // if there is an error in creating the JWT return an internal server error
         errorResponse(w, pimErr(authErr))        
-        return
+        return false
     }
 
     // set the client cookie for "token" as the JWT we just generated
     // we also set an expiry time which is the same as the token itself
-    http.SetCookie(w, &http.Cookie{
-        Name:    "token",
-        Value:   tokenString,
-        Expires: expirationTime,
-    })    
+    http.SetCookie(w, authCookie("token", tokenString, expirationTime))
+    return true
 }
 
 /*
@@ -41,4 +41,15 @@ func removeStringByValue(s []string, valToRemove string) []string {
 		return removeStringByIndex(s, idxToRemove)
 	}
 	return s
-}
\ No newline at end of file
+}
+func stringSlicesEqual(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}